@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sakuraapp/gateway/pkg/gatewaypb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type session struct {
+	Id          string    `json:"id"`
+	UserId      int32     `json:"user_id"`
+	RoomId      int32     `json:"room_id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+	LastActive  time.Time `json:"last_active"`
+}
+
+type room struct {
+	Id      int32 `json:"id"`
+	Clients int   `json:"clients"`
+}
+
+type node struct {
+	Id          string    `json:"id"`
+	Hostname    string    `json:"hostname"`
+	StartedAt   time.Time `json:"started_at"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+	Clients     int       `json:"clients"`
+	Rooms       int       `json:"rooms"`
+	Draining    bool      `json:"draining"`
+}
+
+type broadcastRequest struct {
+	RoomId  int32  `json:"room_id"`
+	Message string `json:"message"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// adminClient calls a node's admin api
+type adminClient struct {
+	baseUrl string
+	token   string
+	http    *http.Client
+}
+
+func newAdminClient(baseUrl string, token string) *adminClient {
+	return &adminClient{
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		token:   token,
+		http:    &http.Client{},
+	}
+}
+
+func (c *adminClient) do(ctx context.Context, method string, path string, body interface{}, res interface{}) error {
+	if c.token == "" {
+		return errors.New("no admin token given (-token or GATEWAY_ADMIN_TOKEN)")
+	}
+
+	var reader io.Reader
+
+	if body != nil {
+		b, err := json.Marshal(body)
+
+		if err != nil {
+			return err
+		}
+
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseUrl+path, reader)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var apiErr apiError
+
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%v: %v", resp.Status, apiErr.Error)
+		}
+
+		return errors.New(resp.Status)
+	}
+
+	if res == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+func (c *adminClient) sessions(ctx context.Context) ([]*session, error) {
+	var res []*session
+
+	err := c.do(ctx, http.MethodGet, "/admin/sessions", nil, &res)
+
+	return res, err
+}
+
+func (c *adminClient) kickSession(ctx context.Context, sessionId string) error {
+	return c.do(ctx, http.MethodDelete, "/admin/sessions/"+sessionId, nil, nil)
+}
+
+func (c *adminClient) disconnectUser(ctx context.Context, userId int32) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/admin/users/%v", userId), nil, nil)
+}
+
+func (c *adminClient) rooms(ctx context.Context) ([]*room, error) {
+	var res []*room
+
+	err := c.do(ctx, http.MethodGet, "/admin/rooms", nil, &res)
+
+	return res, err
+}
+
+func (c *adminClient) closeRoom(ctx context.Context, roomId int32) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/admin/rooms/%v", roomId), nil, nil)
+}
+
+// broadcast sends a system notification to a room, or to everyone when roomId is 0
+func (c *adminClient) broadcast(ctx context.Context, roomId int32, msg string) error {
+	return c.do(ctx, http.MethodPost, "/admin/broadcast", &broadcastRequest{RoomId: roomId, Message: msg}, nil)
+}
+
+func (c *adminClient) nodes(ctx context.Context) ([]*node, error) {
+	var res []*node
+
+	err := c.do(ctx, http.MethodGet, "/admin/nodes", nil, &res)
+
+	return res, err
+}
+
+func (c *adminClient) drain(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/admin/drain", nil, nil)
+}
+
+func (c *adminClient) config(ctx context.Context) (map[string]interface{}, error) {
+	var res map[string]interface{}
+
+	err := c.do(ctx, http.MethodGet, "/admin/config", nil, &res)
+
+	return res, err
+}
+
+func (c *adminClient) overrides(ctx context.Context) (map[string]interface{}, error) {
+	var res map[string]interface{}
+
+	err := c.do(ctx, http.MethodGet, "/admin/config/overrides", nil, &res)
+
+	return res, err
+}
+
+// setOverrides replaces the runtime overrides of every node, nil removes them
+func (c *adminClient) setOverrides(ctx context.Context, overrides map[string]interface{}) error {
+	if overrides == nil {
+		return c.do(ctx, http.MethodDelete, "/admin/config/overrides", nil, nil)
+	}
+
+	return c.do(ctx, http.MethodPut, "/admin/config/overrides", overrides, nil)
+}
+
+func (c *adminClient) reloadConfig(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/admin/config/reload", nil, nil)
+}
+
+func grpcCredentials(opts *options) (credentials.TransportCredentials, error) {
+	if opts.grpcInsecure {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if opts.grpcCAPath != "" {
+		ca, err := os.ReadFile(opts.grpcCAPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %v", opts.grpcCAPath)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.grpcCertPath != "" {
+		cert, err := tls.LoadX509KeyPair(opts.grpcCertPath, opts.grpcKeyPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+func dialGrpc(opts *options) (gatewaypb.GatewayServiceClient, *grpc.ClientConn, error) {
+	creds, err := grpcCredentials(opts)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := grpc.Dial(opts.grpcAddr, grpc.WithTransportCredentials(creds))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return gatewaypb.NewGatewayServiceClient(conn), conn, nil
+}
+
+func getRoomState(ctx context.Context, client gatewaypb.GatewayServiceClient, roomId int32) (*gatewaypb.GetRoomStateResponse, error) {
+	return client.GetRoomState(ctx, &gatewaypb.GetRoomStateRequest{RoomId: roomId})
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const usage = `gatewayctl talks to a gateway node's admin api & gRPC server
+
+Usage:
+  gatewayctl [flags] <command> [args]
+
+Commands:
+  nodes list                      list the live nodes (from the node registry)
+  node drain                      drain the node & shut it down
+  sessions list                   list the sessions connected to the node
+  session kick <session id>       disconnect a session from the node
+  user disconnect <user id>       disconnect every session of a user, on every node
+  rooms list                      list the rooms that have clients on the node
+  room inspect <room id>          show a room's current item, player state, queue & members
+  room broadcast <room id> <msg>  send a system notification to a room
+  room close <room id>            close a room, on every node
+  broadcast <msg>                 send a system notification to everyone
+  config show                     show the node's running config, without its secrets
+  config overrides                show the runtime overrides shared by every node
+  config override <json>          replace the runtime overrides, e.g. '{"log_level": "warn"}'
+  config reset                    remove the runtime overrides
+  config reload                   read the node's config file again, like a SIGHUP
+
+Flags:
+`
+
+type options struct {
+	adminUrl     string
+	adminToken   string
+	grpcAddr     string
+	grpcCAPath   string
+	grpcCertPath string
+	grpcKeyPath  string
+	grpcInsecure bool
+	output       string
+	timeout      time.Duration
+}
+
+func main() {
+	var opts options
+
+	flag.StringVar(&opts.adminUrl, "admin", getEnv("GATEWAY_ADMIN_URL", "http://localhost:9090"), "admin server url")
+	flag.StringVar(&opts.adminToken, "token", os.Getenv("GATEWAY_ADMIN_TOKEN"), "admin api token")
+	flag.StringVar(&opts.grpcAddr, "grpc", getEnv("GATEWAY_GRPC_ADDR", "localhost:9001"), "gRPC server address (host:port or unix:///path)")
+	flag.StringVar(&opts.grpcCAPath, "grpc-ca", os.Getenv("GATEWAY_GRPC_CA"), "CA certificate used to verify the gRPC server")
+	flag.StringVar(&opts.grpcCertPath, "grpc-cert", os.Getenv("GATEWAY_GRPC_CERT"), "client certificate, when the server requires mutual TLS")
+	flag.StringVar(&opts.grpcKeyPath, "grpc-key", os.Getenv("GATEWAY_GRPC_KEY"), "client key, when the server requires mutual TLS")
+	flag.BoolVar(&opts.grpcInsecure, "grpc-insecure", os.Getenv("GATEWAY_GRPC_INSECURE") == "1", "connect to the gRPC server without TLS")
+	flag.StringVar(&opts.output, "o", "table", "output format: table or json")
+	flag.DurationVar(&opts.timeout, "timeout", 10*time.Second, "timeout of each call")
+
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if opts.output != "table" && opts.output != "json" {
+		fail(fmt.Errorf("unsupported output format: %v", opts.output))
+	}
+
+	args := flag.Args()
+
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+
+	err := run(ctx, &opts, args)
+
+	if err != nil {
+		fail(err)
+	}
+}
+
+func run(ctx context.Context, opts *options, args []string) error {
+	admin := newAdminClient(opts.adminUrl, opts.adminToken)
+	out := newPrinter(opts.output)
+
+	command := strings.Join(args[:min(2, len(args))], " ")
+
+	switch command {
+	case "nodes list":
+		nodes, err := admin.nodes(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		return out.nodes(nodes)
+	case "node drain":
+		err := admin.drain(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		return out.done("node is draining")
+	case "sessions list":
+		sessions, err := admin.sessions(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		return out.sessions(sessions)
+	case "session kick":
+		sessionId, err := stringArg(args, 2, "session id")
+
+		if err != nil {
+			return err
+		}
+
+		err = admin.kickSession(ctx, sessionId)
+
+		if err != nil {
+			return err
+		}
+
+		return out.done("session disconnected")
+	case "user disconnect":
+		userId, err := intArg(args, 2, "user id")
+
+		if err != nil {
+			return err
+		}
+
+		err = admin.disconnectUser(ctx, userId)
+
+		if err != nil {
+			return err
+		}
+
+		return out.done("user disconnected")
+	case "rooms list":
+		rooms, err := admin.rooms(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		return out.rooms(rooms)
+	case "room inspect":
+		roomId, err := intArg(args, 2, "room id")
+
+		if err != nil {
+			return err
+		}
+
+		gateway, conn, err := dialGrpc(opts)
+
+		if err != nil {
+			return err
+		}
+
+		defer conn.Close()
+
+		state, err := getRoomState(ctx, gateway, roomId)
+
+		if err != nil {
+			return err
+		}
+
+		return out.roomState(state)
+	case "room broadcast":
+		roomId, err := intArg(args, 2, "room id")
+
+		if err != nil {
+			return err
+		}
+
+		msg, err := stringArg(args, 3, "message")
+
+		if err != nil {
+			return err
+		}
+
+		err = admin.broadcast(ctx, roomId, strings.Join(args[3:], " "))
+
+		if err != nil {
+			return err
+		}
+
+		return out.done(fmt.Sprintf("sent %q", msg))
+	case "room close":
+		roomId, err := intArg(args, 2, "room id")
+
+		if err != nil {
+			return err
+		}
+
+		err = admin.closeRoom(ctx, roomId)
+
+		if err != nil {
+			return err
+		}
+
+		return out.done("room closed")
+	case "config show", "config overrides":
+		get := admin.config
+
+		if command == "config overrides" {
+			get = admin.overrides
+		}
+
+		conf, err := get(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		// nested settings don't fit in a table
+		return out.writeJSON(conf)
+	case "config override":
+		str, err := stringArg(args, 2, "overrides")
+
+		if err != nil {
+			return err
+		}
+
+		var overrides map[string]interface{}
+
+		err = json.Unmarshal([]byte(str), &overrides)
+
+		if err != nil {
+			return fmt.Errorf("invalid overrides: %v", err)
+		}
+
+		err = admin.setOverrides(ctx, overrides)
+
+		if err != nil {
+			return err
+		}
+
+		return out.done("overrides applied to every node")
+	case "config reset":
+		err := admin.setOverrides(ctx, nil)
+
+		if err != nil {
+			return err
+		}
+
+		return out.done("overrides removed")
+	case "config reload":
+		err := admin.reloadConfig(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		return out.done("config reloaded")
+	}
+
+	if args[0] == "broadcast" {
+		_, err := stringArg(args, 1, "message")
+
+		if err != nil {
+			return err
+		}
+
+		err = admin.broadcast(ctx, 0, strings.Join(args[1:], " "))
+
+		if err != nil {
+			return err
+		}
+
+		return out.done("sent to everyone")
+	}
+
+	return fmt.Errorf("unknown command: %v (see gatewayctl -h)", command)
+}
+
+func stringArg(args []string, i int, name string) (string, error) {
+	if len(args) <= i || args[i] == "" {
+		return "", fmt.Errorf("missing %v", name)
+	}
+
+	return args[i], nil
+}
+
+func intArg(args []string, i int, name string) (int32, error) {
+	str, err := stringArg(args, i, name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.ParseInt(str, 10, 32)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v: %v", name, str)
+	}
+
+	return int32(n), nil
+}
+
+func getEnv(key string, fallback string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+
+	return fallback
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	os.Exit(1)
+}
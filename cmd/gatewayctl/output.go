@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sakuraapp/gateway/pkg/gatewaypb"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// printer writes results either as aligned tables or as json, for scripts
+type printer struct {
+	json bool
+	w    io.Writer
+}
+
+func newPrinter(format string) *printer {
+	return &printer{
+		json: format == "json",
+		w:    os.Stdout,
+	}
+}
+
+func (p *printer) writeJSON(v interface{}) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
+
+func (p *printer) table(header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+func (p *printer) done(msg string) error {
+	if p.json {
+		return p.writeJSON(map[string]interface{}{"ok": true})
+	}
+
+	_, err := fmt.Fprintln(p.w, msg)
+
+	return err
+}
+
+func (p *printer) nodes(nodes []*node) error {
+	if p.json {
+		return p.writeJSON(nodes)
+	}
+
+	rows := make([][]string, 0, len(nodes))
+
+	for _, n := range nodes {
+		status := "ready"
+
+		if n.Draining {
+			status = "draining"
+		}
+
+		rows = append(rows, []string{
+			n.Id,
+			n.Hostname,
+			status,
+			fmt.Sprint(n.Clients),
+			fmt.Sprint(n.Rooms),
+			uptime(n.StartedAt),
+			since(n.HeartbeatAt),
+		})
+	}
+
+	return p.table([]string{"ID", "HOSTNAME", "STATUS", "CLIENTS", "ROOMS", "UPTIME", "LAST HEARTBEAT"}, rows)
+}
+
+func (p *printer) sessions(sessions []*session) error {
+	if p.json {
+		return p.writeJSON(sessions)
+	}
+
+	rows := make([][]string, 0, len(sessions))
+
+	for _, s := range sessions {
+		rows = append(rows, []string{
+			s.Id,
+			optionalId(s.UserId),
+			optionalId(s.RoomId),
+			s.RemoteAddr,
+			since(s.ConnectedAt),
+			since(s.LastActive),
+		})
+	}
+
+	return p.table([]string{"ID", "USER", "ROOM", "REMOTE ADDR", "CONNECTED", "LAST ACTIVE"}, rows)
+}
+
+func (p *printer) rooms(rooms []*room) error {
+	if p.json {
+		return p.writeJSON(rooms)
+	}
+
+	rows := make([][]string, 0, len(rooms))
+
+	for _, r := range rooms {
+		rows = append(rows, []string{fmt.Sprint(r.Id), fmt.Sprint(r.Clients)})
+	}
+
+	return p.table([]string{"ID", "CLIENTS"}, rows)
+}
+
+func (p *printer) roomState(state *gatewaypb.GetRoomStateResponse) error {
+	if p.json {
+		return p.writeJSON(state)
+	}
+
+	fmt.Fprintln(p.w, "Current item:")
+
+	if state.CurrentItem != nil {
+		p.items([]*gatewaypb.MediaItem{state.CurrentItem})
+	} else {
+		fmt.Fprintln(p.w, "  (none)")
+	}
+
+	fmt.Fprintln(p.w, "\nPlayer state:")
+
+	if ps := state.PlayerState; ps != nil {
+		playing := "paused"
+
+		if ps.Playing {
+			playing = "playing"
+		}
+
+		fmt.Fprintf(p.w, "  %v at %v\n", playing, time.Duration(ps.CurrentTime*float64(time.Second)).Round(time.Second))
+	} else {
+		fmt.Fprintln(p.w, "  (none)")
+	}
+
+	fmt.Fprintf(p.w, "\nQueue (%v):\n", len(state.Queue))
+
+	if len(state.Queue) > 0 {
+		p.items(state.Queue)
+	}
+
+	fmt.Fprintf(p.w, "\nMembers (%v):\n", len(state.Members))
+
+	if len(state.Members) == 0 {
+		return nil
+	}
+
+	rows := make([][]string, 0, len(state.Members))
+
+	for _, m := range state.Members {
+		if m.User == nil {
+			continue
+		}
+
+		roles := make([]string, 0, len(m.Roles))
+
+		for _, role := range m.Roles {
+			roles = append(roles, fmt.Sprint(role))
+		}
+
+		rows = append(rows, []string{
+			"  " + fmt.Sprint(m.User.Id),
+			m.User.Username + "#" + m.User.Discriminator,
+			strings.Join(roles, ","),
+		})
+	}
+
+	return p.table([]string{"  ID", "USER", "ROLES"}, rows)
+}
+
+func (p *printer) items(items []*gatewaypb.MediaItem) {
+	rows := make([][]string, 0, len(items))
+
+	for _, item := range items {
+		rows = append(rows, []string{"  " + item.Id, item.Title, item.Url, fmt.Sprint(item.Author)})
+	}
+
+	p.table([]string{"  ID", "TITLE", "URL", "AUTHOR"}, rows)
+}
+
+func optionalId(id int32) string {
+	if id == 0 {
+		return "-"
+	}
+
+	return fmt.Sprint(id)
+}
+
+func uptime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return time.Since(t).Round(time.Second).String()
+}
+
+func since(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return uptime(t) + " ago"
+}
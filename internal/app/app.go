@@ -18,6 +18,7 @@ import (
 
 type App interface {
 	pubsub.Dispatcher
+	DispatchToContext(ctx context.Context, target pubsub.MessageTarget, message interface{}) error
 	Context() context.Context
 	NodeId() string
 	GetConfig() *config.Config
@@ -26,7 +27,7 @@ type App interface {
 	GetJWT() *util.JWT
 	GetDB() *pg.DB
 	GetRepos() *repository.Repositories
-	GetRedis() *redis.Client
+	GetRedis() redis.UniversalClient
 	GetCache() *cache.Cache
 	GetHandlerMgr() *manager.HandlerManager
 	GetClientMgr() *manager.ClientManager
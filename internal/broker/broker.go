@@ -0,0 +1,37 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"github.com/sakuraapp/pubsub"
+)
+
+var ErrClosed = errors.New("broker: closed")
+
+// Message is a raw message received from a broker, along with the topic it was published on
+type Message struct {
+	Topic   string
+	Payload []byte
+}
+
+// Broker is the transport used to exchange messages between nodes
+// messages are always serialized the same way (msgpack) so that they're decoded identically regardless of the backend
+type Broker interface {
+	pubsub.Dispatcher
+	pubsub.Client
+	// Receive blocks until a message arrives on one of the subscribed topics
+	Receive(ctx context.Context) (*Message, error)
+	// Resubscribe subscribes to every topic the broker is supposed to be subscribed to again, after the connection was lost
+	Resubscribe(ctx context.Context) error
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+type Backend string
+
+// Backend only selects how messages are passed between nodes, the room state, queues, sessions & event logs are stored in redis regardless
+const (
+	BackendRedis  Backend = "redis"
+	BackendMemory Backend = "memory" // messages never leave the process, so every node must be alone (it still needs redis)
+	BackendNATS   Backend = "nats"
+)
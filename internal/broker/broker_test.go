@@ -0,0 +1,461 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/vmihailenco/msgpack/v5"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testTimeout = 5 * time.Second
+
+// backend runs the server behind a broker, every broker it creates acts as a separate node
+type backend struct {
+	newBroker func(t *testing.T) Broker
+	interrupt func(t *testing.T) // drops the connections to the server, nil if it can't happen
+	restore   func(t *testing.T)
+}
+
+var backends = []struct {
+	name  string
+	setup func(t *testing.T) *backend
+}{
+	{"memory", newMemoryBackend},
+	{"redis", newRedisBackend},
+	{"nats", newNATSBackend},
+}
+
+func newMemoryBackend(t *testing.T) *backend {
+	bus := NewMemoryBus()
+
+	return &backend{
+		newBroker: func(t *testing.T) Broker {
+			return NewMemory(bus)
+		},
+	}
+}
+
+func newRedisBackend(t *testing.T) *backend {
+	srv := miniredis.RunT(t)
+
+	return &backend{
+		newBroker: func(t *testing.T) Broker {
+			rdb := redis.NewClient(&redis.Options{
+				Addr:       srv.Addr(),
+				MaxRetries: -1,
+			})
+			t.Cleanup(func() { _ = rdb.Close() })
+
+			return NewRedis(context.Background(), rdb)
+		},
+		interrupt: func(t *testing.T) {
+			srv.Close()
+		},
+		restore: func(t *testing.T) {
+			err := srv.Restart()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+}
+
+func newNATSBackend(t *testing.T) *backend {
+	opts := &server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true}
+
+	start := func(t *testing.T) *server.Server {
+		srv, err := server.NewServer(opts)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go srv.Start()
+
+		if !srv.ReadyForConnections(testTimeout) {
+			t.Fatal("nats server didn't start")
+		}
+
+		return srv
+	}
+
+	srv := start(t)
+	url := srv.ClientURL()
+	opts.Port = srv.Addr().(*net.TCPAddr).Port // restarted on the same port
+
+	t.Cleanup(func() { srv.Shutdown() })
+
+	return &backend{
+		newBroker: func(t *testing.T) Broker {
+			b, err := NewNATS(url, nats.ReconnectWait(50*time.Millisecond), nats.MaxReconnects(-1))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return b
+		},
+		interrupt: func(t *testing.T) {
+			srv.Shutdown()
+			srv.WaitForShutdown()
+		},
+		restore: func(t *testing.T) {
+			srv = start(t)
+		},
+	}
+}
+
+type testMessage struct {
+	N int `msgpack:"n"`
+}
+
+func decode(t *testing.T, msg *Message) int {
+	var m testMessage
+
+	err := msgpack.Unmarshal(msg.Payload, &m)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m.N
+}
+
+var syncId int32
+
+// receive returns the next message, skipping the ones used to sync subscriptions
+func receive(t *testing.T, b Broker) *Message {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	for {
+		msg, err := b.Receive(ctx)
+
+		if err != nil {
+			t.Fatalf("Receive: %v", err)
+		}
+
+		if !strings.HasPrefix(msg.Topic, "sync.") {
+			return msg
+		}
+	}
+}
+
+// subscribe subscribes sub to the topics & waits for the subscriptions to be in place, pub being the broker that will dispatch to them
+func subscribe(t *testing.T, sub Broker, pub Broker, topics ...string) {
+	err := sub.Subscribe(context.Background(), topics...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitSubscriptions(t, sub, pub)
+}
+
+// waitSubscriptions waits for the subscription changes of sub to be applied.
+// they're asynchronous on some backends but applied in order, so they are once a topic subscribed to afterwards receives messages
+func waitSubscriptions(t *testing.T, sub Broker, pub Broker) {
+	topic := fmt.Sprintf("sync.%d", atomic.AddInt32(&syncId, 1))
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	err := sub.Subscribe(ctx, topic)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ctx.Err() == nil {
+		dispatch(t, pub, topic, 0)
+
+		receiveCtx, cancelReceive := context.WithTimeout(ctx, 20*time.Millisecond)
+		msg, err := sub.Receive(receiveCtx)
+		cancelReceive()
+
+		if err == nil && msg.Topic == topic {
+			return
+		}
+
+		if err == nil && !strings.HasPrefix(msg.Topic, "sync.") {
+			t.Fatalf("received a message on %v while waiting for subscriptions", msg.Topic)
+		}
+	}
+
+	t.Fatal("the subscriptions weren't applied")
+}
+
+func dispatch(t *testing.T, b Broker, topic string, n int) {
+	err := b.Dispatch(topic, &testMessage{N: n})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBrokers(t *testing.T) {
+	for _, backend := range backends {
+		backend := backend
+
+		t.Run(backend.name, func(t *testing.T) {
+			t.Run("Subscribe", func(t *testing.T) { testSubscribe(t, backend.setup(t)) })
+			t.Run("Unsubscribe", func(t *testing.T) { testUnsubscribe(t, backend.setup(t)) })
+			t.Run("Ordering", func(t *testing.T) { testOrdering(t, backend.setup(t)) })
+			t.Run("Close", func(t *testing.T) { testClose(t, backend.setup(t)) })
+			t.Run("CloseWhileReceiving", func(t *testing.T) { testCloseWhileReceiving(t, backend.setup(t)) })
+			t.Run("Resubscribe", func(t *testing.T) { testResubscribe(t, backend.setup(t)) })
+		})
+	}
+}
+
+func testSubscribe(t *testing.T, backend *backend) {
+	a := backend.newBroker(t)
+	b := backend.newBroker(t)
+	defer a.Close()
+	defer b.Close()
+
+	subscribe(t, a, b, "room.1", "room.2")
+	dispatch(t, b, "room.2", 2)
+
+	msg := receive(t, a)
+
+	if msg.Topic != "room.2" || decode(t, msg) != 2 {
+		t.Fatalf("expected message 2 on room.2, got %d on %v", decode(t, msg), msg.Topic)
+	}
+
+	// a node receives its own messages too
+	dispatch(t, a, "room.1", 1)
+
+	msg = receive(t, a)
+
+	if msg.Topic != "room.1" || decode(t, msg) != 1 {
+		t.Fatalf("expected message 1 on room.1, got %d on %v", decode(t, msg), msg.Topic)
+	}
+}
+
+func testUnsubscribe(t *testing.T, backend *backend) {
+	a := backend.newBroker(t)
+	b := backend.newBroker(t)
+	defer a.Close()
+	defer b.Close()
+
+	subscribe(t, a, b, "room.1", "room.2")
+
+	err := a.Unsubscribe(context.Background(), "room.1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitSubscriptions(t, a, b)
+
+	dispatch(t, b, "room.1", 1)
+	dispatch(t, b, "room.2", 2)
+
+	// messages of a topic are delivered in order, but there's no order across topics
+	deadline := time.Now().Add(200 * time.Millisecond)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	for {
+		msg, err := a.Receive(ctx)
+
+		if errors.Is(err, context.DeadlineExceeded) {
+			return
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.Topic == "room.1" {
+			t.Fatal("received a message on a topic that was unsubscribed from")
+		}
+	}
+}
+
+func testOrdering(t *testing.T, backend *backend) {
+	a := backend.newBroker(t)
+	b := backend.newBroker(t)
+	defer a.Close()
+	defer b.Close()
+
+	const count = 500
+
+	subscribe(t, a, b, "room.1")
+
+	for i := 0; i < count; i++ {
+		dispatch(t, b, "room.1", i)
+	}
+
+	for i := 0; i < count; i++ {
+		n := decode(t, receive(t, a))
+
+		if n != i {
+			t.Fatalf("expected message %d, got %d", i, n)
+		}
+	}
+}
+
+func testClose(t *testing.T, backend *backend) {
+	a := backend.newBroker(t)
+	subscribe(t, a, a, "room.1")
+
+	err := a.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = a.Close()
+
+	if err != nil {
+		t.Fatalf("closing twice: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	_, err = a.Receive(ctx)
+
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+
+	err = a.Ping(ctx)
+
+	if err == nil {
+		t.Fatal("expected Ping to fail once closed")
+	}
+}
+
+func testCloseWhileReceiving(t *testing.T, backend *backend) {
+	a := backend.newBroker(t)
+	subscribe(t, a, a, "room.1")
+
+	errs := make(chan error, 1)
+
+	go func() {
+		_, err := a.Receive(context.Background())
+		errs <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	err := a.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err = <-errs:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("expected ErrClosed, got %v", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Receive didn't return after Close")
+	}
+}
+
+func testResubscribe(t *testing.T, backend *backend) {
+	if backend.interrupt == nil {
+		t.Skip("the backend can't lose its connection")
+	}
+
+	a := backend.newBroker(t)
+	b := backend.newBroker(t)
+	defer a.Close()
+	defer b.Close()
+
+	subscribe(t, a, b, "room.1", "room.2")
+	backend.interrupt(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	// the connection being lost is reported, so the node can make up for the messages it missed
+	_, err := a.Receive(ctx)
+
+	if err == nil || errors.Is(err, ErrClosed) || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the connection error, got %v", err)
+	}
+
+	backend.restore(t)
+
+	// like the server does, the broker is resubscribed until it works, while errors that were reported in the meantime are skipped
+	for {
+		err = a.Resubscribe(ctx)
+
+		if err == nil {
+			break
+		}
+
+		if ctx.Err() != nil {
+			t.Fatalf("Resubscribe: %v", err)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	for i := 0; ; i++ {
+		if ctx.Err() != nil {
+			t.Fatal("no message was received after resubscribing")
+		}
+
+		_ = b.Dispatch(fmt.Sprintf("room.%d", i%2+1), &testMessage{N: i})
+
+		receiveCtx, cancelReceive := context.WithTimeout(ctx, 100*time.Millisecond)
+		msg, err := a.Receive(receiveCtx)
+		cancelReceive()
+
+		if err == nil && msg.Topic == fmt.Sprintf("room.%d", decode(t, msg)%2+1) {
+			break
+		}
+	}
+
+	err = a.Ping(ctx)
+
+	if err != nil {
+		t.Fatalf("Ping after recovering: %v", err)
+	}
+}
+
+func TestNATSSlowConsumer(t *testing.T) {
+	backend := newNATSBackend(t)
+	a := backend.newBroker(t)
+	b := backend.newBroker(t)
+	defer a.Close()
+	defer b.Close()
+
+	subscribe(t, a, b, "room.1")
+
+	// more than fits in the buffer, nats drops the rest
+	for i := 0; i < 2*natsBufferSize; i++ {
+		dispatch(t, b, "room.1", i)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	for {
+		_, err := a.Receive(ctx)
+
+		if errors.Is(err, nats.ErrSlowConsumer) {
+			return
+		}
+
+		if err != nil {
+			t.Fatalf("expected ErrSlowConsumer, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package broker
+
+import (
+	"context"
+	"github.com/sakuraapp/pubsub"
+	"github.com/vmihailenco/msgpack/v5"
+	"sync"
+)
+
+const memoryBufferSize = 1024
+
+// MemoryBus is an in-process message bus, every broker created on the same bus behaves like a separate node
+type MemoryBus struct {
+	mu   sync.RWMutex
+	subs map[string]map[*MemoryBroker]bool
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		subs: map[string]map[*MemoryBroker]bool{},
+	}
+}
+
+func (bus *MemoryBus) publish(topic string, payload []byte) {
+	bus.mu.RLock()
+	brokers := make([]*MemoryBroker, 0, len(bus.subs[topic]))
+
+	for b := range bus.subs[topic] {
+		brokers = append(brokers, b)
+	}
+	bus.mu.RUnlock()
+
+	for _, b := range brokers {
+		b.deliver(&Message{Topic: topic, Payload: payload})
+	}
+}
+
+func (bus *MemoryBus) subscribe(b *MemoryBroker, topics []string) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	for _, topic := range topics {
+		if bus.subs[topic] == nil {
+			bus.subs[topic] = map[*MemoryBroker]bool{b: true}
+		} else {
+			bus.subs[topic][b] = true
+		}
+	}
+}
+
+func (bus *MemoryBus) unsubscribe(b *MemoryBroker, topics []string) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	for _, topic := range topics {
+		delete(bus.subs[topic], b)
+
+		if len(bus.subs[topic]) == 0 {
+			delete(bus.subs, topic)
+		}
+	}
+}
+
+// MemoryBroker passes messages within a single process, which saves a round trip to redis when a gateway runs on its own.
+// It's not a standalone mode: everything else the gateway stores is still in redis
+type MemoryBroker struct {
+	bus       *MemoryBus
+	mu        sync.Mutex
+	topics    map[string]bool
+	messages  chan *Message
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewMemory(bus *MemoryBus) *MemoryBroker {
+	return &MemoryBroker{
+		bus:      bus,
+		topics:   map[string]bool{},
+		messages: make(chan *Message, memoryBufferSize),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (b *MemoryBroker) deliver(msg *Message) {
+	select {
+	case b.messages <- msg:
+	case <-b.closed:
+	}
+}
+
+func (b *MemoryBroker) Dispatch(topic string, message interface{}) error {
+	bytes, err := msgpack.Marshal(message)
+
+	if err != nil {
+		return err
+	}
+
+	b.bus.publish(topic, bytes)
+
+	return nil
+}
+
+func (b *MemoryBroker) DispatchTo(target pubsub.MessageTarget, message interface{}) error {
+	return b.Dispatch(target.Build(), message)
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, topics ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, topic := range topics {
+		b.topics[topic] = true
+	}
+
+	b.bus.subscribe(b, topics)
+
+	return nil
+}
+
+func (b *MemoryBroker) Unsubscribe(ctx context.Context, topics ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, topic := range topics {
+		delete(b.topics, topic)
+	}
+
+	b.bus.unsubscribe(b, topics)
+
+	return nil
+}
+
+func (b *MemoryBroker) Resubscribe(ctx context.Context) error {
+	return b.Ping(ctx) // the bus can't lose subscriptions
+}
+
+func (b *MemoryBroker) Receive(ctx context.Context) (*Message, error) {
+	select {
+	case msg := <-b.messages:
+		return msg, nil
+	case <-b.closed:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *MemoryBroker) Ping(ctx context.Context) error {
+	select {
+	case <-b.closed:
+		return ErrClosed
+	default:
+		return nil
+	}
+}
+
+func (b *MemoryBroker) Close() error {
+	b.closeOnce.Do(func() {
+		b.mu.Lock()
+		topics := make([]string, 0, len(b.topics))
+
+		for topic := range b.topics {
+			topics = append(topics, topic)
+		}
+		b.mu.Unlock()
+
+		b.bus.unsubscribe(b, topics)
+		close(b.closed)
+	})
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"github.com/sakuraapp/pubsub"
+	"github.com/vmihailenco/msgpack/v5"
+	"sync"
+)
+
+const natsBufferSize = 1024
+
+var (
+	errNATSDisconnected = errors.New("nats: disconnected")
+	errNATSReconnected  = errors.New("nats: reconnected, messages sent in the meantime were missed")
+)
+
+type NATSBroker struct {
+	conn     *nats.Conn
+	mu       sync.Mutex
+	subs     map[string]*nats.Subscription
+	messages chan *nats.Msg
+	errs     chan error
+	closed   chan struct{}
+}
+
+func NewNATS(url string, opts ...nats.Option) (*NATSBroker, error) {
+	b := &NATSBroker{
+		subs:     map[string]*nats.Subscription{},
+		messages: make(chan *nats.Msg, natsBufferSize),
+		errs:     make(chan error, 1),
+		closed:   make(chan struct{}),
+	}
+
+	// nats reconnects & resubscribes on its own, but the messages that were lost in the meantime (or dropped because they were read too slowly) have to be made up for
+	opts = append(opts,
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err == nil {
+				err = errNATSDisconnected
+			} else {
+				err = fmt.Errorf("%w: %v", errNATSDisconnected, err)
+			}
+
+			b.fail(err)
+		}),
+		nats.ReconnectHandler(func(_ *nats.Conn) {
+			b.fail(errNATSReconnected)
+		}),
+		nats.ClosedHandler(func(_ *nats.Conn) {
+			b.fail(nats.ErrConnectionClosed) // it gave up reconnecting
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, sub *nats.Subscription, err error) {
+			if sub != nil {
+				err = fmt.Errorf("%w (%v)", err, sub.Subject)
+			}
+
+			b.fail(err)
+		}),
+	)
+
+	conn, err := nats.Connect(url, opts...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b.conn = conn
+
+	return b, nil
+}
+
+// fail reports an error to Receive, one pending error is enough for every topic to be resynced
+func (b *NATSBroker) fail(err error) {
+	select {
+	case <-b.closed:
+		return
+	default:
+	}
+
+	select {
+	case b.errs <- err:
+	default:
+	}
+}
+
+func (b *NATSBroker) Dispatch(topic string, message interface{}) error {
+	bytes, err := msgpack.Marshal(message)
+
+	if err != nil {
+		return err
+	}
+
+	return b.conn.Publish(topic, bytes)
+}
+
+func (b *NATSBroker) DispatchTo(target pubsub.MessageTarget, message interface{}) error {
+	return b.Dispatch(target.Build(), message)
+}
+
+func (b *NATSBroker) Subscribe(ctx context.Context, topics ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, topic := range topics {
+		if b.subs[topic] != nil {
+			continue
+		}
+
+		sub, err := b.conn.ChanSubscribe(topic, b.messages)
+
+		if err != nil {
+			return err
+		}
+
+		b.subs[topic] = sub
+	}
+
+	return nil
+}
+
+func (b *NATSBroker) Unsubscribe(ctx context.Context, topics ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, topic := range topics {
+		sub := b.subs[topic]
+
+		if sub == nil {
+			continue
+		}
+
+		delete(b.subs, topic)
+
+		err := sub.Unsubscribe()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Resubscribe replaces subscriptions that were invalidated, nats already restores the valid ones on its own when it reconnects
+func (b *NATSBroker) Resubscribe(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for topic, sub := range b.subs {
+		if sub.IsValid() {
+			continue
+		}
+
+		newSub, err := b.conn.ChanSubscribe(topic, b.messages)
+
+		if err != nil {
+			return err
+		}
+
+		b.subs[topic] = newSub
+	}
+
+	return b.conn.FlushWithContext(ctx)
+}
+
+func (b *NATSBroker) Receive(ctx context.Context) (*Message, error) {
+	select {
+	case msg := <-b.messages:
+		return &Message{
+			Topic:   msg.Subject,
+			Payload: msg.Data,
+		}, nil
+	case err := <-b.errs:
+		return nil, err
+	case <-b.closed:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *NATSBroker) Ping(ctx context.Context) error {
+	if b.conn.IsClosed() {
+		return ErrClosed
+	}
+
+	return b.conn.FlushWithContext(ctx)
+}
+
+func (b *NATSBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-b.closed:
+		return nil
+	default:
+	}
+
+	// closed first, so that the disconnection isn't reported as an error
+	close(b.closed)
+	b.conn.Close()
+
+	return nil
+}
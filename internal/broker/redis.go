@@ -0,0 +1,155 @@
+package broker
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/sakuraapp/pubsub"
+	"github.com/vmihailenco/msgpack/v5"
+	"sync"
+)
+
+const redisBufferSize = 1024
+
+type RedisBroker struct {
+	ctx       context.Context
+	rdb       redis.UniversalClient
+	pubsub    *redis.PubSub
+	mu        sync.Mutex
+	topics    map[string]bool
+	messages  chan *Message
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewRedis(ctx context.Context, rdb redis.UniversalClient) *RedisBroker {
+	b := &RedisBroker{
+		ctx:      ctx,
+		rdb:      rdb,
+		pubsub:   rdb.Subscribe(ctx),
+		topics:   map[string]bool{},
+		messages: make(chan *Message, redisBufferSize),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+
+	go b.read()
+
+	return b
+}
+
+// read is the only reader of the subscription's connection, so that Receive can give up on its context without the connection being dropped (go-redis drops it when a read times out)
+func (b *RedisBroker) read() {
+	for {
+		// the connection is re-established by the next read after an error, along with the subscriptions
+		msg, err := b.pubsub.ReceiveMessage(context.Background())
+
+		if err != nil {
+			select {
+			case b.errs <- err:
+				continue
+			case <-b.done:
+				return
+			}
+		}
+
+		select {
+		case b.messages <- &Message{Topic: msg.Channel, Payload: []byte(msg.Payload)}:
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *RedisBroker) Dispatch(topic string, message interface{}) error {
+	bytes, err := msgpack.Marshal(message)
+
+	if err != nil {
+		return err
+	}
+
+	return b.rdb.Publish(b.ctx, topic, bytes).Err()
+}
+
+func (b *RedisBroker) DispatchTo(target pubsub.MessageTarget, message interface{}) error {
+	return b.Dispatch(target.Build(), message)
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context, topics ...string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	for _, topic := range topics {
+		b.topics[topic] = true
+	}
+	b.mu.Unlock()
+
+	return b.pubsub.Subscribe(ctx, topics...)
+}
+
+func (b *RedisBroker) Unsubscribe(ctx context.Context, topics ...string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	for _, topic := range topics {
+		delete(b.topics, topic)
+	}
+	b.mu.Unlock()
+
+	return b.pubsub.Unsubscribe(ctx, topics...)
+}
+
+func (b *RedisBroker) Resubscribe(ctx context.Context) error {
+	b.mu.Lock()
+	topics := make([]string, 0, len(b.topics))
+
+	for topic := range b.topics {
+		topics = append(topics, topic)
+	}
+	b.mu.Unlock()
+
+	if len(topics) == 0 {
+		return b.pubsub.Ping(ctx)
+	}
+
+	return b.pubsub.Subscribe(ctx, topics...)
+}
+
+func (b *RedisBroker) Receive(ctx context.Context) (*Message, error) {
+	// a message or error that's pending when the broker is closed isn't returned
+	select {
+	case <-b.done:
+		return nil, ErrClosed
+	default:
+	}
+
+	select {
+	case msg := <-b.messages:
+		return msg, nil
+	case err := <-b.errs:
+		return nil, err
+	case <-b.done:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *RedisBroker) Ping(ctx context.Context) error {
+	return b.pubsub.Ping(ctx)
+}
+
+func (b *RedisBroker) Close() error {
+	var err error
+
+	b.closeOnce.Do(func() {
+		close(b.done)
+		err = b.pubsub.Close()
+	})
+
+	return err
+}
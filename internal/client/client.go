@@ -4,22 +4,30 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/lesismal/nbio/nbhttp/websocket"
+	"github.com/sakuraapp/gateway/internal/metrics"
+	"github.com/sakuraapp/gateway/pkg/util"
 	"github.com/sakuraapp/shared/pkg/dispatcher/gateway"
 	dispatcher "github.com/sakuraapp/shared/pkg/dispatcher/gateway"
 	"github.com/sakuraapp/shared/pkg/model"
 	"github.com/sakuraapp/shared/pkg/resource"
 	"github.com/sakuraapp/shared/pkg/resource/opcode"
+	"github.com/sakuraapp/shared/pkg/resource/permission"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
 type Client struct {
-	Session    *Session
-	LastActive time.Time
-	ctx        context.Context
-	ctxCancel  context.CancelFunc
-	conn       *websocket.Conn
-	upgrader   *websocket.Upgrader
+	Session     *Session
+	LastActive  time.Time
+	ConnectedAt time.Time
+	ctx         context.Context
+	ctxCancel   context.CancelFunc
+	conn        *websocket.Conn
+	upgrader    *websocket.Upgrader
+	limiter     atomic.Value // *rate.Limiter
 }
 
 func (c *Client) Context() context.Context {
@@ -30,7 +38,21 @@ func (c *Client) Conn() *websocket.Conn {
 	return c.conn
 }
 
+// SequencedPacket is a packet along with the sequence number of the room event it carries, so clients can detect stale updates
+type SequencedPacket struct {
+	resource.Packet
+	Seq int64 `json:"s"`
+}
+
 func (c *Client) Write(packet resource.Packet) error {
+	return c.write(packet.Opcode, packet)
+}
+
+func (c *Client) WriteSeq(packet resource.Packet, seq int64) error {
+	return c.write(packet.Opcode, &SequencedPacket{Packet: packet, Seq: seq})
+}
+
+func (c *Client) write(op opcode.Opcode, packet interface{}) error {
 	b, err := json.Marshal(packet)
 
 	if err != nil {
@@ -43,6 +65,8 @@ func (c *Client) Write(packet resource.Packet) error {
 		return err
 	}
 
+	metrics.PacketsOut.WithLabelValues(strconv.Itoa(int(op))).Inc()
+
 	log.Debugf("OnWrite: %+v\n", packet)
 
 	return nil
@@ -55,16 +79,15 @@ func (c *Client) Send(op opcode.Opcode, data interface{}) error {
 }
 
 func (c *Client) Dispatch(msg *dispatcher.Message) {
-	if msg.Filters != nil {
-		for filter, value := range msg.Filters {
-			switch filter {
-			case gateway.MessageFilterRoom:
-				roomId := value.(model.RoomId)
-
-				if c.Session.RoomId != roomId {
-					return
-				}
-			}
+	if roomId, ok := util.FilterInt(msg.Filters, gateway.MessageFilterRoom); ok {
+		if c.Session.RoomId != model.RoomId(roomId) {
+			return
+		}
+	}
+
+	if perms, ok := util.FilterInt(msg.Filters, gateway.MessageFilterPermissions); ok && perms > 0 {
+		if !c.Session.HasPermission(permission.Permission(perms)) {
+			return
 		}
 	}
 
@@ -86,11 +109,29 @@ func (c *Client) Disconnect() {
 func NewClient(ctx context.Context, conn *websocket.Conn, upgrader *websocket.Upgrader) *Client {
 	ctx, cancel := context.WithCancel(ctx)
 	c := &Client{
-		ctx:       ctx,
-		ctxCancel: cancel,
-		conn:      conn,
-		upgrader:  upgrader,
+		ctx:         ctx,
+		ctxCancel:   cancel,
+		conn:        conn,
+		upgrader:    upgrader,
+		ConnectedAt: time.Now(),
 	}
 
+	c.SetRateLimit(0, 0)
+
 	return c
 }
+
+// SetRateLimit changes how many messages per second the client can send (& how many at once), 0 is unlimited
+// the limiter is replaced rather than updated, so the client starts over with a full burst
+func (c *Client) SetRateLimit(limit float64, burst int) {
+	if limit == 0 {
+		c.limiter.Store(rate.NewLimiter(rate.Inf, 0))
+	} else {
+		c.limiter.Store(rate.NewLimiter(rate.Limit(limit), burst))
+	}
+}
+
+// Allow reports whether the client can send a message now, under its rate limit
+func (c *Client) Allow() bool {
+	return c.limiter.Load().(*rate.Limiter).Allow()
+}
@@ -1,5 +1,11 @@
 package config
 
+import (
+	"github.com/sakuraapp/gateway/pkg/util"
+	"net/url"
+	"time"
+)
+
 type envType string
 
 const (
@@ -7,25 +13,151 @@ const (
 	EnvPROD envType = "PROD"
 )
 
+// RedisMode is how redis is deployed
+type RedisMode string
+
+const (
+	RedisStandalone RedisMode = "standalone"
+	RedisSentinel   RedisMode = "sentinel" // the master is looked up through sentinels & followed when it fails over
+)
+
+func (m RedisMode) valid() bool {
+	switch m {
+	case RedisStandalone, RedisSentinel:
+		return true
+	}
+
+	return false
+}
+
 type Config struct {
-	Env  envType
-	Port int
-	NodeId string
-	AllowedOrigins []string
-	GrpcPort int
-	GrpcCertPath string
-	GrpcKeyPath string
-	JWTPublicPath string
-	DatabaseUser string
-	DatabasePassword string
-	DatabaseName string
-	RedisAddr string
-	RedisPassword string
-	RedisDatabase int
-	S3Region *string
-	S3Bucket *string
-	S3Endpoint *string
-	S3ForcePathStyle *bool
+	Env  envType `config:"app_env"`
+	Port int `config:"port"`
+	AdminPort int `config:"admin_port"` // serves metrics & the admin api, 0 disables it
+	AdminToken string `config:"admin_token"` // bearer token required by the admin api, empty disables the api (metrics are still served)
+	NodeId string `config:"node_id"` // a random id is generated when empty
+	AllowedOrigins []string `config:"allowed_origins,reload"`
+	MaxConnections int `config:"max_connections"` // websocket connections accepted by this node at most
+	MaxMessageSize int `config:"max_message_size"` // bytes, larger requests & messages are rejected
+	MaxRooms int `config:"max_rooms,reload"` // rooms this node hosts at most, clients can't join other rooms once it's reached, 0 is unlimited
+	MessageRateLimit float64 `config:"message_rate_limit,reload"` // messages per second a client can send, 0 is unlimited
+	MessageRateBurst int `config:"message_rate_burst,reload"` // messages a client can send at once, above the rate limit
+	LogLevel string `config:"log_level,reload"` // logrus level, defaults to debug in DEV & info in PROD
+	LogFormat string `config:"log_format"` // text or json
+	GrpcPort int `config:"grpc_port"`
+	GrpcCertPath string `config:"grpc_cert_path"`
+	GrpcKeyPath string `config:"grpc_key_path"`
+	GrpcNetwork string `config:"grpc_network"` // tcp or unix
+	GrpcSocketPath string `config:"grpc_socket_path"`
+	GrpcInsecure bool `config:"grpc_insecure"` // plaintext, only meant for local development
+	GrpcClientCAPath string `config:"grpc_client_ca_path"` // enables mutual TLS when set
+	GrpcAllowedClients []string `config:"grpc_allowed_clients"` // subject names allowed to connect with mutual TLS, empty allows any client signed by the CA
+	GrpcDefaultTimeout time.Duration `config:"grpc_default_timeout"` // deadline given to unary calls that don't have one
+	JWTPublicPath string `config:"jwt_public_key"`
+	DatabaseAddr string `config:"db_addr"` // host:port of the primary
+	DatabaseReplicaAddrs []string `config:"db_replica_addrs"` // host:port of each replica, some reads go to them rather than to the primary
+	DatabaseUser string `config:"db_user"`
+	DatabasePassword string `config:"db_password"`
+	DatabaseName string `config:"db_database"`
+	DatabaseTLS TLSMode `config:"db_tls"`
+	DatabaseTLSCAPath string `config:"db_tls_ca_path"` // verifies the server against this CA instead of the system's
+	DatabaseDialTimeout time.Duration `config:"db_dial_timeout"`
+	DatabaseReadTimeout time.Duration `config:"db_read_timeout"`
+	DatabaseWriteTimeout time.Duration `config:"db_write_timeout"`
+	DatabasePoolSize int `config:"db_pool_size"` // connections to each server at most, 0 is 10 per CPU
+	DatabaseStatementTimeout time.Duration `config:"db_statement_timeout"` // queries running for longer are cancelled by postgres, 0 is no timeout
+	RedisMode RedisMode `config:"redis_mode"`
+	RedisAddr string `config:"redis_addr"` // standalone mode
+	RedisAddrs []string `config:"redis_addrs"` // the sentinels (sentinel mode)
+	RedisMasterName string `config:"redis_master_name"` // sentinel mode
+	RedisSentinelPassword string `config:"redis_sentinel_password"` // sentinel mode, when the sentinels require a different password than redis
+	RedisPassword string `config:"redis_password"`
+	RedisDatabase int `config:"redis_database"`
+	RedisTLS TLSMode `config:"redis_tls"`
+	RedisTLSCAPath string `config:"redis_tls_ca_path"`
+	RedisDialTimeout time.Duration `config:"redis_dial_timeout"`
+	RedisReadTimeout time.Duration `config:"redis_read_timeout"`
+	RedisWriteTimeout time.Duration `config:"redis_write_timeout"`
+	PubsubBackend string `config:"pubsub_backend"` // redis, memory (a single node, which still needs redis for everything but pubsub) or nats
+	NatsUrl string `config:"nats_url"`
+	S3Region *string `config:"s3_region"`
+	S3Bucket *string `config:"s3_bucket"`
+	S3Endpoint *string `config:"s3_endpoint"`
+	S3ForcePathStyle *bool `config:"s3_force_path_style"`
+	Crawler util.CrawlerOptions `config:"crawler"`
+	MediaCacheTTL time.Duration `config:"media_cache_ttl"`
+	MediaNegativeCacheTTL time.Duration `config:"media_negative_cache_ttl"`
+	MediaMaxConcurrentCrawls int `config:"media_max_concurrent_crawls"` // items enriched at once, the items added past it keep the info they were added with
+	RoomEventsMaxLen int64 `config:"room_events_max_len"` // approximate number of events kept per room, 0 disables the event log
+	RoomEventsRetention time.Duration `config:"room_events_retention"` // how long events (& a room's sequence number, once it's idle) are kept for, 0 keeps them until they're trimmed by length
+	DrainTimeout time.Duration `config:"drain_timeout"` // how long clients are given to move to another node on shutdown, 0 disconnects them right away
+	DrainReconnectSpread time.Duration `config:"drain_reconnect_spread"` // clients are told to reconnect after a random delay up to this
+	TracingExporter string `config:"tracing_exporter"` // otlp or stdout, empty disables tracing
+	TracingEndpoint string `config:"tracing_endpoint"` // otlp/http collector address (host:port)
+	TracingInsecure bool `config:"tracing_insecure"`
+	TracingSampleRatio float64 `config:"tracing_sample_ratio"`
+}
+
+// Default returns the config used for anything that isn't set by a file, the environment or a flag
+func Default() *Config {
+	s3Region, s3Bucket, s3Endpoint := "", "", ""
+	s3ForcePathStyle := false
+
+	return &Config{
+		Env: EnvDEV,
+		MaxConnections: 1000000,
+		MaxMessageSize: 1 << 20,
+		MessageRateLimit: 20,
+		MessageRateBurst: 40,
+		LogFormat: "text",
+		GrpcNetwork: "tcp",
+		GrpcDefaultTimeout: 10 * time.Second,
+		DatabaseAddr: "localhost:5432",
+		DatabaseTLS: TLSDisable,
+		DatabaseDialTimeout: 5 * time.Second,
+		DatabaseReadTimeout: 30 * time.Second,
+		DatabaseWriteTimeout: 30 * time.Second,
+		RedisMode: RedisStandalone,
+		RedisAddr: "localhost:6379",
+		RedisTLS: TLSDisable,
+		RedisDialTimeout: 5 * time.Second,
+		RedisReadTimeout: 3 * time.Second,
+		RedisWriteTimeout: 3 * time.Second,
+		S3Region: &s3Region,
+		S3Bucket: &s3Bucket,
+		S3Endpoint: &s3Endpoint,
+		S3ForcePathStyle: &s3ForcePathStyle,
+		Crawler: util.DefaultCrawlerOptions(),
+		MediaCacheTTL: 6 * time.Hour,
+		MediaNegativeCacheTTL: time.Minute,
+		MediaMaxConcurrentCrawls: 32,
+		RoomEventsMaxLen: 1000,
+		RoomEventsRetention: 24 * time.Hour,
+		DrainTimeout: 30 * time.Second,
+		DrainReconnectSpread: 10 * time.Second,
+		TracingSampleRatio: 1,
+	}
+}
+
+// Redacted returns a copy of the config without its secrets, so it can be shown to operators
+func (c Config) Redacted() Config {
+	redact := func(s *string) {
+		if *s != "" {
+			*s = "[redacted]"
+		}
+	}
+
+	redact(&c.AdminToken)
+	redact(&c.DatabasePassword)
+	redact(&c.RedisPassword)
+	redact(&c.RedisSentinelPassword)
+
+	if u, err := url.Parse(c.NatsUrl); err == nil && u.User != nil {
+		u.User = url.User("[redacted]")
+		c.NatsUrl = u.String()
+	}
+
+	return c
 }
 
 func (c *Config) IsDev() bool {
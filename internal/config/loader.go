@@ -0,0 +1,371 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigFileEnv is the environment variable holding the config file's path, the -config flag takes precedence over it
+const ConfigFileEnv = "CONFIG_FILE"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field is a single setting of the config, found through its `config` tag
+// nested structs (e.g. crawler) are flattened: crawler.timeout in a file, CRAWLER_TIMEOUT in the environment & -crawler-timeout as a flag
+// settings tagged with the reload option (`config:"log_level,reload"`) can be changed while the gateway is running, see Reloader
+type field struct {
+	path       []string
+	value      reflect.Value
+	reloadable bool
+}
+
+func (f *field) key() string {
+	return strings.Join(f.path, ".")
+}
+
+func (f *field) env() string {
+	return strings.ToUpper(strings.Join(f.path, "_"))
+}
+
+func (f *field) flag() string {
+	return strings.ReplaceAll(strings.Join(f.path, "-"), "_", "-")
+}
+
+func fields(v reflect.Value, path []string) []*field {
+	var res []*field
+
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("config")
+
+		if !ok {
+			continue
+		}
+
+		name, opt, _ := strings.Cut(tag, ",")
+		fieldPath := append(append([]string{}, path...), name)
+		value := v.Field(i)
+
+		if value.Kind() == reflect.Struct {
+			res = append(res, fields(value, fieldPath)...)
+		} else {
+			res = append(res, &field{path: fieldPath, value: value, reloadable: opt == "reload"})
+		}
+	}
+
+	return res
+}
+
+// flagValue keeps flags aside until the file & environment are loaded, since they take precedence over both
+type flagValue struct {
+	field  *field
+	isBool bool
+	values *[]flagSetting
+}
+
+type flagSetting struct {
+	field *field
+	value string
+}
+
+func (v *flagValue) String() string {
+	return ""
+}
+
+func (v *flagValue) Set(s string) error {
+	*v.values = append(*v.values, flagSetting{field: v.field, value: s})
+
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+// Load builds the config from, in increasing order of precedence: the defaults, a yaml or toml file, the environment & command-line flags
+// flag.ErrHelp is returned when the usage was requested
+func Load(name string, args []string) (*Config, error) {
+	conf, _, err := load(name, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conf.ensureNodeId()
+
+	return conf, nil
+}
+
+// load also returns the path of the config file, empty when there's none
+func load(name string, args []string) (*Config, string, error) {
+	conf := Default()
+	all := fields(reflect.ValueOf(conf).Elem(), nil)
+
+	var configPath string
+	var flags []flagSetting
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&configPath, "config", os.Getenv(ConfigFileEnv), "config file (.yaml, .yml or .toml)")
+
+	for _, f := range all {
+		kind := f.value.Type()
+
+		if kind.Kind() == reflect.Ptr {
+			kind = kind.Elem()
+		}
+
+		fs.Var(&flagValue{
+			field:  f,
+			isBool: kind.Kind() == reflect.Bool,
+			values: &flags,
+		}, f.flag(), fmt.Sprintf("sets %v (env %v)", f.key(), f.env()))
+	}
+
+	err := fs.Parse(args)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if fs.NArg() > 0 {
+		return nil, "", fmt.Errorf("unexpected argument: %v", fs.Arg(0))
+	}
+
+	if configPath != "" {
+		err = loadFile(conf, configPath)
+
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	for _, f := range all {
+		val := os.Getenv(f.env())
+
+		if val == "" {
+			continue
+		}
+
+		err = setValue(f.value, val)
+
+		if err != nil {
+			return nil, "", fmt.Errorf("%v: %w", f.env(), err)
+		}
+	}
+
+	for _, setting := range flags {
+		err = setValue(setting.field.value, setting.value)
+
+		if err != nil {
+			return nil, "", fmt.Errorf("-%v: %w", setting.field.flag(), err)
+		}
+	}
+
+	conf.fillDefaults()
+
+	err = conf.Validate()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return conf, configPath, nil
+}
+
+func loadFile(conf *Config, path string) error {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	raw := map[string]interface{}{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return fmt.Errorf("%v: unsupported config file format, use yaml or toml", path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+
+	byKey := map[string]*field{}
+
+	for _, f := range fields(reflect.ValueOf(conf).Elem(), nil) {
+		byKey[f.key()] = f
+	}
+
+	flat := map[string]interface{}{}
+	flatten(flat, "", raw)
+
+	// sorted so the same file always fails on the same key
+	keys := make([]string, 0, len(flat))
+
+	for key := range flat {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		f, ok := byKey[key]
+
+		if !ok {
+			return fmt.Errorf("%v: unknown setting %v", path, key)
+		}
+
+		err = setValue(f.value, flat[key])
+
+		if err != nil {
+			return fmt.Errorf("%v: %v: %w", path, key, err)
+		}
+	}
+
+	return nil
+}
+
+func flatten(dst map[string]interface{}, prefix string, m map[string]interface{}) {
+	for key, val := range m {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if nested, ok := val.(map[string]interface{}); ok {
+			flatten(dst, key, nested)
+		} else {
+			dst[key] = val
+		}
+	}
+}
+
+// setValue sets a setting from a file value (already typed) or from a string (environment or flag)
+func setValue(v reflect.Value, raw interface{}) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+
+		return setValue(v.Elem(), raw)
+	}
+
+	if v.Kind() == reflect.Slice {
+		if list, ok := raw.([]interface{}); ok {
+			items := make([]string, 0, len(list))
+
+			for _, item := range list {
+				items = append(items, fmt.Sprint(item))
+			}
+
+			raw = strings.Join(items, ",")
+		}
+	}
+
+	var str string
+
+	switch val := raw.(type) {
+	case string:
+		str = val
+	case float64:
+		str = strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		str = fmt.Sprint(val)
+	}
+
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(str)
+
+		if err != nil {
+			return fmt.Errorf("invalid duration %q (e.g. 10s, 5m)", str)
+		}
+
+		v.SetInt(int64(d))
+
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(str)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(str)
+
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", str)
+		}
+
+		v.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(str, 10, v.Type().Bits())
+
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", str)
+		}
+
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(str, 64)
+
+		if err != nil {
+			return fmt.Errorf("invalid number %q", str)
+		}
+
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+
+		for _, item := range strings.Split(str, ",") {
+			item = strings.TrimSpace(item)
+
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %v", v.Type())
+	}
+
+	return nil
+}
+
+// ensureNodeId gives the node a random id when it wasn't given one
+// it's not part of the defaults since it's different every time the config is loaded
+func (c *Config) ensureNodeId() {
+	if c.NodeId == "" {
+		c.NodeId = uuid.NewString()
+	}
+}
+
+// fillDefaults sets the defaults that depend on other settings
+func (c *Config) fillDefaults() {
+	c.Env = envType(strings.ToUpper(string(c.Env)))
+
+	if c.LogLevel == "" {
+		if c.IsDev() {
+			c.LogLevel = "debug"
+		} else {
+			c.LogLevel = "info"
+		}
+	}
+
+	for i, origin := range c.AllowedOrigins {
+		c.AllowedOrigins[i] = strings.ToLower(origin)
+	}
+}
@@ -0,0 +1,278 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"github.com/sakuraapp/gateway/pkg/util"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the settings every loaded config needs to be valid
+const (
+	testYaml = `
+port: 9000
+grpc_port: 9001
+grpc_insecure: true
+jwt_public_key: jwt.pem
+db_user: sakura
+db_database: sakura
+`
+	testToml = `
+port = 9000
+grpc_port = 9001
+grpc_insecure = true
+jwt_public_key = "jwt.pem"
+db_user = "sakura"
+db_database = "sakura"
+`
+)
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0o600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"gateway.yaml", testYaml + `
+app_env: prod
+allowed_origins: [https://sakura.rip, HTTPS://Staging.Sakura.rip]
+message_rate_limit: 2.5
+media_cache_ttl: 1h30m
+s3_bucket: avatars
+crawler:
+  timeout: 4s
+  max_redirects: 0
+  allowed_domains:
+    - youtube.com
+    - vimeo.com
+`},
+		{"gateway.toml", testToml + `
+app_env = "prod"
+allowed_origins = ["https://sakura.rip", "HTTPS://Staging.Sakura.rip"]
+message_rate_limit = 2.5
+media_cache_ttl = "1h30m"
+s3_bucket = "avatars"
+
+[crawler]
+timeout = "4s"
+max_redirects = 0
+allowed_domains = ["youtube.com", "vimeo.com"]
+`},
+	}
+
+	for _, test := range tests {
+		path := writeConfigFile(t, test.name, test.content)
+		conf, loadedPath, err := load("gateway", []string{"-config", path})
+
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		if loadedPath != path {
+			t.Errorf("%v: expected the file's path, got %q", test.name, loadedPath)
+		}
+
+		// the defaults that depend on other settings are filled in once everything's loaded
+		if conf.Env != EnvPROD || conf.LogLevel != "info" {
+			t.Errorf("%v: expected PROD with its default log level, got %v & %v", test.name, conf.Env, conf.LogLevel)
+		}
+
+		if origins := []string{"https://sakura.rip", "https://staging.sakura.rip"}; !reflect.DeepEqual(conf.AllowedOrigins, origins) {
+			t.Errorf("%v: expected %v, got %v", test.name, origins, conf.AllowedOrigins)
+		}
+
+		if conf.MessageRateLimit != 2.5 {
+			t.Errorf("%v: expected a rate limit of 2.5, got %v", test.name, conf.MessageRateLimit)
+		}
+
+		if conf.MediaCacheTTL != 90*time.Minute {
+			t.Errorf("%v: expected a cache ttl of 1h30m, got %v", test.name, conf.MediaCacheTTL)
+		}
+
+		if conf.S3Bucket == nil || *conf.S3Bucket != "avatars" {
+			t.Errorf("%v: expected the avatars bucket, got %v", test.name, conf.S3Bucket)
+		}
+
+		if conf.Crawler.Timeout != 4*time.Second || conf.Crawler.MaxRedirects != 0 {
+			t.Errorf("%v: expected the nested crawler settings, got %+v", test.name, conf.Crawler)
+		}
+
+		if domains := []string{"youtube.com", "vimeo.com"}; !reflect.DeepEqual(conf.Crawler.AllowedDomains, domains) {
+			t.Errorf("%v: expected %v, got %v", test.name, domains, conf.Crawler.AllowedDomains)
+		}
+
+		// settings that aren't in the file keep their default
+		if conf.Crawler.ConnectTimeout != util.DefaultCrawlerOptions().ConnectTimeout {
+			t.Errorf("%v: expected the default connect timeout, got %v", test.name, conf.Crawler.ConnectTimeout)
+		}
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "gateway.yaml", testYaml+`
+max_rooms: 10
+allowed_origins: [https://file.example]
+crawler:
+  timeout: 1s
+`)
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		maxRooms int
+		origins  []string
+		timeout  time.Duration
+	}{
+		{"file", nil, nil, 10, []string{"https://file.example"}, time.Second},
+		{
+			"env",
+			map[string]string{"MAX_ROOMS": "20", "ALLOWED_ORIGINS": "https://env.example, https://other.example", "CRAWLER_TIMEOUT": "2s"},
+			nil,
+			20, []string{"https://env.example", "https://other.example"}, 2 * time.Second,
+		},
+		{
+			"flag",
+			map[string]string{"MAX_ROOMS": "20", "ALLOWED_ORIGINS": "https://env.example", "CRAWLER_TIMEOUT": "2s"},
+			[]string{"-max-rooms", "30", "-allowed-origins", "https://flag.example", "-crawler-timeout=3s"},
+			30, []string{"https://flag.example"}, 3 * time.Second,
+		},
+		{
+			"partial",
+			map[string]string{"CRAWLER_TIMEOUT": "2s"},
+			[]string{"-max-rooms", "30"},
+			30, []string{"https://file.example"}, 2 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, val := range test.env {
+				t.Setenv(key, val)
+			}
+
+			// the file can also be given through the environment
+			t.Setenv(ConfigFileEnv, path)
+
+			conf, _, err := load("gateway", test.args)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if conf.MaxRooms != test.maxRooms {
+				t.Errorf("expected max_rooms %v, got %v", test.maxRooms, conf.MaxRooms)
+			}
+
+			if !reflect.DeepEqual(conf.AllowedOrigins, test.origins) {
+				t.Errorf("expected allowed_origins %v, got %v", test.origins, conf.AllowedOrigins)
+			}
+
+			if conf.Crawler.Timeout != test.timeout {
+				t.Errorf("expected crawler.timeout %v, got %v", test.timeout, conf.Crawler.Timeout)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	valid := writeConfigFile(t, "gateway.yaml", testYaml)
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		env     map[string]string
+		args    []string
+		err     string
+	}{
+		{"unknown key", "gateway.yaml", testYaml + "crawler:\n  retries: 3\n", nil, nil, "unknown setting crawler.retries"},
+		{"unknown toml key", "gateway.toml", testToml + "[crawler]\nretries = 3\n", nil, nil, "unknown setting crawler.retries"},
+		{"file duration", "gateway.yaml", testYaml + "media_cache_ttl: 10\n", nil, nil, "media_cache_ttl: invalid duration"},
+		{"file format", "gateway.json", "{}", nil, nil, "unsupported config file format"},
+		{"yaml syntax", "gateway.yaml", "port: [9000\n", nil, nil, "gateway.yaml"},
+		{"env duration", "", "", map[string]string{"CRAWLER_TIMEOUT": "soon"}, nil, "CRAWLER_TIMEOUT: invalid duration"},
+		{"env integer", "", "", map[string]string{"PORT": "http"}, nil, "PORT: invalid integer"},
+		{"flag boolean", "", "", nil, []string{"-grpc-insecure=maybe"}, "-grpc-insecure: invalid boolean"},
+		{"argument", "", "", nil, []string{"serve"}, "unexpected argument: serve"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := valid
+
+			if test.file != "" {
+				path = writeConfigFile(t, test.file, test.content)
+			}
+
+			for key, val := range test.env {
+				t.Setenv(key, val)
+			}
+
+			_, _, err := load("gateway", append([]string{"-config", path}, test.args...))
+
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected an error about %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestLoadValidates(t *testing.T) {
+	path := writeConfigFile(t, "gateway.yaml", testYaml+`
+log_format: xml
+redis_mode: cluster
+crawler:
+  max_body_size: 0
+`)
+
+	t.Setenv("MAX_CONNECTIONS", "0")
+
+	_, _, err := load("gateway", []string{"-config", path, "-port", "0"})
+
+	var errs ValidationError
+
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+
+	// every invalid setting is reported at once, wherever it came from
+	for _, key := range []string{"port", "max_connections", "log_format", "redis_mode", "crawler.max_body_size"} {
+		found := false
+
+		for _, e := range errs {
+			found = found || strings.HasPrefix(e, key+" ")
+		}
+
+		if !found {
+			t.Errorf("expected %v to be reported, got %q", key, []string(errs))
+		}
+	}
+
+	if len(errs) != 5 {
+		t.Errorf("expected 5 errors, got %q", []string(errs))
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	_, _, err := load("gateway", []string{"-h"})
+
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("expected flag.ErrHelp, got %v", err)
+	}
+}
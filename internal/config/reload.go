@@ -0,0 +1,303 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fileReloadDelay groups the events of a single save (editors often write a file in several steps) into one reload
+const fileReloadDelay = 500 * time.Millisecond
+
+// Reloader holds the running config, only its reloadable settings change after startup
+// they change when the config is read again (file, environment & flags) or through overrides, which take precedence & are shared by every node
+type Reloader struct {
+	name      string
+	args      []string
+	path      string
+	mu        sync.Mutex // serializes changes
+	loaded    *Config    // as last read, without the overrides
+	overrides map[string]interface{}
+	current   atomic.Value // *Config
+	listeners []func(*Config)
+}
+
+// NewReloader loads the config like Load does, the path of the config file (if any) is kept so it can be watched
+func NewReloader(name string, args []string) (*Reloader, error) {
+	conf, path, err := load(name, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reloader{
+		name:   name,
+		args:   args,
+		path:   path,
+		loaded: conf,
+	}
+
+	running := *conf
+	running.ensureNodeId()
+
+	r.current.Store(&running)
+
+	return r, nil
+}
+
+// Config returns the running config, it must not be modified
+func (r *Reloader) Config() *Config {
+	return r.current.Load().(*Config)
+}
+
+// OnChange registers fn to be called with the new config whenever a reloadable setting changes
+func (r *Reloader) OnChange(fn func(*Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.listeners = append(r.listeners, fn)
+}
+
+// Reload reads the config again, the running config is kept as is when the new one is invalid
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	loaded, _, err := load(r.name, r.args)
+
+	if err != nil {
+		return err
+	}
+
+	if keys := diff(r.loaded, loaded, false); len(keys) > 0 {
+		log.WithField("settings", strings.Join(keys, ", ")).Warn("Some changed settings only apply after a restart")
+	}
+
+	err = r.apply(loaded, r.overrides)
+
+	if err != nil {
+		return err
+	}
+
+	r.loaded = loaded
+
+	return nil
+}
+
+// SetOverrides replaces the overrides, given in the same layout as a config file
+func (r *Reloader) SetOverrides(overrides map[string]interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.apply(r.loaded, overrides)
+
+	if err != nil {
+		return err
+	}
+
+	r.overrides = overrides
+
+	return nil
+}
+
+// CheckOverrides returns the error SetOverrides would, without applying them
+func (r *Reloader) CheckOverrides(overrides map[string]interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.build(r.loaded, overrides)
+
+	return err
+}
+
+func (r *Reloader) build(loaded *Config, overrides map[string]interface{}) (*Config, error) {
+	// static settings are copied from the running config, so they stay as they were at startup.
+	// every setting is deep-copied, the running config is being read while the next one is normalized & overridden
+	next := *r.Config()
+	nextFields := fields(reflect.ValueOf(&next).Elem(), nil)
+	loadedFields := fields(reflect.ValueOf(loaded).Elem(), nil)
+
+	for i, f := range nextFields {
+		if f.reloadable {
+			f.value.Set(deepCopy(loadedFields[i].value))
+		} else {
+			f.value.Set(deepCopy(f.value))
+		}
+	}
+
+	byKey := map[string]*field{}
+
+	for _, f := range nextFields {
+		byKey[f.key()] = f
+	}
+
+	flat := map[string]interface{}{}
+	flatten(flat, "", overrides)
+
+	for key, val := range flat {
+		f, ok := byKey[key]
+
+		if !ok {
+			return nil, fmt.Errorf("unknown setting %v", key)
+		}
+
+		if !f.reloadable {
+			return nil, fmt.Errorf("%v can't be changed at runtime", key)
+		}
+
+		err := setValue(f.value, val)
+
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", key, err)
+		}
+	}
+
+	next.fillDefaults()
+
+	err := next.Validate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &next, nil
+}
+
+func (r *Reloader) apply(loaded *Config, overrides map[string]interface{}) error {
+	next, err := r.build(loaded, overrides)
+
+	if err != nil {
+		return err
+	}
+
+	keys := diff(r.Config(), next, true)
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	r.current.Store(next)
+
+	log.WithField("settings", strings.Join(keys, ", ")).Info("Config reloaded")
+
+	for _, fn := range r.listeners {
+		fn(next)
+	}
+
+	return nil
+}
+
+// WatchFile reloads the config whenever its file changes, until ctx is done
+func (r *Reloader) WatchFile(ctx context.Context) error {
+	if r.path == "" {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return err
+	}
+
+	// the directory is watched rather than the file, since editors (& kubernetes config maps) replace files instead of writing to them
+	err = watcher.Add(filepath.Dir(r.path))
+
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		var pending <-chan time.Time
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) != 0 {
+					pending = time.After(fileReloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				log.WithError(err).Error("Config file watcher error")
+			case <-pending:
+				pending = nil
+
+				err := r.Reload()
+
+				if err != nil {
+					log.WithError(err).WithField("path", r.path).Error("Failed to reload the config file")
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// deepCopy copies a setting, along with the slice, map or pointer it refers to
+func deepCopy(v reflect.Value) reflect.Value {
+	if v.IsZero() {
+		return v
+	}
+
+	switch v.Kind() {
+	case reflect.Slice:
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+
+		return c
+	case reflect.Map:
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), iter.Value())
+		}
+
+		return c
+	case reflect.Ptr:
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(deepCopy(v.Elem()))
+
+		return c
+	}
+
+	return v
+}
+
+// diff returns the keys of the reloadable (or static) settings that differ between a & b
+func diff(a *Config, b *Config, reloadable bool) []string {
+	aFields := fields(reflect.ValueOf(a).Elem(), nil)
+	bFields := fields(reflect.ValueOf(b).Elem(), nil)
+
+	var keys []string
+
+	for i, f := range aFields {
+		if f.reloadable == reloadable && !reflect.DeepEqual(f.value.Interface(), bFields[i].value.Interface()) {
+			keys = append(keys, f.key())
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReloadWhileReading(t *testing.T) {
+	path := writeConfigFile(t, "gateway.yaml", testYaml+`
+allowed_origins: [HTTPS://Sakura.rip, https://staging.sakura.rip]
+crawler:
+  allowed_domains: [youtube.com]
+`)
+
+	r, err := NewReloader("gateway", []string{"-config", path})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+
+	// the config is changed once every reader has read it at least once
+	var wg sync.WaitGroup
+	var started sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		started.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			var once sync.Once
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				conf := r.Config()
+
+				for _, origin := range conf.AllowedOrigins {
+					if origin != strings.ToLower(origin) {
+						t.Errorf("expected %q to be normalized", origin)
+					}
+				}
+
+				for _, domain := range conf.Crawler.AllowedDomains {
+					if domain == "" {
+						t.Error("expected no empty domain")
+					}
+				}
+
+				once.Do(started.Done)
+			}
+		}()
+	}
+
+	started.Wait()
+
+	// the running config is rebuilt from the same loaded config until the file is read again
+	overrides := []map[string]interface{}{
+		{"max_rooms": 10},
+		{"max_rooms": 20},
+		{"max_rooms": 30, "crawler": map[string]interface{}{"denied_domains": []interface{}{"example.com"}}},
+	}
+
+	for i := 0; i < 100; i++ {
+		if i%10 == 0 {
+			err = r.Reload()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = r.SetOverrides(overrides[i%len(overrides)])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+)
+
+// TLSMode is how a connection to a backing service (postgres, redis) is secured
+type TLSMode string
+
+const (
+	TLSDisable TLSMode = "disable"
+	TLSRequire TLSMode = "require" // encrypted, but the server's certificate isn't verified
+	TLSVerify  TLSMode = "verify"  // encrypted & the server's certificate is verified against its address
+)
+
+func (m TLSMode) valid() bool {
+	switch m {
+	case TLSDisable, TLSRequire, TLSVerify:
+		return true
+	}
+
+	return false
+}
+
+// NewTLSConfig builds the client TLS config for a service at addr, nil when TLS is disabled
+// caPath replaces the system's CAs when verifying the server
+func NewTLSConfig(mode TLSMode, addr string, caPath string) (*tls.Config, error) {
+	if mode == TLSDisable || mode == "" {
+		return nil, nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		host = addr
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: mode == TLSRequire,
+	}
+
+	if caPath != "" {
+		ca, err := os.ReadFile(caPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %v", caPath)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
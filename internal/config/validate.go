@@ -0,0 +1,156 @@
+package config
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"strings"
+)
+
+// ValidationError lists every invalid setting, so they can all be fixed at once
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "invalid config:\n  " + strings.Join(e, "\n  ")
+}
+
+// Validate checks the settings & how they fit together
+func (c *Config) Validate() error {
+	var errs ValidationError
+
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	checkPort := func(key string, port int, optional bool) {
+		if optional && port == 0 {
+			return
+		}
+
+		check(port > 0 && port <= 65535, "%v must be between 1 and 65535, got %v", key, port)
+	}
+
+	checkAddr := func(key string, addr string) {
+		_, port, err := net.SplitHostPort(addr)
+
+		check(err == nil && port != "", "%v must be a host:port address, got %q", key, addr)
+	}
+
+	checkOneOf := func(key string, val string, allowed ...string) {
+		for _, a := range allowed {
+			if val == a {
+				return
+			}
+		}
+
+		check(false, "%v must be one of %v, got %q", key, strings.Join(allowed, ", "), val)
+	}
+
+	checkPositive := func(key string, n int64) {
+		check(n > 0, "%v must be greater than 0", key)
+	}
+
+	checkNotNegative := func(key string, n int64) {
+		check(n >= 0, "%v can't be negative", key)
+	}
+
+	checkOneOf("app_env", string(c.Env), string(EnvDEV), string(EnvPROD))
+	checkPort("port", c.Port, false)
+	checkPort("admin_port", c.AdminPort, true)
+	check(c.AdminPort == 0 || c.AdminPort != c.Port, "admin_port can't be the same as port")
+	checkPositive("max_connections", int64(c.MaxConnections))
+	checkPositive("max_message_size", int64(c.MaxMessageSize))
+	checkNotNegative("max_rooms", int64(c.MaxRooms))
+	check(c.MessageRateLimit >= 0, "message_rate_limit can't be negative")
+	check(c.MessageRateLimit == 0 || c.MessageRateBurst > 0, "message_rate_burst must be greater than 0 when message_rate_limit is set")
+
+	_, err := log.ParseLevel(c.LogLevel)
+	check(err == nil, "log_level must be a valid level (e.g. debug, info, warn), got %q", c.LogLevel)
+	checkOneOf("log_format", c.LogFormat, "text", "json")
+
+	checkOneOf("grpc_network", c.GrpcNetwork, "tcp", "unix")
+
+	if c.GrpcNetwork == "unix" {
+		check(c.GrpcSocketPath != "", "grpc_socket_path is required when grpc_network is unix")
+	} else {
+		checkPort("grpc_port", c.GrpcPort, false)
+		check(c.GrpcPort == 0 || c.GrpcPort != c.Port, "grpc_port can't be the same as port")
+	}
+
+	if !c.GrpcInsecure {
+		check(c.GrpcCertPath != "" && c.GrpcKeyPath != "", "grpc_cert_path & grpc_key_path are required unless grpc_insecure is set")
+	}
+
+	check(len(c.GrpcAllowedClients) == 0 || c.GrpcClientCAPath != "", "grpc_allowed_clients requires grpc_client_ca_path")
+	checkNotNegative("grpc_default_timeout", int64(c.GrpcDefaultTimeout))
+
+	check(c.JWTPublicPath != "", "jwt_public_key is required")
+
+	checkAddr("db_addr", c.DatabaseAddr)
+
+	for _, addr := range c.DatabaseReplicaAddrs {
+		checkAddr("db_replica_addrs", addr)
+	}
+
+	check(c.DatabaseUser != "", "db_user is required")
+	check(c.DatabaseName != "", "db_database is required")
+	check(c.DatabaseTLS.valid(), "db_tls must be one of disable, require, verify, got %q", c.DatabaseTLS)
+	check(c.DatabaseTLSCAPath == "" || c.DatabaseTLS == TLSVerify, "db_tls_ca_path requires db_tls to be verify")
+	checkNotNegative("db_dial_timeout", int64(c.DatabaseDialTimeout))
+	checkNotNegative("db_read_timeout", int64(c.DatabaseReadTimeout))
+	checkNotNegative("db_write_timeout", int64(c.DatabaseWriteTimeout))
+	checkNotNegative("db_pool_size", int64(c.DatabasePoolSize))
+	checkNotNegative("db_statement_timeout", int64(c.DatabaseStatementTimeout))
+
+	// the room, queue & session keys are shared with the other services (see github.com/sakuraapp/shared/pkg/constant),
+	// they can't go to a cluster until their layouts are hash-tagged there, since a room's scripts & pipelines span several of them
+	check(c.RedisMode != "cluster", "redis_mode cluster isn't supported yet, the shared room & session key layouts aren't hash-tagged")
+	check(c.RedisMode == "cluster" || c.RedisMode.valid(), "redis_mode must be one of standalone, sentinel, got %q", c.RedisMode)
+
+	switch c.RedisMode {
+	case RedisStandalone:
+		checkAddr("redis_addr", c.RedisAddr)
+	case RedisSentinel:
+		check(len(c.RedisAddrs) > 0, "redis_addrs is required when redis_mode is sentinel")
+
+		for _, addr := range c.RedisAddrs {
+			checkAddr("redis_addrs", addr)
+		}
+
+		check(c.RedisMasterName != "", "redis_master_name is required when redis_mode is sentinel")
+	}
+
+	check(c.RedisDatabase >= 0, "redis_database can't be negative")
+	check(c.RedisTLS.valid(), "redis_tls must be one of disable, require, verify, got %q", c.RedisTLS)
+	check(c.RedisTLSCAPath == "" || c.RedisTLS == TLSVerify, "redis_tls_ca_path requires redis_tls to be verify")
+	checkNotNegative("redis_dial_timeout", int64(c.RedisDialTimeout))
+	checkNotNegative("redis_read_timeout", int64(c.RedisReadTimeout))
+	checkNotNegative("redis_write_timeout", int64(c.RedisWriteTimeout))
+
+	checkOneOf("pubsub_backend", c.PubsubBackend, "", "redis", "memory", "nats")
+	check(c.PubsubBackend != "nats" || c.NatsUrl != "", "nats_url is required when pubsub_backend is nats")
+
+	checkPositive("crawler.connect_timeout", int64(c.Crawler.ConnectTimeout))
+	checkPositive("crawler.timeout", int64(c.Crawler.Timeout))
+	checkPositive("crawler.max_body_size", c.Crawler.MaxBodySize)
+	checkNotNegative("crawler.max_redirects", int64(c.Crawler.MaxRedirects))
+	checkNotNegative("media_cache_ttl", int64(c.MediaCacheTTL))
+	checkNotNegative("media_negative_cache_ttl", int64(c.MediaNegativeCacheTTL))
+	checkPositive("media_max_concurrent_crawls", int64(c.MediaMaxConcurrentCrawls))
+
+	checkNotNegative("room_events_max_len", c.RoomEventsMaxLen)
+	checkNotNegative("room_events_retention", int64(c.RoomEventsRetention))
+	checkNotNegative("drain_timeout", int64(c.DrainTimeout))
+	checkNotNegative("drain_reconnect_spread", int64(c.DrainReconnectSpread))
+
+	checkOneOf("tracing_exporter", c.TracingExporter, "", "otlp", "stdout")
+	check(c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1, "tracing_sample_ratio must be between 0 and 1, got %v", c.TracingSampleRatio)
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
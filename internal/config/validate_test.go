@@ -0,0 +1,152 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConfig returns a valid config, which the tests then break one setting at a time
+func testConfig() *Config {
+	c := Default()
+	c.Port = 9000
+	c.GrpcPort = 9001
+	c.GrpcInsecure = true
+	c.JWTPublicPath = "jwt.pem"
+	c.DatabaseUser = "sakura"
+	c.DatabaseName = "sakura"
+	c.fillDefaults()
+
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		err    string // part of the only error expected, empty when the config is valid
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"app_env", func(c *Config) { c.Env = "TEST" }, "app_env must be one of"},
+		{"port", func(c *Config) { c.Port = 0 }, "port must be between 1 and 65535"},
+		{"port range", func(c *Config) { c.Port = 65536 }, "port must be between 1 and 65535"},
+		{"no admin_port", func(c *Config) { c.AdminPort = 0 }, ""},
+		{"admin_port range", func(c *Config) { c.AdminPort = -1 }, "admin_port must be between 1 and 65535"},
+		{"admin_port on port", func(c *Config) { c.AdminPort = c.Port }, "admin_port can't be the same as port"},
+		{"max_connections", func(c *Config) { c.MaxConnections = 0 }, "max_connections must be greater than 0"},
+		{"max_message_size", func(c *Config) { c.MaxMessageSize = 0 }, "max_message_size must be greater than 0"},
+		{"max_rooms", func(c *Config) { c.MaxRooms = -1 }, "max_rooms can't be negative"},
+		{"message_rate_limit", func(c *Config) { c.MessageRateLimit = -1 }, "message_rate_limit can't be negative"},
+		{"message_rate_burst", func(c *Config) { c.MessageRateBurst = 0 }, "message_rate_burst must be greater than 0"},
+		{"no rate limit", func(c *Config) { c.MessageRateLimit, c.MessageRateBurst = 0, 0 }, ""},
+		{"log_level", func(c *Config) { c.LogLevel = "loud" }, "log_level must be a valid level"},
+		{"log_format", func(c *Config) { c.LogFormat = "xml" }, "log_format must be one of"},
+		{"grpc_network", func(c *Config) { c.GrpcNetwork = "udp" }, "grpc_network must be one of"},
+		{"grpc unix socket", func(c *Config) { c.GrpcNetwork, c.GrpcPort, c.GrpcSocketPath = "unix", 0, "/tmp/gateway.sock" }, ""},
+		{"grpc_socket_path", func(c *Config) { c.GrpcNetwork = "unix" }, "grpc_socket_path is required"},
+		{"grpc_port", func(c *Config) { c.GrpcPort = 0 }, "grpc_port must be between 1 and 65535"},
+		{"grpc_port on port", func(c *Config) { c.GrpcPort = c.Port }, "grpc_port can't be the same as port"},
+		{"grpc tls", func(c *Config) { c.GrpcInsecure, c.GrpcCertPath, c.GrpcKeyPath = false, "cert.pem", "key.pem" }, ""},
+		{"grpc_cert_path", func(c *Config) { c.GrpcInsecure, c.GrpcKeyPath = false, "key.pem" }, "grpc_cert_path & grpc_key_path are required"},
+		{"grpc_allowed_clients", func(c *Config) { c.GrpcAllowedClients = []string{"api"} }, "grpc_allowed_clients requires grpc_client_ca_path"},
+		{"grpc_default_timeout", func(c *Config) { c.GrpcDefaultTimeout = -time.Second }, "grpc_default_timeout can't be negative"},
+		{"jwt_public_key", func(c *Config) { c.JWTPublicPath = "" }, "jwt_public_key is required"},
+		{"db_addr", func(c *Config) { c.DatabaseAddr = "localhost" }, "db_addr must be a host:port address"},
+		{"db_replica_addrs", func(c *Config) { c.DatabaseReplicaAddrs = []string{"replica:5432", "replica"} }, "db_replica_addrs must be a host:port address"},
+		{"db_user", func(c *Config) { c.DatabaseUser = "" }, "db_user is required"},
+		{"db_database", func(c *Config) { c.DatabaseName = "" }, "db_database is required"},
+		{"db_tls", func(c *Config) { c.DatabaseTLS = "maybe" }, "db_tls must be one of"},
+		{"db_tls_ca_path", func(c *Config) { c.DatabaseTLS, c.DatabaseTLSCAPath = TLSRequire, "ca.pem" }, "db_tls_ca_path requires db_tls to be verify"},
+		{"db_dial_timeout", func(c *Config) { c.DatabaseDialTimeout = -time.Second }, "db_dial_timeout can't be negative"},
+		{"db_read_timeout", func(c *Config) { c.DatabaseReadTimeout = -time.Second }, "db_read_timeout can't be negative"},
+		{"db_write_timeout", func(c *Config) { c.DatabaseWriteTimeout = -time.Second }, "db_write_timeout can't be negative"},
+		{"db_pool_size", func(c *Config) { c.DatabasePoolSize = -1 }, "db_pool_size can't be negative"},
+		{"db_statement_timeout", func(c *Config) { c.DatabaseStatementTimeout = -time.Second }, "db_statement_timeout can't be negative"},
+		{"redis_mode", func(c *Config) { c.RedisMode = "replicated" }, "redis_mode must be one of standalone, sentinel"},
+		{"redis cluster", func(c *Config) { c.RedisMode, c.RedisAddrs = "cluster", []string{"node:6379"} }, "redis_mode cluster isn't supported yet"},
+		{"redis_addr", func(c *Config) { c.RedisAddr = "redis" }, "redis_addr must be a host:port address"},
+		{"redis sentinel", func(c *Config) {
+			c.RedisMode, c.RedisAddr, c.RedisAddrs, c.RedisMasterName = RedisSentinel, "", []string{"sentinel:26379"}, "mymaster"
+		}, ""},
+		{"redis_addrs", func(c *Config) { c.RedisMode, c.RedisMasterName = RedisSentinel, "mymaster" }, "redis_addrs is required when redis_mode is sentinel"},
+		{"redis_addrs address", func(c *Config) {
+			c.RedisMode, c.RedisAddrs, c.RedisMasterName = RedisSentinel, []string{"sentinel"}, "mymaster"
+		}, "redis_addrs must be a host:port address"},
+		{"redis_master_name", func(c *Config) { c.RedisMode, c.RedisAddrs = RedisSentinel, []string{"sentinel:26379"} }, "redis_master_name is required"},
+		{"redis_database", func(c *Config) { c.RedisDatabase = -1 }, "redis_database can't be negative"},
+		{"redis_database sentinel", func(c *Config) {
+			c.RedisMode, c.RedisAddrs, c.RedisMasterName, c.RedisDatabase = RedisSentinel, []string{"sentinel:26379"}, "mymaster", 2
+		}, ""},
+		{"redis_tls", func(c *Config) { c.RedisTLS = "maybe" }, "redis_tls must be one of"},
+		{"redis_tls_ca_path", func(c *Config) { c.RedisTLSCAPath = "ca.pem" }, "redis_tls_ca_path requires redis_tls to be verify"},
+		{"redis_dial_timeout", func(c *Config) { c.RedisDialTimeout = -time.Second }, "redis_dial_timeout can't be negative"},
+		{"redis_read_timeout", func(c *Config) { c.RedisReadTimeout = -time.Second }, "redis_read_timeout can't be negative"},
+		{"redis_write_timeout", func(c *Config) { c.RedisWriteTimeout = -time.Second }, "redis_write_timeout can't be negative"},
+		{"pubsub_backend", func(c *Config) { c.PubsubBackend = "kafka" }, "pubsub_backend must be one of"},
+		{"pubsub memory", func(c *Config) { c.PubsubBackend = "memory" }, ""},
+		{"nats_url", func(c *Config) { c.PubsubBackend = "nats" }, "nats_url is required"},
+		{"crawler.connect_timeout", func(c *Config) { c.Crawler.ConnectTimeout = 0 }, "crawler.connect_timeout must be greater than 0"},
+		{"crawler.timeout", func(c *Config) { c.Crawler.Timeout = 0 }, "crawler.timeout must be greater than 0"},
+		{"crawler.max_body_size", func(c *Config) { c.Crawler.MaxBodySize = 0 }, "crawler.max_body_size must be greater than 0"},
+		{"crawler.max_redirects", func(c *Config) { c.Crawler.MaxRedirects = -1 }, "crawler.max_redirects can't be negative"},
+		{"media_cache_ttl", func(c *Config) { c.MediaCacheTTL = -time.Second }, "media_cache_ttl can't be negative"},
+		{"media_negative_cache_ttl", func(c *Config) { c.MediaNegativeCacheTTL = -time.Second }, "media_negative_cache_ttl can't be negative"},
+		{"media_max_concurrent_crawls", func(c *Config) { c.MediaMaxConcurrentCrawls = 0 }, "media_max_concurrent_crawls must be greater than 0"},
+		{"room_events_max_len", func(c *Config) { c.RoomEventsMaxLen = -1 }, "room_events_max_len can't be negative"},
+		{"room_events_retention", func(c *Config) { c.RoomEventsRetention = -time.Second }, "room_events_retention can't be negative"},
+		{"drain_timeout", func(c *Config) { c.DrainTimeout = -time.Second }, "drain_timeout can't be negative"},
+		{"drain_reconnect_spread", func(c *Config) { c.DrainReconnectSpread = -time.Second }, "drain_reconnect_spread can't be negative"},
+		{"tracing_exporter", func(c *Config) { c.TracingExporter = "jaeger" }, "tracing_exporter must be one of"},
+		{"tracing_sample_ratio", func(c *Config) { c.TracingSampleRatio = 1.5 }, "tracing_sample_ratio must be between 0 and 1"},
+	}
+
+	for _, test := range tests {
+		c := testConfig()
+		test.modify(c)
+
+		err := c.Validate()
+
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%v: %v", test.name, err)
+			}
+
+			continue
+		}
+
+		var errs ValidationError
+
+		if !errors.As(err, &errs) {
+			t.Errorf("%v: expected a ValidationError, got %v", test.name, err)
+			continue
+		}
+
+		if len(errs) != 1 || !strings.Contains(errs[0], test.err) {
+			t.Errorf("%v: expected only %q, got %q", test.name, test.err, []string(errs))
+		}
+	}
+}
+
+func TestValidateAggregates(t *testing.T) {
+	c := testConfig()
+	c.Port = 0
+	c.LogFormat = "xml"
+	c.DatabaseUser = ""
+	c.Crawler.Timeout = 0
+
+	var errs ValidationError
+
+	if err := c.Validate(); !errors.As(err, &errs) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+
+	if len(errs) != 4 {
+		t.Fatalf("expected every invalid setting to be reported, got %q", []string(errs))
+	}
+
+	// the message lists them all, one per line
+	if lines := strings.Split(errs.Error(), "\n"); len(lines) != 5 {
+		t.Fatalf("expected a line per setting, got %q", errs.Error())
+	}
+}
@@ -0,0 +1,18 @@
+package constant
+
+import "github.com/sakuraapp/shared/pkg/constant"
+
+// Keys that are specific to the gateway, the shared ones live in github.com/sakuraapp/shared/pkg/constant
+const (
+	// built on the shared room layout, so they follow it if it changes
+	RoomSeqFmt    = constant.RoomFmt + ".seq"
+	RoomEventsFmt = constant.RoomFmt + ".events"
+
+	NodesKey        = "nodes"            // every node that registered, alive or not
+	NodeFmt         = "node.%v"          // heartbeat, expires when the node stops sending them
+	NodeSessionsFmt = "node.%v.sessions" // sessions connected to the node
+	NodeReaperKey   = "nodes.reaper"     // lock held by the node that's cleaning up after dead nodes
+
+	ConfigOverridesKey     = "config.overrides"         // runtime config overrides shared by every node (json)
+	ConfigOverridesChannel = "config.overrides.updates" // published to whenever the overrides change
+)
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/sakuraapp/gateway/internal/metrics"
+	log "github.com/sirupsen/logrus"
+	"sync/atomic"
+	"time"
+)
+
+// replicaRetryDelay is how long reads skip a replica after it couldn't be reached
+const replicaRetryDelay = 10 * time.Second
+
+type Role string
+
+const (
+	RolePrimary Role = "primary"
+	RoleReplica Role = "replica"
+)
+
+type replica struct {
+	db        *pg.DB
+	downUntil int64 // unix nanoseconds, accessed atomically
+}
+
+func (r *replica) up() bool {
+	return time.Now().UnixNano() >= atomic.LoadInt64(&r.downUntil)
+}
+
+// DB sends writes to the primary & spreads reads over the replicas, reads fall back to the primary when no replica can be reached
+type DB struct {
+	primary  *pg.DB
+	replicas []*replica
+	next     uint32 // accessed atomically
+}
+
+func New(primary *pg.DB, replicas ...*pg.DB) *DB {
+	d := &DB{primary: primary}
+
+	for _, db := range replicas {
+		d.replicas = append(d.replicas, &replica{db: db})
+	}
+
+	return d
+}
+
+// Primary returns the primary, for writes & for reads that can't be stale
+func (d *DB) Primary() *pg.DB {
+	return d.primary
+}
+
+// Read runs a read only query on a replica (in turns), or on the primary when there's none or none can be reached
+// replicas lag behind the primary, so it shouldn't be used to read something that was just written
+func (d *DB) Read(fn func(db orm.DB) error) error {
+	n := len(d.replicas)
+
+	if n > 0 {
+		start := int(atomic.AddUint32(&d.next, 1))
+
+		for i := 0; i < n; i++ {
+			r := d.replicas[(start+i)%n]
+
+			if !r.up() {
+				continue
+			}
+
+			err := fn(r.db)
+
+			if !isConnError(err) {
+				return err
+			}
+
+			atomic.StoreInt64(&r.downUntil, time.Now().Add(replicaRetryDelay).UnixNano())
+
+			log.WithError(err).
+				WithField("addr", r.db.Options().Addr).
+				Warn("Postgres replica unreachable, reading from another server")
+		}
+
+		metrics.PostgresReplicaFallbacks.Inc()
+	}
+
+	return fn(d.primary)
+}
+
+// ForEach calls fn with the primary & each replica, i.e. to add query hooks
+func (d *DB) ForEach(fn func(db *pg.DB, role Role)) {
+	fn(d.primary, RolePrimary)
+
+	for _, r := range d.replicas {
+		fn(r.db, RoleReplica)
+	}
+}
+
+// Ping checks the primary, the replicas being unreachable only slows reads down
+func (d *DB) Ping(ctx context.Context) error {
+	return d.primary.Ping(ctx)
+}
+
+// isConnError reports whether err means the server couldn't be reached, as opposed to the query failing or returning nothing
+func isConnError(err error) bool {
+	if err == nil || errors.Is(err, pg.ErrNoRows) || errors.Is(err, pg.ErrMultiRows) {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr pg.Error
+
+	return !errors.As(err, &pgErr)
+}
@@ -1,12 +1,29 @@
 package gateway
 
 import (
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/sakuraapp/gateway/internal/client"
 	"github.com/sakuraapp/shared/pkg/resource/opcode"
 	log "github.com/sirupsen/logrus"
 )
 
+// Errors returned by room operations when a request can't be fulfilled, websocket clients are never told about them
+var (
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrNotFound       = errors.New("not found")
+
+	ErrInvalidUrl    = fmt.Errorf("%w: invalid url", ErrInvalidRequest)
+	ErrInvalidRole   = fmt.Errorf("%w: invalid role", ErrInvalidRequest)
+	ErrRoomNotFound  = fmt.Errorf("%w: room not found", ErrNotFound)
+	ErrUserNotInRoom = fmt.Errorf("%w: user is not in the room", ErrNotFound)
+	ErrItemNotFound  = fmt.Errorf("%w: queue item not found", ErrNotFound)
+
+	ErrInvalidTarget       = fmt.Errorf("%w: invalid target", ErrInvalidRequest)
+	ErrInvalidNotification = fmt.Errorf("%w: invalid notification type", ErrInvalidRequest)
+)
+
 type ErrorCode int
 
 const (
@@ -22,6 +39,7 @@ const (
 	ErrorRemoveClient
 	ErrorParse
 	ErrorSerialize
+	ErrorRoomLimit
 )
 
 var errorMessages = map[ErrorCode]string{
@@ -37,6 +55,7 @@ var errorMessages = map[ErrorCode]string{
 	ErrorRemoveClient: "Failed to remove client from room",
 	ErrorParse: "Failed to parse data",
 	ErrorSerialize: "Failed to serialize data",
+	ErrorRoomLimit: "Node can't host more rooms",
 }
 
 type Error interface {
@@ -49,6 +68,18 @@ type BaseError struct {
 	code ErrorCode
 }
 
+func (e *BaseError) Error() string {
+	return fmt.Sprintf("%v: %v", errorMessages[e.code], e.err)
+}
+
+func (e *BaseError) Unwrap() error {
+	return e.err
+}
+
+func (e *BaseError) Code() ErrorCode {
+	return e.code
+}
+
 func (e *BaseError) dispatch(c *client.Client) error {
 	return c.Send(opcode.Error, e.id)
 }
@@ -80,6 +111,17 @@ func NewError(code ErrorCode, err error) *BaseError {
 	}
 }
 
+// WrapError turns err into a gateway error, errors that already are gateway errors keep their original code
+func WrapError(code ErrorCode, err error) *BaseError {
+	var gErr *BaseError
+
+	if errors.As(err, &gErr) {
+		return gErr
+	}
+
+	return NewError(code, err)
+}
+
 func NewAuthError(err error) *AuthError {
 	return &AuthError{err: err}
 }
\ No newline at end of file
@@ -0,0 +1,10 @@
+package gateway
+
+import "github.com/sakuraapp/pubsub"
+
+// Message filters that are specific to the gateway, they start far from the shared ones so both can grow
+const (
+	MessageFilterSequence pubsub.MessageFilterKind = 100 + iota // the room's sequence number at the time the message was dispatched
+	MessageFilterStreamId                                        // id of the message in the room's event log
+	MessageFilterTrace                                           // trace context of the dispatch (w3c headers), so the receiving nodes join the same trace
+)
@@ -0,0 +1,21 @@
+package gateway
+
+import "github.com/sakuraapp/shared/pkg/resource"
+
+// Notification types that are specific to the gateway, they pick up where the shared ones stop
+const (
+	NotificationSystem resource.NotificationType = resource.NotificationJoinRequest + 1 + iota
+	NotificationRoomStarted
+	NotificationRoomReported
+)
+
+var notificationTypes = map[resource.NotificationType]bool{
+	resource.NotificationJoinRequest: true,
+	NotificationSystem:               true,
+	NotificationRoomStarted:          true,
+	NotificationRoomReported:         true,
+}
+
+func IsValidNotificationType(t resource.NotificationType) bool {
+	return notificationTypes[t]
+}
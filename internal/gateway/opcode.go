@@ -0,0 +1,13 @@
+package gateway
+
+import "github.com/sakuraapp/shared/pkg/resource/opcode"
+
+// Opcodes that are specific to the gateway, they pick up where the shared ones stop
+const (
+	QueueItemUpdate opcode.Opcode = 26 + iota
+	CloseRoom
+	Resync // tells clients to refetch the room's state, since they might have missed updates
+	FetchRoomEvents
+	Reconnect // asks clients to move to another node, with their session & a delay (so they don't all reconnect at once)
+	DisconnectUser
+)
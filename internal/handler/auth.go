@@ -1,10 +1,13 @@
 package handler
 
 import (
+	"context"
 	"fmt"
 	"github.com/go-pg/pg/v10"
+	"github.com/mitchellh/mapstructure"
 	"github.com/sakuraapp/gateway/internal/client"
 	"github.com/sakuraapp/gateway/internal/gateway"
+	"github.com/sakuraapp/gateway/internal/tracing"
 	"github.com/sakuraapp/shared/pkg/constant"
 	dispatcher "github.com/sakuraapp/shared/pkg/dispatcher/gateway"
 	"github.com/sakuraapp/shared/pkg/model"
@@ -17,12 +20,16 @@ type AuthResponseData struct {
 	SessionId string `json:"sessionId" msgpack:"sessionId"`
 }
 
+type DisconnectUserMessage struct {
+	UserId model.UserId `json:"userId" msgpack:"userId" mapstructure:"userId"`
+}
+
 func (h *Handlers) handleAuthFail(err error, client *client.Client) {
 	log.WithError(err).Error("Authentication failed")
 	client.Disconnect()
 }
 
-func (h *Handlers) HandleAuth(packet *resource.Packet, c *client.Client) gateway.Error {
+func (h *Handlers) HandleAuth(ctx context.Context, packet *resource.Packet, c *client.Client) gateway.Error {
 	data := packet.DataMap()
 	token, ok := data["token"].(string)
 
@@ -37,8 +44,6 @@ func (h *Handlers) HandleAuth(packet *resource.Packet, c *client.Client) gateway
 		return gateway.NewAuthError(err)
 	}
 
-	ctx := c.Context()
-
 	fUserId := claims["id"].(float64)
 	userId := model.UserId(fUserId)
 
@@ -130,6 +135,12 @@ func (h *Handlers) HandleAuth(packet *resource.Packet, c *client.Client) gateway
 		return gateway.NewAuthError(err)
 	}
 
+	err = h.app.GetRepos().Node.AddSession(ctx, nodeId, s.Id)
+
+	if err != nil {
+		return gateway.NewAuthError(err)
+	}
+
 	userTopic := dispatcher.NewUserTarget(s.UserId).Build()
 	sessionTopic := dispatcher.NewSessionTarget(s.Id).Build()
 
@@ -153,6 +164,7 @@ func (h *Handlers) HandleAuth(packet *resource.Packet, c *client.Client) gateway
 
 	if s.RoomId != 0 {
 		h.HandleJoinRoom(
+			ctx,
 			&resource.Packet{
 				Opcode: opcode.JoinRoom,
 				Data:   float64(s.RoomId),
@@ -164,24 +176,27 @@ func (h *Handlers) HandleAuth(packet *resource.Packet, c *client.Client) gateway
 	return nil
 }
 
-func (h *Handlers) HandleDisconnect(data *resource.Packet, c *client.Client) gateway.Error {
-	h.removeClient(c, false)
+func (h *Handlers) HandleDisconnect(ctx context.Context, data *resource.Packet, c *client.Client) gateway.Error {
+	ctx = tracing.WithSpanFrom(h.app.Context(), ctx) // the client's context is done by now
 
 	log.Debugf("OnDisconnect: %v", c.Session.Id)
 
 	s := c.Session
 
-	ctx := h.app.Context()
 	rdb := h.app.GetRedis()
-	pipe := rdb.Pipeline()
-
-	userSessionsKey := fmt.Sprintf(constant.UserSessionsFmt, s.UserId)
 	sessionKey := fmt.Sprintf(constant.SessionFmt, s.Id)
 
-	pipe.SRem(ctx, userSessionsKey, s.Id)
-	pipe.Expire(ctx, sessionKey, client.SessionExpiryDuration)
+	nodeId, err := rdb.HGet(ctx, sessionKey, "node_id").Result()
 
-	_, err := pipe.Exec(ctx)
+	if err == nil && nodeId != h.app.NodeId() {
+		// the session was resumed on another node (i.e. the client was told to reconnect while this node drains)
+		// it belongs to that node now, so only the local state is cleaned up & the room doesn't see the user leave
+		err = h.detachClient(ctx, c)
+	} else {
+		h.removeClient(ctx, c, false)
+
+		err = h.endSession(ctx, s)
+	}
 
 	if err != nil {
 		log.WithError(err).
@@ -189,6 +204,14 @@ func (h *Handlers) HandleDisconnect(data *resource.Packet, c *client.Client) gat
 			Error("Failed to destroy session")
 	}
 
+	err = h.app.GetRepos().Node.RemoveSession(ctx, h.app.NodeId(), s.Id)
+
+	if err != nil {
+		log.WithError(err).
+			WithField("session_id", s.Id).
+			Error("Failed to remove session from the node")
+	}
+
 	userTopic := dispatcher.NewUserTarget(s.UserId).Build()
 	sessionTopic := dispatcher.NewSessionTarget(s.Id).Build()
 
@@ -206,3 +229,60 @@ func (h *Handlers) HandleDisconnect(data *resource.Packet, c *client.Client) gat
 
 	return nil
 }
+
+// endSession removes a session from its user's sessions, it's kept around for a while so it can still be resumed
+func (h *Handlers) endSession(ctx context.Context, s *client.Session) error {
+	userSessionsKey := fmt.Sprintf(constant.UserSessionsFmt, s.UserId)
+	sessionKey := fmt.Sprintf(constant.SessionFmt, s.Id)
+
+	pipe := h.app.GetRedis().Pipeline()
+
+	pipe.SRem(ctx, userSessionsKey, s.Id)
+	pipe.Expire(ctx, sessionKey, client.SessionExpiryDuration)
+
+	_, err := pipe.Exec(ctx)
+
+	return err
+}
+
+// DisconnectUser disconnects every session of a user, on every node
+func (h *Handlers) DisconnectUser(ctx context.Context, userId model.UserId) error {
+	msg := dispatcher.Message{
+		Filters: dispatcher.NewFilterMap().WithType(dispatcher.ServerMessage),
+		Payload: resource.BuildPacket(gateway.DisconnectUser, &DisconnectUserMessage{UserId: userId}),
+	}
+
+	err := h.app.DispatchToContext(ctx, dispatcher.NewUserTarget(userId), &msg)
+
+	if err != nil {
+		return gateway.NewError(gateway.ErrorDispatch, err)
+	}
+
+	return nil
+}
+
+func (h *Handlers) UserDisconnected(msg *dispatcher.Message) {
+	var opts DisconnectUserMessage
+
+	err := mapstructure.Decode(msg.Payload.Data, &opts)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to parse disconnect message")
+		return
+	}
+
+	clientMgr := h.app.GetClientMgr()
+
+	var clients []*client.Client
+
+	// collected first since disconnecting removes the sessions
+	for sessionId := range h.app.GetSessionMgr().GetByUserId(opts.UserId) {
+		if c := clientMgr.Get(sessionId); c != nil {
+			clients = append(clients, c)
+		}
+	}
+
+	for _, c := range clients {
+		c.Disconnect()
+	}
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"context"
+	"github.com/mitchellh/mapstructure"
+	"github.com/sakuraapp/gateway/internal/client"
+	"github.com/sakuraapp/gateway/internal/gateway"
+	"github.com/sakuraapp/gateway/internal/repository"
+	"github.com/sakuraapp/gateway/pkg/util"
+	dispatcher "github.com/sakuraapp/shared/pkg/dispatcher/gateway"
+	"github.com/sakuraapp/shared/pkg/model"
+	"github.com/sakuraapp/shared/pkg/resource"
+	"github.com/sakuraapp/shared/pkg/resource/permission"
+)
+
+const (
+	defaultRoomEventsLimit = 50
+	maxRoomEventsLimit     = 500
+)
+
+type FetchRoomEventsMessage struct {
+	After string `json:"after" mapstructure:"after"` // id of the last event the client has, empty for the latest ones
+	Limit int64  `json:"limit" mapstructure:"limit"`
+}
+
+type RoomEventMessage struct {
+	Id     string          `json:"id"`
+	Seq    int64           `json:"s"`
+	Packet resource.Packet `json:"p"`
+}
+
+// HandleFetchRoomEvents sends a client the events of its room's log, so that late joiners can see what happened recently
+func (h *Handlers) HandleFetchRoomEvents(ctx context.Context, data *resource.Packet, c *client.Client) gateway.Error {
+	roomId := c.Session.RoomId
+
+	if roomId == 0 {
+		return nil
+	}
+
+	var opts FetchRoomEventsMessage
+
+	if data.Data != nil {
+		err := mapstructure.Decode(data.Data, &opts)
+
+		if err != nil {
+			return nil
+		}
+	}
+
+	events, err := h.GetRoomEvents(ctx, roomId, opts.After, opts.Limit)
+
+	if err != nil {
+		return handleError(gateway.ErrorRedis, err)
+	}
+
+	msgs := make([]*RoomEventMessage, 0, len(events))
+
+	for _, event := range events {
+		// events that were only meant for some members (i.e. join requests) stay that way
+		if perms, ok := util.FilterInt(event.Message.Filters, dispatcher.MessageFilterPermissions); ok && perms > 0 {
+			if !c.Session.HasPermission(permission.Permission(perms)) {
+				continue
+			}
+		}
+
+		msgs = append(msgs, &RoomEventMessage{
+			Id:     event.Id,
+			Seq:    event.Seq,
+			Packet: event.Message.Payload,
+		})
+	}
+
+	err = c.Send(gateway.FetchRoomEvents, msgs)
+
+	if err != nil {
+		return gateway.NewError(gateway.ErrorClientSend, err)
+	}
+
+	return nil
+}
+
+// GetRoomEvents returns up to limit events of a room's log that come after afterId (or the latest ones if it's empty)
+func (h *Handlers) GetRoomEvents(ctx context.Context, roomId model.RoomId, afterId string, limit int64) ([]*repository.RoomEvent, error) {
+	repo := h.app.GetRepos().RoomEvent
+
+	if !repo.Enabled() {
+		return []*repository.RoomEvent{}, nil
+	}
+
+	if limit <= 0 {
+		limit = defaultRoomEventsLimit
+	} else if limit > maxRoomEventsLimit {
+		limit = maxRoomEventsLimit
+	}
+
+	events, err := repo.Range(ctx, roomId, afterId, limit)
+
+	if err != nil {
+		return nil, gateway.NewError(gateway.ErrorRedis, err)
+	}
+
+	return events, nil
+}
@@ -1,16 +1,24 @@
 package handler
 
 import (
+	"errors"
+	"sync"
 	"github.com/sakuraapp/gateway/internal/app"
+	"github.com/sakuraapp/gateway/internal/gateway"
 	"github.com/sakuraapp/shared/pkg/resource/opcode"
 )
 
 type Handlers struct {
-	app app.App
+	app    app.App
+	crawls chan struct{} // semaphore of the items being enriched
+	wg     sync.WaitGroup
 }
 
 func Init(app app.App) *Handlers {
-	h := &Handlers{app}
+	h := &Handlers{
+		app:    app,
+		crawls: make(chan struct{}, app.GetConfig().MediaMaxConcurrentCrawls),
+	}
 	m := app.GetHandlerMgr()
 
 	m.Register(opcode.Authenticate, h.HandleAuth)
@@ -27,10 +35,28 @@ func Init(app app.App) *Handlers {
 	m.Register(opcode.KickUser, h.HandleKickUser)
 	m.Register(opcode.AddRole, h.HandleUpdateRole)
 	m.Register(opcode.RemoveRole, h.HandleUpdateRole)
+	m.Register(gateway.FetchRoomEvents, h.HandleFetchRoomEvents)
 
 	m.RegisterServer(opcode.KickUser, h.KickUser)
 	m.RegisterServer(opcode.AddRole, h.UpdateRole)
 	m.RegisterServer(opcode.RemoveRole, h.UpdateRole)
+	m.RegisterServer(gateway.CloseRoom, h.RoomClosed)
+	m.RegisterServer(gateway.DisconnectUser, h.UserDisconnected)
 
 	return h
-}
\ No newline at end of file
+}
+
+// Wait returns once every background task the handlers started (i.e. enriching items) is done
+func (h *Handlers) Wait() {
+	h.wg.Wait()
+}
+
+// handleError turns an error returned by a room operation into a gateway error.
+// Invalid requests are silently ignored for websocket clients, like they always have been
+func handleError(code gateway.ErrorCode, err error) gateway.Error {
+	if err == nil || errors.Is(err, gateway.ErrInvalidRequest) || errors.Is(err, gateway.ErrNotFound) {
+		return nil
+	}
+
+	return gateway.WrapError(code, err)
+}
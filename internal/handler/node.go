@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/sakuraapp/gateway/internal/client"
+	"github.com/sakuraapp/shared/pkg/constant"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReapNode cleans up after a node that died without disconnecting its clients
+// its sessions are removed from their rooms (users that have no session left leave them) & from their users, then left to expire
+// sessions that were resumed on another node in the meantime are left alone
+func (h *Handlers) ReapNode(ctx context.Context, nodeId string) error {
+	nodes := h.app.GetRepos().Node
+	sessionIds, err := nodes.Sessions(ctx, nodeId)
+
+	if err != nil {
+		return err
+	}
+
+	rdb := h.app.GetRedis()
+	reaped := 0
+
+	for _, sessionId := range sessionIds {
+		var s client.Session
+
+		err = rdb.HGetAll(ctx, fmt.Sprintf(constant.SessionFmt, sessionId)).Scan(&s)
+
+		if err != nil && err != redis.Nil {
+			return err
+		}
+
+		if s.UserId == 0 || s.NodeId != nodeId {
+			continue // already expired, or resumed elsewhere
+		}
+
+		s.Id = sessionId
+
+		if s.RoomId != 0 {
+			err = h.removeRoomSession(ctx, &s, false)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		err = h.endSession(ctx, &s)
+
+		if err != nil {
+			return err
+		}
+
+		reaped++
+	}
+
+	log.WithFields(log.Fields{
+		"node_id":  nodeId,
+		"sessions": reaped,
+	}).Info("Reaped dead node")
+
+	return nodes.Forget(ctx, nodeId)
+}
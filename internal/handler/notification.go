@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/sakuraapp/gateway/internal/gateway"
+	"github.com/sakuraapp/pubsub"
+	dispatcher "github.com/sakuraapp/shared/pkg/dispatcher/gateway"
+	"github.com/sakuraapp/shared/pkg/resource"
+	"github.com/sakuraapp/shared/pkg/resource/opcode"
+	"github.com/sakuraapp/shared/pkg/resource/permission"
+)
+
+// SendNotification pushes a notification to every online session of the target (a user, a room or a single session), on whichever node they're connected to
+// perms (optional) restricts it to the sessions that have these permissions in their current room
+func (h *Handlers) SendNotification(ctx context.Context, target pubsub.MessageTarget, notificationType resource.NotificationType, data interface{}, perms permission.Permission) (*resource.Notification, error) {
+	if target == nil {
+		return nil, gateway.ErrInvalidTarget
+	}
+
+	notification, err := newNotification(notificationType, data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.app.DispatchToContext(ctx, target, buildNotificationMessage(notification, perms))
+
+	if err != nil {
+		return nil, gateway.NewError(gateway.ErrorDispatch, err)
+	}
+
+	return notification, nil
+}
+
+// BroadcastNotification pushes a notification to every online session, on every live node
+func (h *Handlers) BroadcastNotification(ctx context.Context, notificationType resource.NotificationType, data interface{}) (*resource.Notification, error) {
+	notification, err := newNotification(notificationType, data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nodeIds, err := h.app.GetRepos().Node.LiveNodes(ctx)
+
+	if err != nil {
+		return nil, gateway.NewError(gateway.ErrorRedis, err)
+	}
+
+	for _, nodeId := range nodeIds {
+		err = h.app.DispatchToContext(ctx, dispatcher.NewNodeTarget(nodeId), buildNotificationMessage(notification, 0))
+
+		if err != nil {
+			return nil, gateway.NewError(gateway.ErrorDispatch, err)
+		}
+	}
+
+	return notification, nil
+}
+
+func newNotification(notificationType resource.NotificationType, data interface{}) (*resource.Notification, error) {
+	if !gateway.IsValidNotificationType(notificationType) {
+		return nil, gateway.ErrInvalidNotification
+	}
+
+	return &resource.Notification{
+		Id:   uuid.NewString(),
+		Type: notificationType,
+		Data: data,
+	}, nil
+}
+
+func buildNotificationMessage(notification *resource.Notification, perms permission.Permission) *dispatcher.Message {
+	filters := dispatcher.NewFilterMap()
+
+	if perms > 0 {
+		filters.WithPermissions(perms)
+	}
+
+	return &dispatcher.Message{
+		Filters: filters,
+		Payload: resource.BuildPacket(opcode.AddNotification, notification),
+	}
+}
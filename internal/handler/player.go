@@ -6,6 +6,7 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/sakuraapp/gateway/internal/client"
 	"github.com/sakuraapp/gateway/internal/gateway"
+	"github.com/sakuraapp/gateway/internal/tracing"
 	"github.com/sakuraapp/pubsub"
 	"github.com/sakuraapp/shared/pkg/constant"
 	dispatcher "github.com/sakuraapp/shared/pkg/dispatcher/gateway"
@@ -27,7 +28,7 @@ func buildState(state *resource.PlayerState) resource.Packet {
 	return resource.BuildPacket(opcode.PlayerState, data)
 }
 
-func (h *Handlers) HandleSetPlayerState(data *resource.Packet, c *client.Client) gateway.Error {
+func (h *Handlers) HandleSetPlayerState(ctx context.Context, data *resource.Packet, c *client.Client) gateway.Error {
 	if c.Session.HasPermission(permission.VIDEO_REMOTE) {
 		var t time.Time
 
@@ -37,9 +38,6 @@ func (h *Handlers) HandleSetPlayerState(data *resource.Packet, c *client.Client)
 			t = time.Unix(data.Time.Int64, 0)
 		}
 
-		ctx := h.app.Context()
-		rdb := h.app.GetRedis()
-
 		m := data.DataMap()
 		state := resource.PlayerState{
 			IsPlaying:     m["playing"].(bool),
@@ -47,39 +45,55 @@ func (h *Handlers) HandleSetPlayerState(data *resource.Packet, c *client.Client)
 			PlaybackStart: t,
 		}
 
-		roomId := c.Session.RoomId
-		stateKey := fmt.Sprintf(constant.RoomStateFmt, roomId)
+		err := h.SetPlayerState(ctx, c.Session.RoomId, &state, c.Session.Id)
 
-		err := h.app.DispatchTo(dispatcher.NewRoomTarget(roomId), &dispatcher.Message{
-			Payload: resource.BuildPacket(opcode.PlayerState, state),
-			Filters: dispatcher.NewFilterMap().WithIgnoredSession(c.Session.Id),
-		})
+		return handleError(gateway.ErrorRedis, err)
+	}
 
-		if err != nil {
-			return gateway.NewError(gateway.ErrorDispatch, err)
-		}
+	return nil
+}
 
-		err = rdb.HSet(ctx,
-			stateKey,
-			"playing",
-			state.IsPlaying,
-			"currentTime",
-			state.CurrentTime,
-			"playbackStart",
-			state.PlaybackStart,
-		).Err()
+// SetPlayerState broadcasts a new player state to a room and stores it, the session that changed it (if any) isn't notified
+func (h *Handlers) SetPlayerState(ctx context.Context, roomId model.RoomId, state *resource.PlayerState, ignoredSessionId string) error {
+	// once dispatched, the state has to be stored even if the caller goes away
+	ctx = tracing.WithSpanFrom(h.app.Context(), ctx)
 
-		if err != nil {
-			return gateway.NewError(gateway.ErrorRedis, err)
-		}
+	filters := dispatcher.NewFilterMap()
+
+	if ignoredSessionId != "" {
+		filters = filters.WithIgnoredSession(ignoredSessionId)
+	}
+
+	err := h.app.DispatchToContext(ctx, dispatcher.NewRoomTarget(roomId), &dispatcher.Message{
+		Payload: resource.BuildPacket(opcode.PlayerState, state),
+		Filters: filters,
+	})
+
+	if err != nil {
+		return gateway.NewError(gateway.ErrorDispatch, err)
+	}
+
+	stateKey := fmt.Sprintf(constant.RoomStateFmt, roomId)
+
+	err = h.app.GetRedis().HSet(ctx,
+		stateKey,
+		"playing",
+		state.IsPlaying,
+		"currentTime",
+		state.CurrentTime,
+		"playbackStart",
+		state.PlaybackStart,
+	).Err()
+
+	if err != nil {
+		return gateway.NewError(gateway.ErrorRedis, err)
 	}
 
 	return nil
 }
 
-func (h *Handlers) HandleSeek(data *resource.Packet, c *client.Client) gateway.Error {
+func (h *Handlers) HandleSeek(ctx context.Context, data *resource.Packet, c *client.Client) gateway.Error {
 	if c.Session.HasPermission(permission.VIDEO_REMOTE) {
-		ctx := h.app.Context()
 		rdb := h.app.GetRedis()
 
 		currentTime := data.Data.(float64)
@@ -87,7 +101,10 @@ func (h *Handlers) HandleSeek(data *resource.Packet, c *client.Client) gateway.E
 		roomId := c.Session.RoomId
 		stateKey := fmt.Sprintf(constant.RoomStateFmt, roomId)
 
-		err := h.app.DispatchTo(dispatcher.NewRoomTarget(roomId), &dispatcher.Message{
+		// once dispatched, the time has to be stored even if the client goes away
+		ctx = tracing.WithSpanFrom(h.app.Context(), ctx)
+
+		err := h.app.DispatchToContext(ctx, dispatcher.NewRoomTarget(roomId), &dispatcher.Message{
 			Payload: resource.BuildPacket(opcode.Seek, currentTime),
 			Filters: dispatcher.NewFilterMap().WithIgnoredSession(c.Session.Id),
 		})
@@ -106,12 +123,12 @@ func (h *Handlers) HandleSeek(data *resource.Packet, c *client.Client) gateway.E
 	return nil
 }
 
-func (h *Handlers) HandleSkip(data *resource.Packet, c *client.Client) gateway.Error {
+func (h *Handlers) HandleSkip(ctx context.Context, data *resource.Packet, c *client.Client) gateway.Error {
 	if !c.Session.HasPermission(permission.VIDEO_REMOTE) {
 		return nil
 	}
 
-	err := h.nextItem(c.Context(), c.Session.RoomId)
+	err := h.nextItem(ctx, c.Session.RoomId)
 
 	if err != nil {
 		return gateway.NewError(gateway.ErrorNextItem, err) // todo: rethink this and whether nextItem should return a regular error or a gateway error
@@ -120,7 +137,7 @@ func (h *Handlers) HandleSkip(data *resource.Packet, c *client.Client) gateway.E
 	return nil
 }
 
-func (h *Handlers) HandleVideoEnd(data *resource.Packet, c *client.Client) gateway.Error {
+func (h *Handlers) HandleVideoEnd(ctx context.Context, data *resource.Packet, c *client.Client) gateway.Error {
 	roomId := c.Session.RoomId
 
 	if roomId == 0 {
@@ -133,7 +150,6 @@ func (h *Handlers) HandleVideoEnd(data *resource.Packet, c *client.Client) gatew
 		return nil
 	}
 
-	ctx := c.Context()
 	rdb := h.app.GetRedis()
 
 	currentItemKey := fmt.Sprintf(constant.RoomCurrentItemFmt, roomId)
@@ -167,7 +183,7 @@ func (h *Handlers) HandleVideoEnd(data *resource.Packet, c *client.Client) gatew
 		totalCount := totalCountCmd.Val()
 
 		if ackCount >= totalCount/2 {
-			err = h.nextItem(h.app.Context(), roomId)
+			err = h.nextItem(ctx, roomId)
 
 			if err != nil {
 				return gateway.NewError(gateway.ErrorNextItem, err)
@@ -179,19 +195,24 @@ func (h *Handlers) HandleVideoEnd(data *resource.Packet, c *client.Client) gatew
 }
 
 func (h *Handlers) nextItem(ctx context.Context, roomId model.RoomId) error {
-	item, err := h.popItem(ctx, roomId)
+	// the queue is popped before the new item is played, the caller going away in between would leave the room with nothing playing
+	ctx = tracing.WithSpanFrom(h.app.Context(), ctx)
 
-	if err != nil {
-		if err == redis.Nil {
-			item = nil
-		} else {
-			return err
-		}
+	var item *resource.MediaItem
+	var duration float64
+
+	entry, err := h.popItem(ctx, roomId)
+
+	if err == nil {
+		item = &entry.MediaItem
+		duration = entry.Duration
+	} else if err != redis.Nil {
+		return err
 	}
 
 	if item != nil {
 		packet := resource.BuildPacket(opcode.QueueRemove, item.Id)
-		err = h.app.DispatchTo(dispatcher.NewRoomTarget(roomId), dispatcher.NewMessage(packet))
+		err = h.app.DispatchToContext(ctx, dispatcher.NewRoomTarget(roomId), dispatcher.NewMessage(packet))
 
 		if err != nil {
 			return err
@@ -208,10 +229,16 @@ func (h *Handlers) nextItem(ctx context.Context, roomId model.RoomId) error {
 		}
 	}
 
-	return h.SetCurrentItem(ctx, roomId, item)
+	return h.setCurrentItem(ctx, roomId, item, duration)
 }
 
+// SetCurrentItem plays an item whose duration isn't known (yet)
 func (h *Handlers) SetCurrentItem(ctx context.Context, roomId model.RoomId, item *resource.MediaItem) error {
+	return h.setCurrentItem(ctx, roomId, item, 0)
+}
+
+// setCurrentItem plays an item, its duration is in seconds (0 if it's unknown)
+func (h *Handlers) setCurrentItem(ctx context.Context, roomId model.RoomId, item *resource.MediaItem, duration float64) error {
 	state := resource.PlayerState{
 		IsPlaying:     false,
 		CurrentTime:   0,
@@ -219,13 +246,13 @@ func (h *Handlers) SetCurrentItem(ctx context.Context, roomId model.RoomId, item
 	}
 
 	packet := resource.BuildPacket(opcode.VideoSet, item)
-	err := h.app.DispatchTo(dispatcher.NewRoomTarget(roomId), pubsub.NewMessage(packet))
+	err := h.app.DispatchToContext(ctx, dispatcher.NewRoomTarget(roomId), pubsub.NewMessage(packet))
 
 	if err != nil {
 		return err
 	}
 
-	err = h.dispatchState(roomId, &state)
+	err = h.dispatchState(ctx, roomId, &state)
 
 	if err != nil {
 		return err
@@ -246,6 +273,7 @@ func (h *Handlers) SetCurrentItem(ctx context.Context, roomId model.RoomId, item
 			"url", item.Url,
 			"title", item.Title,
 			"icon", item.Icon,
+			"duration", duration,
 		)
 	} else {
 		pipe.Del(ctx, currentItemKey)
@@ -273,6 +301,10 @@ func (h *Handlers) getState(ctx context.Context, roomId model.RoomId) (*resource
 		return nil, err
 	}
 
+	if len(vals) == 0 {
+		return nil, redis.Nil
+	}
+
 	playbackStart, err := time.Parse(time.RFC3339Nano, vals["playbackStart"])
 
 	if err != nil {
@@ -299,8 +331,8 @@ func (h *Handlers) getState(ctx context.Context, roomId model.RoomId) (*resource
 	return &state, nil
 }
 
-func (h *Handlers) dispatchState(roomId model.RoomId, state *resource.PlayerState) error {
-	return h.app.DispatchTo(dispatcher.NewRoomTarget(roomId), dispatcher.NewMessage(buildState(state)))
+func (h *Handlers) dispatchState(ctx context.Context, roomId model.RoomId, state *resource.PlayerState) error {
+	return h.app.DispatchToContext(ctx, dispatcher.NewRoomTarget(roomId), dispatcher.NewMessage(buildState(state)))
 }
 
 func (h *Handlers) sendState(ctx context.Context, roomId model.RoomId) error {
@@ -310,11 +342,11 @@ func (h *Handlers) sendState(ctx context.Context, roomId model.RoomId) error {
 		return err
 	}
 
-	return h.dispatchState(roomId, state)
+	return h.dispatchState(ctx, roomId, state)
 }
 
-func (h *Handlers) sendStateToClient(c *client.Client) error {
-	state, err := h.getState(c.Context(), c.Session.RoomId)
+func (h *Handlers) sendStateToClient(ctx context.Context, c *client.Client) error {
+	state, err := h.getState(ctx, c.Session.RoomId)
 
 	if err != nil {
 		return err
@@ -3,9 +3,11 @@ package handler
 import (
 	"context"
 	"fmt"
+	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/sakuraapp/gateway/internal/client"
 	"github.com/sakuraapp/gateway/internal/gateway"
+	"github.com/sakuraapp/gateway/internal/tracing"
 	"github.com/sakuraapp/gateway/pkg/util"
 	"github.com/sakuraapp/pubsub"
 	"github.com/sakuraapp/shared/pkg/constant"
@@ -16,25 +18,67 @@ import (
 	"github.com/sakuraapp/shared/pkg/resource/permission"
 	log "github.com/sirupsen/logrus"
 	"github.com/vmihailenco/msgpack/v5"
-	"io"
 	"net/url"
 )
 
-func (h *Handlers) HandleQueueAdd(data *resource.Packet, c *client.Client) gateway.Error {
+// updateItemInfoScript replaces the stored item if it's still queued, or updates the current item's hash if it's the one playing
+var updateItemInfoScript = redis.NewScript(`
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 1 then
+	redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+	return 1
+end
+
+if redis.call("HGET", KEYS[2], "id") == ARGV[1] then
+	redis.call("HSET", KEYS[2], "title", ARGV[3], "icon", ARGV[4], "duration", ARGV[5])
+	return 1
+end
+
+return 0
+`)
+
+// queueEntry is how an item is stored in the queue, MediaItem has no duration so it's kept alongside once the item's been crawled
+type queueEntry struct {
+	resource.MediaItem
+	Duration float64 `msgpack:"duration,omitempty"` // in seconds
+}
+
+type QueueItemUpdateMessage struct {
+	Id       string  `json:"id" msgpack:"id"`
+	Title    string  `json:"title" msgpack:"title"`
+	Icon     string  `json:"icon" msgpack:"icon"`
+	Duration float64 `json:"duration,omitempty" msgpack:"duration,omitempty"` // in seconds
+}
+
+func (h *Handlers) HandleQueueAdd(ctx context.Context, data *resource.Packet, c *client.Client) gateway.Error {
 	roomId := c.Session.RoomId
 
 	if roomId == 0 || !c.Session.HasPermission(permission.QUEUE_ADD) {
 		return nil
 	}
 
-	inputUrl := data.Data.(string)
-	rawUrl := inputUrl
-	u, err := url.Parse(rawUrl)
+	inputUrl, ok := data.Data.(string)
 
-	if err != nil {
+	if !ok {
 		return nil
 	}
 
+	_, err := h.AddQueueItem(ctx, roomId, c.Session.UserId, inputUrl)
+
+	return handleError(gateway.ErrorRedis, err)
+}
+
+// AddQueueItem queues a url (or plays it right away if nothing else is playing) and returns the created item
+func (h *Handlers) AddQueueItem(ctx context.Context, roomId model.RoomId, author model.UserId, inputUrl string) (*resource.MediaItem, error) {
+	u, err := url.Parse(inputUrl)
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, gateway.ErrInvalidUrl
+	}
+
+	u = util.CanonicalizeURL(u)
+	inputUrl = u.String()
+	rawUrl := inputUrl
+
 	switch util.GetDomain(u) {
 	case "youtube.com":
 		if u.Path == "/watch" {
@@ -43,27 +87,25 @@ func (h *Handlers) HandleQueueAdd(data *resource.Packet, c *client.Client) gatew
 		}
 	}
 
-	itemInfo, err := h.app.GetCrawler().Get(inputUrl)
-
-	if err != nil && err != io.EOF {
-		return gateway.NewError(gateway.ErrorCrawler, err)
-	}
-
+	// the item is queued right away with only its url, the rest of its info is filled in once it's been crawled
 	// note that empty titles & icons are handled client-side
-
-	itemInfo.Url = rawUrl
 	item := resource.MediaItem{
-		Id:            uuid.NewString(),
-		Author:        c.Session.UserId,
-		Type:          resource.MediaItemTypeNormal,
-		MediaItemInfo: itemInfo,
+		Id:     uuid.NewString(),
+		Author: author,
+		Type:   resource.MediaItemTypeNormal,
+		MediaItemInfo: &resource.MediaItemInfo{
+			Url: rawUrl,
+		},
 	}
 
 	queueKey := fmt.Sprintf(constant.RoomQueueFmt, roomId)
 	currentItemKey := fmt.Sprintf(constant.RoomCurrentItemFmt, roomId)
 	queueItemsKey := fmt.Sprintf(constant.RoomQueueItemsFmt, roomId)
 
-	ctx := c.Context()
+	// the item is stored, announced, played & enriched in several steps, which must all happen even if the caller goes away.
+	// they (and the crawl, which outlives the request) are still part of its trace
+	ctx = tracing.WithSpanFrom(h.app.Context(), ctx)
+
 	rdb := h.app.GetRedis()
 
 	pipe := rdb.Pipeline()
@@ -74,15 +116,15 @@ func (h *Handlers) HandleQueueAdd(data *resource.Packet, c *client.Client) gatew
 	_, err = pipe.Exec(ctx)
 
 	if err != nil {
-		return gateway.NewError(gateway.ErrorRedis, err)
+		return nil, gateway.NewError(gateway.ErrorRedis, err)
 	}
 
 	if lenCmd.Val() > 0 || currentCmd.Val() == 1 {
 		// something else is already playing
-		bytes, err := msgpack.Marshal(item)
+		bytes, err := msgpack.Marshal(&queueEntry{MediaItem: item})
 
 		if err != nil {
-			return gateway.NewError(gateway.ErrorSerialize, err)
+			return nil, gateway.NewError(gateway.ErrorSerialize, err)
 		}
 
 		pipe = rdb.Pipeline()
@@ -93,48 +135,146 @@ func (h *Handlers) HandleQueueAdd(data *resource.Packet, c *client.Client) gatew
 		_, err = pipe.Exec(ctx)
 
 		if err != nil {
-			return gateway.NewError(gateway.ErrorRedis, err)
+			return nil, gateway.NewError(gateway.ErrorRedis, err)
 		}
 
 		packet := resource.BuildPacket(opcode.QueueAdd, item)
-		err = h.app.DispatchTo(dispatcher.NewRoomTarget(roomId), pubsub.NewMessage(packet))
+		err = h.app.DispatchToContext(ctx, dispatcher.NewRoomTarget(roomId), pubsub.NewMessage(packet))
 
 		if err != nil {
-			return gateway.NewError(gateway.ErrorDispatch, err)
+			return nil, gateway.NewError(gateway.ErrorDispatch, err)
 		}
 	} else {
-		err = h.SetCurrentItem(h.app.Context(), roomId, &item)
+		err = h.SetCurrentItem(ctx, roomId, &item)
 
 		if err != nil {
-			return gateway.NewError(gateway.ErrorSetCurrentItem, err)
+			return nil, gateway.NewError(gateway.ErrorSetCurrentItem, err)
 		}
 	}
 
-	return nil
+	h.goEnrichItem(ctx, roomId, item, inputUrl)
+
+	return &item, nil
 }
 
-func (h *Handlers) HandleQueueRemove(data *resource.Packet, c *client.Client) gateway.Error {
+// goEnrichItem enriches the item in the background, unless too many items already are (it's then left as it was added)
+func (h *Handlers) goEnrichItem(ctx context.Context, roomId model.RoomId, item resource.MediaItem, inputUrl string) {
+	select {
+	case h.crawls <- struct{}{}:
+	default:
+		log.WithFields(log.Fields{
+			"room_id": roomId,
+			"item_id": item.Id,
+		}).Warn("Too many queue items are being fetched, skipping this one")
+
+		return
+	}
+
+	h.wg.Add(1)
+
+	go func() {
+		defer func() {
+			<-h.crawls
+			h.wg.Done()
+		}()
+
+		h.enrichItem(ctx, roomId, item, inputUrl)
+	}()
+}
+
+// enrichItem crawls an item that was already added and updates it, wherever it currently is (in the queue or playing)
+func (h *Handlers) enrichItem(ctx context.Context, roomId model.RoomId, item resource.MediaItem, inputUrl string) {
+	logger := log.WithFields(log.Fields{
+		"room_id": roomId,
+		"item_id": item.Id,
+	})
+
+	info, err := h.app.GetRepos().Media.Get(ctx, inputUrl)
+
+	if err != nil {
+		logger.WithError(err).Warn("Failed to fetch queue item info")
+		return
+	}
+
+	item.MediaItemInfo = &resource.MediaItemInfo{
+		Title: info.Title,
+		Icon:  info.Icon,
+		Url:   item.Url,
+	}
+
+	duration := info.Duration.Seconds()
+	bytes, err := msgpack.Marshal(&queueEntry{MediaItem: item, Duration: duration})
+
+	if err != nil {
+		logger.WithError(err).Error("Failed to serialize queue item")
+		return
+	}
+
+	queueItemsKey := fmt.Sprintf(constant.RoomQueueItemsFmt, roomId)
+	currentItemKey := fmt.Sprintf(constant.RoomCurrentItemFmt, roomId)
+
+	updated, err := updateItemInfoScript.Run(ctx, h.app.GetRedis(),
+		[]string{queueItemsKey, currentItemKey},
+		item.Id,
+		bytes,
+		item.Title,
+		item.Icon,
+		duration,
+	).Bool()
+
+	if err != nil {
+		logger.WithError(err).Error("Failed to update queue item info")
+		return
+	}
+
+	if !updated {
+		return // the item was removed or skipped in the meantime
+	}
+
+	packet := resource.BuildPacket(gateway.QueueItemUpdate, &QueueItemUpdateMessage{
+		Id:       item.Id,
+		Title:    item.Title,
+		Icon:     item.Icon,
+		Duration: duration,
+	})
+
+	err = h.app.DispatchToContext(ctx, dispatcher.NewRoomTarget(roomId), dispatcher.NewMessage(packet))
+
+	if err != nil {
+		logger.WithError(err).Error("Failed to dispatch queue item update")
+	}
+}
+
+func (h *Handlers) HandleQueueRemove(ctx context.Context, data *resource.Packet, c *client.Client) gateway.Error {
 	roomId := c.Session.RoomId
 
 	if roomId == 0 {
 		return nil
 	}
 
-	queueKey := fmt.Sprintf(constant.RoomQueueFmt, roomId)
-	queueItemsKey := fmt.Sprintf(constant.RoomQueueItemsFmt, roomId)
+	id, ok := data.Data.(string)
 
-	ctx := c.Context()
-	rdb := h.app.GetRedis()
-
-	id := data.Data.(string)
+	if !ok {
+		return nil
+	}
 
 	if !c.Session.HasPermission(permission.QUEUE_EDIT) {
+		queueItemsKey := fmt.Sprintf(constant.RoomQueueItemsFmt, roomId)
+
+		bytes, err := h.app.GetRedis().HGet(ctx, queueItemsKey, id).Bytes()
+
+		if err == redis.Nil {
+			return nil
+		} else if err != nil {
+			return gateway.NewError(gateway.ErrorRedis, err)
+		}
+
 		var item resource.MediaItem
 
-		err := rdb.HGet(ctx, queueItemsKey, id).Scan(&item)
+		err = msgpack.Unmarshal(bytes, &item)
 
 		if err != nil {
-			return gateway.NewError(gateway.ErrorRedis, err)
+			return gateway.NewError(gateway.ErrorParse, err)
 		}
 
 		userId := c.Session.UserId
@@ -145,10 +285,19 @@ func (h *Handlers) HandleQueueRemove(data *resource.Packet, c *client.Client) ga
 		}
 	}
 
-	pipe := rdb.Pipeline()
+	err := h.RemoveQueueItem(ctx, roomId, id)
+
+	return handleError(gateway.ErrorRedis, err)
+}
+
+func (h *Handlers) RemoveQueueItem(ctx context.Context, roomId model.RoomId, id string) error {
+	queueKey := fmt.Sprintf(constant.RoomQueueFmt, roomId)
+	queueItemsKey := fmt.Sprintf(constant.RoomQueueItemsFmt, roomId)
+
+	pipe := h.app.GetRedis().Pipeline()
 
 	pipe.LRem(ctx, queueKey, 1, id)
-	pipe.HDel(ctx, queueItemsKey, id)
+	delCmd := pipe.HDel(ctx, queueItemsKey, id)
 
 	_, err := pipe.Exec(ctx)
 
@@ -156,8 +305,12 @@ func (h *Handlers) HandleQueueRemove(data *resource.Packet, c *client.Client) ga
 		return gateway.NewError(gateway.ErrorRedis, err)
 	}
 
+	if delCmd.Val() == 0 {
+		return gateway.ErrItemNotFound
+	}
+
 	packet := resource.BuildPacket(opcode.QueueRemove, id)
-	err = h.app.DispatchTo(dispatcher.NewRoomTarget(roomId), pubsub.NewMessage(packet))
+	err = h.app.DispatchToContext(ctx, dispatcher.NewRoomTarget(roomId), pubsub.NewMessage(packet))
 
 	if err != nil {
 		return gateway.NewError(gateway.ErrorDispatch, err)
@@ -166,7 +319,52 @@ func (h *Handlers) HandleQueueRemove(data *resource.Packet, c *client.Client) ga
 	return nil
 }
 
-func (h *Handlers) popItem(ctx context.Context, roomId model.RoomId) (*resource.MediaItem, error) {
+// getQueue returns the queued items of a room, in order
+func (h *Handlers) getQueue(ctx context.Context, roomId model.RoomId) ([]*resource.MediaItem, error) {
+	queueKey := fmt.Sprintf(constant.RoomQueueFmt, roomId)
+	itemsKey := fmt.Sprintf(constant.RoomQueueItemsFmt, roomId)
+
+	rdb := h.app.GetRedis()
+	ids, err := rdb.LRange(ctx, queueKey, 0, -1).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*resource.MediaItem, 0, len(ids))
+
+	if len(ids) == 0 {
+		return items, nil
+	}
+
+	vals, err := rdb.HMGet(ctx, itemsKey, ids...).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, val := range vals {
+		str, ok := val.(string)
+
+		if !ok {
+			continue // removed in the meantime
+		}
+
+		var item resource.MediaItem
+
+		err = msgpack.Unmarshal([]byte(str), &item)
+
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, &item)
+	}
+
+	return items, nil
+}
+
+func (h *Handlers) popItem(ctx context.Context, roomId model.RoomId) (*queueEntry, error) {
 	queueKey := fmt.Sprintf(constant.RoomQueueFmt, roomId)
 	itemsKey := fmt.Sprintf(constant.RoomQueueItemsFmt, roomId)
 
@@ -190,14 +388,14 @@ func (h *Handlers) popItem(ctx context.Context, roomId model.RoomId) (*resource.
 		return nil, err
 	}
 
-	var item resource.MediaItem
+	var entry queueEntry
 
 	bytes, _ := getCmd.Bytes()
-	err = msgpack.Unmarshal(bytes, &item)
+	err = msgpack.Unmarshal(bytes, &entry)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &item, nil
+	return &entry, nil
 }
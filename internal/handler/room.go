@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"context"
 	"fmt"
+	"github.com/go-pg/pg/v10"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/mitchellh/mapstructure"
@@ -19,25 +21,36 @@ import (
 )
 
 type RoleUpdateMessage struct {
-	RoomId model.RoomId `json:"roomId" mapstructure:"roomId"`
-	UserId model.UserId `json:"userId" mapstructure:"userId"`
-	RoleId role.Id      `json:"roleId" mapstructure:"roleId"`
+	RoomId model.RoomId `json:"roomId" msgpack:"roomId" mapstructure:"roomId"`
+	UserId model.UserId `json:"userId" msgpack:"userId" mapstructure:"userId"`
+	RoleId role.Id      `json:"roleId" msgpack:"roleId" mapstructure:"roleId"`
 }
 
 type KickUserMessage struct {
-	RoomId model.RoomId `json:"roomId" mapstructure:"roomId"`
-	UserId model.UserId `json:"userId" mapstructure:"userId"`
+	RoomId model.RoomId `json:"roomId" msgpack:"roomId" mapstructure:"roomId"`
+	UserId model.UserId `json:"userId" msgpack:"userId" mapstructure:"userId"`
 }
 
-func (h *Handlers) HandleJoinRoom(data *resource.Packet, c *client.Client) gateway.Error {
+type CloseRoomMessage struct {
+	RoomId model.RoomId `json:"roomId" msgpack:"roomId" mapstructure:"roomId"`
+}
+
+// RoomState is a snapshot of everything going on in a room
+type RoomState struct {
+	Room        *resource.Room
+	CurrentItem *resource.MediaItem
+	PlayerState *resource.PlayerState
+	Queue       []*resource.MediaItem
+	Members     []*resource.RoomMember
+}
+
+func (h *Handlers) HandleJoinRoom(ctx context.Context, data *resource.Packet, c *client.Client) gateway.Error {
 	fRoomId, ok := data.Data.(float64)
 
 	if !ok {
 		return nil
 	}
 
-	ctx := c.Context()
-
 	roomId := model.RoomId(fRoomId)
 	room, err := h.app.GetRepos().Room.Get(ctx, roomId)
 
@@ -45,13 +58,20 @@ func (h *Handlers) HandleJoinRoom(data *resource.Packet, c *client.Client) gatew
 		return gateway.NewError(gateway.ErrorDatabase, err)
 	}
 
+	m := h.app.GetRoomMgr()
+
+	// rooms that already have clients on this node can always be joined
+	if maxRooms := h.app.GetConfig().MaxRooms; maxRooms > 0 && m.Get(roomId) == nil && m.Len() >= maxRooms {
+		return gateway.NewError(gateway.ErrorRoomLimit, fmt.Errorf("node already hosts %v rooms", m.Len()))
+	}
+
 	s := c.Session
 	currRoomId := s.RoomId
 	alreadyInRoom := currRoomId == roomId
 	isRoomOwner := s.UserId == room.OwnerId
 
 	if currRoomId != 0 && !alreadyInRoom {
-		h.HandleLeaveRoom(data, c)
+		h.HandleLeaveRoom(ctx, data, c)
 	}
 
 	userId := s.UserId
@@ -99,7 +119,7 @@ func (h *Handlers) HandleJoinRoom(data *resource.Packet, c *client.Client) gatew
 				},
 			}
 
-			err = h.app.DispatchTo(dispatcher.NewRoomTarget(roomId), &reqMsg)
+			err = h.app.DispatchToContext(ctx, dispatcher.NewRoomTarget(roomId), &reqMsg)
 
 			if err != nil {
 				return gateway.NewError(gateway.ErrorDispatch, err)
@@ -138,7 +158,6 @@ func (h *Handlers) HandleJoinRoom(data *resource.Packet, c *client.Client) gatew
 		return gateway.NewError(gateway.ErrorRedis, err)
 	}
 
-	m := h.app.GetRoomMgr()
 	r := m.Get(roomId)
 
 	if r == nil {
@@ -157,41 +176,16 @@ func (h *Handlers) HandleJoinRoom(data *resource.Packet, c *client.Client) gatew
 		return gateway.NewError(gateway.ErrorRedis, err)
 	}
 
-	// todo: make this code not awful
-	userCount := len(strUserIds)
-	userIds := make([]model.UserId, 0, userCount)
+	userIds := make([]model.UserId, 0, len(strUserIds))
 	userIds = append(userIds, userId) // add current user at the front so we can find their user object easily
+	userIds = append(userIds, parseUserIds(strUserIds, userId)...)
 
-	var intUID int
-	var uid model.UserId
-
-	for _, strUID := range strUserIds {
-		if strUID == strUserId {
-			continue // don't re-add current user
-		}
-
-		intUID, err = strconv.Atoi(strUID)
-
-		if err == nil {
-			uid = model.UserId(intUID)
-
-			userIds = append(userIds, uid)
-		}
-	}
-
-	roomMembers, err := h.app.GetRepos().User.GetRoomMembers(userIds, roomId)
+	members, err := h.getMembers(roomId, userIds)
 
 	if err != nil {
 		return gateway.NewError(gateway.ErrorDatabase, err)
 	}
 
-	members := make([]*resource.RoomMember, 0, len(roomMembers))
-
-	for _, roomMember := range roomMembers {
-		member := builder.NewRoomMember(&roomMember)
-		members = append(members, member)
-	}
-
 	addUserMessage := dispatcher.Message{
 		Payload: resource.BuildPacket(opcode.AddUser, members[0]),
 		Filters: dispatcher.NewFilterMap().WithIgnoredSession(sessionId),
@@ -213,7 +207,7 @@ func (h *Handlers) HandleJoinRoom(data *resource.Packet, c *client.Client) gatew
 		"permissions": roles.Permissions(),
 	}
 
-	err = h.app.DispatchTo(dispatcher.NewRoomTarget(roomId), &addUserMessage)
+	err = h.app.DispatchToContext(ctx, dispatcher.NewRoomTarget(roomId), &addUserMessage)
 
 	if err != nil {
 		return gateway.NewError(gateway.ErrorDispatch, err)
@@ -225,14 +219,109 @@ func (h *Handlers) HandleJoinRoom(data *resource.Packet, c *client.Client) gatew
 		return gateway.NewError(gateway.ErrorClientSend, err)
 	}
 
+	currentItem, err := h.getCurrentItem(ctx, roomId)
+
+	if err != nil {
+		return gateway.WrapError(gateway.ErrorRedis, err)
+	}
+
+	if currentItem != nil {
+		err = c.Send(opcode.VideoSet, currentItem)
+
+		if err != nil {
+			return gateway.NewError(gateway.ErrorClientSend, err)
+		}
+
+		err = h.sendStateToClient(ctx, c)
+
+		if err != nil {
+			return gateway.NewError(gateway.ErrorSendState, err)
+		}
+	}
+
+	return nil
+}
+
+// GetRoom returns a room, or ErrRoomNotFound if it doesn't exist
+func (h *Handlers) GetRoom(ctx context.Context, roomId model.RoomId) (*model.Room, error) {
+	room, err := h.app.GetRepos().Room.Get(ctx, roomId)
+
+	if err == pg.ErrNoRows {
+		return nil, gateway.ErrRoomNotFound
+	}
+
+	if err != nil {
+		return nil, gateway.NewError(gateway.ErrorDatabase, err)
+	}
+
+	return room, nil
+}
+
+// GetRoomState returns the current item, player state, queue and members of a room
+func (h *Handlers) GetRoomState(ctx context.Context, roomId model.RoomId) (*RoomState, error) {
+	room, err := h.GetRoom(ctx, roomId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	currentItem, err := h.getCurrentItem(ctx, roomId)
+
+	if err != nil {
+		return nil, gateway.WrapError(gateway.ErrorRedis, err)
+	}
+
+	state, err := h.getState(ctx, roomId)
+
+	if err == redis.Nil {
+		state = nil
+	} else if err != nil {
+		return nil, gateway.NewError(gateway.ErrorRedis, err)
+	}
+
+	queue, err := h.getQueue(ctx, roomId)
+
+	if err != nil {
+		return nil, gateway.NewError(gateway.ErrorRedis, err)
+	}
+
+	usersKey := fmt.Sprintf(constant.RoomUsersFmt, roomId)
+	strUserIds, err := h.app.GetRedis().SMembers(ctx, usersKey).Result()
+
+	if err != nil {
+		return nil, gateway.NewError(gateway.ErrorRedis, err)
+	}
+
+	members := []*resource.RoomMember{}
+	userIds := parseUserIds(strUserIds, 0)
+
+	if len(userIds) > 0 {
+		members, err = h.getMembers(roomId, userIds)
+
+		if err != nil {
+			return nil, gateway.NewError(gateway.ErrorDatabase, err)
+		}
+	}
+
+	return &RoomState{
+		Room:        h.app.GetBuilder().NewRoom(room),
+		CurrentItem: currentItem,
+		PlayerState: state,
+		Queue:       queue,
+		Members:     members,
+	}, nil
+}
+
+// getCurrentItem returns the item currently playing in a room, or nil if nothing is
+func (h *Handlers) getCurrentItem(ctx context.Context, roomId model.RoomId) (*resource.MediaItem, error) {
 	currentItemKey := fmt.Sprintf(constant.RoomCurrentItemFmt, roomId)
 
-	vals, err := rdb.HGetAll(ctx, currentItemKey).Result()
+	vals, err := h.app.GetRedis().HGetAll(ctx, currentItemKey).Result()
 
-	if err == redis.Nil || len(vals) == 0 {
-		return nil
+	if err == redis.Nil || (err == nil && len(vals) == 0) {
+		return nil, nil
 	} else if err != nil {
-		return gateway.NewError(gateway.ErrorRedis, err)
+		return nil, gateway.NewError(gateway.ErrorRedis, err)
 	}
 
 	intAuthor := int64(0)
@@ -241,17 +330,21 @@ func (h *Handlers) HandleJoinRoom(data *resource.Packet, c *client.Client) gatew
 		intAuthor, err = strconv.ParseInt(vals["author"], 10, 64)
 
 		if err != nil {
-			return gateway.NewError(gateway.ErrorParse, err)
+			return nil, gateway.NewError(gateway.ErrorParse, err)
 		}
 	}
 
 	intType, err := strconv.ParseInt(vals["type"], 10, 64)
 
 	if err != nil {
-		return gateway.NewError(gateway.ErrorParse, err)
+		return nil, gateway.NewError(gateway.ErrorParse, err)
 	}
 
-	currentItem := resource.MediaItem{
+	if vals["id"] == "" {
+		return nil, nil
+	}
+
+	return &resource.MediaItem{
 		Id:     vals["id"],
 		Author: model.UserId(intAuthor),
 		Type:   resource.MediaItemType(intType),
@@ -260,26 +353,50 @@ func (h *Handlers) HandleJoinRoom(data *resource.Packet, c *client.Client) gatew
 			Icon:  vals["icon"],
 			Url:   vals["url"],
 		},
+	}, nil
+}
+
+// getMembers fetches the users with the given ids along with their roles in a room
+func (h *Handlers) getMembers(roomId model.RoomId, userIds []model.UserId) ([]*resource.RoomMember, error) {
+	roomMembers, err := h.app.GetRepos().User.GetRoomMembers(userIds, roomId)
+
+	if err != nil {
+		return nil, err
 	}
 
-	if currentItem.Id != "" {
-		err = c.Send(opcode.VideoSet, currentItem)
+	builder := h.app.GetBuilder()
+	members := make([]*resource.RoomMember, 0, len(roomMembers))
+
+	for _, roomMember := range roomMembers {
+		member := builder.NewRoomMember(&roomMember)
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+// parseUserIds converts the user ids stored in redis, skipping invalid ones and the excluded id
+func parseUserIds(strUserIds []string, excluded model.UserId) []model.UserId {
+	userIds := make([]model.UserId, 0, len(strUserIds))
+
+	for _, strUID := range strUserIds {
+		intUID, err := strconv.Atoi(strUID)
 
 		if err != nil {
-			return gateway.NewError(gateway.ErrorClientSend, err)
+			continue
 		}
 
-		err = h.sendStateToClient(c)
+		uid := model.UserId(intUID)
 
-		if err != nil {
-			return gateway.NewError(gateway.ErrorSendState, err)
+		if uid != excluded {
+			userIds = append(userIds, uid)
 		}
 	}
 
-	return nil
+	return userIds
 }
 
-func (h *Handlers) HandleUpdateRole(data *resource.Packet, c *client.Client) gateway.Error {
+func (h *Handlers) HandleUpdateRole(ctx context.Context, data *resource.Packet, c *client.Client) gateway.Error {
 	s := c.Session
 	roomId := s.RoomId
 
@@ -318,24 +435,8 @@ func (h *Handlers) HandleUpdateRole(data *resource.Packet, c *client.Client) gat
 		return nil
 	}
 
-	ctx := c.Context()
-	rdb := h.app.GetRedis()
-
-	usersKey := fmt.Sprintf(constant.RoomUsersFmt, roomId)
-	isInRoom, err := rdb.SIsMember(ctx, usersKey, opts.UserId).Result()
-
-	if err != nil {
-		return gateway.NewError(gateway.ErrorRedis, err)
-	}
-
-	if !isInRoom {
-		return nil
-	}
-
-	roleRepo := h.app.GetRepos().Role
-
 	if data.Opcode == opcode.RemoveRole {
-		userRoles, err := roleRepo.Get(opts.UserId, roomId)
+		userRoles, err := h.app.GetRepos().Role.Get(opts.UserId, roomId)
 
 		if err != nil {
 			return gateway.NewError(gateway.ErrorDatabase, err)
@@ -356,15 +457,44 @@ func (h *Handlers) HandleUpdateRole(data *resource.Packet, c *client.Client) gat
 		}
 	}
 
+	err = h.SetRole(ctx, roomId, opts.UserId, opts.RoleId, data.Opcode == opcode.AddRole, s.Id)
+
+	return handleError(gateway.ErrorDatabase, err)
+}
+
+// SetRole adds a role to (or removes it from) a member of a room and notifies everyone in it, except the session that made the change (if any)
+func (h *Handlers) SetRole(ctx context.Context, roomId model.RoomId, userId model.UserId, roleId role.Id, add bool, ignoredSessionId string) error {
+	r := role.GetRole(roleId)
+
+	if r == nil {
+		return gateway.ErrInvalidRole
+	}
+
+	usersKey := fmt.Sprintf(constant.RoomUsersFmt, roomId)
+	isInRoom, err := h.app.GetRedis().SIsMember(ctx, usersKey, userId).Result()
+
+	if err != nil {
+		return gateway.NewError(gateway.ErrorRedis, err)
+	}
+
+	if !isInRoom {
+		return gateway.ErrUserNotInRoom
+	}
+
+	roleRepo := h.app.GetRepos().Role
 	userRole := model.UserRole{
-		UserId: opts.UserId,
+		UserId: userId,
 		RoomId: roomId,
 		RoleId: r.Id(),
 	}
 
-	if data.Opcode == opcode.AddRole {
+	var op opcode.Opcode
+
+	if add {
+		op = opcode.AddRole
 		err = roleRepo.Add(&userRole)
 	} else {
+		op = opcode.RemoveRole
 		err = roleRepo.Remove(&userRole)
 	}
 
@@ -372,27 +502,35 @@ func (h *Handlers) HandleUpdateRole(data *resource.Packet, c *client.Client) gat
 		return gateway.NewError(gateway.ErrorDatabase, err)
 	}
 
+	msgData := &RoleUpdateMessage{
+		RoomId: roomId,
+		UserId: userId,
+		RoleId: roleId,
+	}
+
 	updateServerMsg := dispatcher.Message{
-		Payload: resource.BuildPacket(data.Opcode, &RoleUpdateMessage{
-			RoomId: roomId,
-			UserId: opts.UserId,
-			RoleId: opts.RoleId,
-		}),
+		Payload: resource.BuildPacket(op, msgData),
 		Filters: dispatcher.NewFilterMap().WithType(dispatcher.ServerMessage).WithRoom(roomId),
 	}
 
-	err = h.app.DispatchTo(dispatcher.NewUserTarget(opts.UserId), &updateServerMsg)
+	err = h.app.DispatchToContext(ctx, dispatcher.NewUserTarget(userId), &updateServerMsg)
 
 	if err != nil {
 		return gateway.NewError(gateway.ErrorDispatch, err)
 	}
 
+	filters := dispatcher.NewFilterMap()
+
+	if ignoredSessionId != "" {
+		filters = filters.WithIgnoredSession(ignoredSessionId)
+	}
+
 	updateMsg := dispatcher.Message{
-		Payload: *data,
-		Filters: dispatcher.NewFilterMap().WithIgnoredSession(s.Id),
+		Payload: resource.BuildPacket(op, msgData),
+		Filters: filters,
 	}
 
-	err = h.app.DispatchTo(dispatcher.NewRoomTarget(roomId), &updateMsg)
+	err = h.app.DispatchToContext(ctx, dispatcher.NewRoomTarget(roomId), &updateMsg)
 
 	if err != nil {
 		return gateway.NewError(gateway.ErrorDispatch, err)
@@ -417,7 +555,7 @@ func (h *Handlers) UpdateRole(msg *dispatcher.Message) {
 
 	ignoredSessionId := msg.Filters[dispatcher.MessageFilterIgnoredSession]
 
-	clients := h.app.GetClientMgr().Clients()
+	clientMgr := h.app.GetClientMgr()
 	sessions := h.app.GetSessionMgr().GetByUserId(userId)
 
 	for _, s := range sessions {
@@ -425,9 +563,18 @@ func (h *Handlers) UpdateRole(msg *dispatcher.Message) {
 			continue
 		}
 
-		c := clients[s.Id]
+		c := clientMgr.Get(s.Id)
+
+		if c == nil || s.Roles == nil {
+			continue
+		}
+
+		if msg.Payload.Opcode == opcode.RemoveRole {
+			s.Roles.Remove(roleId)
+		} else {
+			s.Roles.Add(roleId)
+		}
 
-		s.Roles.Add(roleId)
 		err = c.Send(opcode.UpdatePermissions, s.Roles.Permissions())
 
 		if err != nil {
@@ -438,17 +585,9 @@ func (h *Handlers) UpdateRole(msg *dispatcher.Message) {
 	}
 }
 
-func (h *Handlers) removeClient(c *client.Client, updateSession bool) error {
-	s := c.Session
-
-	userId := s.UserId
-	roomId := s.RoomId
-
-	if roomId == 0 {
-		return nil
-	}
-
-	var err error
+// detachClient removes a client from its room on this node, without touching the room's shared state
+func (h *Handlers) detachClient(ctx context.Context, c *client.Client) error {
+	roomId := c.Session.RoomId
 
 	m := h.app.GetRoomMgr()
 	r := m.Get(roomId)
@@ -457,19 +596,46 @@ func (h *Handlers) removeClient(c *client.Client, updateSession bool) error {
 		r.Remove(c)
 
 		if r.NumClients() == 0 {
-			err = m.Delete(h.app.Context(), roomId)
-
-			if err != nil {
-				return err
-			}
+			return m.Delete(ctx, roomId)
 		}
 	}
 
+	return nil
+}
+
+func (h *Handlers) removeClient(ctx context.Context, c *client.Client, updateSession bool) error {
+	s := c.Session
+
+	if s.RoomId == 0 {
+		return nil
+	}
+
+	err := h.detachClient(ctx, c)
+
+	if err != nil {
+		return err
+	}
+
+	err = h.removeRoomSession(ctx, s, updateSession)
+
+	if err != nil {
+		return err
+	}
+
+	s.RoomId = 0
+
+	return nil
+}
+
+// removeRoomSession removes a session from its room's members, the user leaves the room if it was their last session in it
+func (h *Handlers) removeRoomSession(ctx context.Context, s *client.Session, updateSession bool) error {
+	userId := s.UserId
+	roomId := s.RoomId
+
 	usersKey := fmt.Sprintf(constant.RoomUsersFmt, roomId)
 	userSessionsKey := fmt.Sprintf(constant.RoomUserSessionsFmt, roomId, userId)
 	sessionKey := fmt.Sprintf(constant.SessionFmt, s.Id)
 
-	ctx := h.app.Context()
 	rdb := h.app.GetRedis()
 	pipe := rdb.Pipeline()
 
@@ -479,7 +645,7 @@ func (h *Handlers) removeClient(c *client.Client, updateSession bool) error {
 		pipe.HSet(ctx, sessionKey, "room_id", 0)
 	}
 
-	_, err = pipe.Exec(ctx)
+	_, err := pipe.Exec(ctx)
 
 	if err != nil {
 		return err
@@ -502,19 +668,17 @@ func (h *Handlers) removeClient(c *client.Client, updateSession bool) error {
 			Payload: resource.BuildPacket(opcode.RemoveUser, userId),
 		}
 
-		err = h.app.DispatchTo(dispatcher.NewRoomTarget(roomId), &leaveMsg)
+		err = h.app.DispatchToContext(ctx, dispatcher.NewRoomTarget(roomId), &leaveMsg)
 
 		if err != nil {
 			return err
 		}
 	}
 
-	s.RoomId = 0
-
 	return nil
 }
 
-func (h *Handlers) HandleKickUser(data *resource.Packet, c *client.Client) gateway.Error {
+func (h *Handlers) HandleKickUser(ctx context.Context, data *resource.Packet, c *client.Client) gateway.Error {
 	s := c.Session
 	roomId := s.RoomId
 
@@ -541,21 +705,6 @@ func (h *Handlers) HandleKickUser(data *resource.Packet, c *client.Client) gatew
 		return nil
 	}
 
-	ctx := c.Context()
-	rdb := h.app.GetRedis()
-
-	usersKey := fmt.Sprintf(constant.RoomUsersFmt, roomId)
-
-	isInRoom, err := rdb.SIsMember(ctx, usersKey, targetUserId).Result()
-
-	if err != nil {
-		return gateway.NewError(gateway.ErrorRedis, err)
-	}
-
-	if !isInRoom {
-		return nil
-	}
-
 	userRoles, err := h.app.GetRepos().Role.Get(targetUserId, roomId)
 
 	if err != nil {
@@ -578,6 +727,27 @@ func (h *Handlers) HandleKickUser(data *resource.Packet, c *client.Client) gatew
 		return nil
 	}
 
+	err = h.Kick(ctx, roomId, targetUserId)
+
+	return handleError(gateway.ErrorRedis, err)
+}
+
+// Kick removes a user from a room, every session they have in it is notified on whichever node it's connected to
+func (h *Handlers) Kick(ctx context.Context, roomId model.RoomId, targetUserId model.UserId) error {
+	rdb := h.app.GetRedis()
+
+	usersKey := fmt.Sprintf(constant.RoomUsersFmt, roomId)
+
+	isInRoom, err := rdb.SIsMember(ctx, usersKey, targetUserId).Result()
+
+	if err != nil {
+		return gateway.NewError(gateway.ErrorRedis, err)
+	}
+
+	if !isInRoom {
+		return gateway.ErrUserNotInRoom
+	}
+
 	userSessionsKey := fmt.Sprintf(constant.RoomUserSessionsFmt, roomId, targetUserId)
 	sessions, err := rdb.SMembers(ctx, userSessionsKey).Result()
 
@@ -596,7 +766,8 @@ func (h *Handlers) HandleKickUser(data *resource.Packet, c *client.Client) gatew
 		},
 	}
 
-	err = h.app.DispatchTo(dispatcher.NewUserTarget(targetUserId), &kickMsg)
+	// sent to the room rather than the user so that anything else observing the room (like event streams) sees it too
+	err = h.app.DispatchToContext(ctx, dispatcher.NewRoomTarget(roomId), &kickMsg)
 
 	if err != nil {
 		return gateway.NewError(gateway.ErrorDispatch, err)
@@ -622,7 +793,7 @@ func (h *Handlers) HandleKickUser(data *resource.Packet, c *client.Client) gatew
 		Payload: resource.BuildPacket(opcode.RemoveUser, targetUserId),
 	}
 
-	err = h.app.DispatchTo(dispatcher.NewRoomTarget(roomId), &leaveMsg)
+	err = h.app.DispatchToContext(ctx, dispatcher.NewRoomTarget(roomId), &leaveMsg)
 
 	if err != nil {
 		return gateway.NewError(gateway.ErrorDispatch, err)
@@ -631,8 +802,8 @@ func (h *Handlers) HandleKickUser(data *resource.Packet, c *client.Client) gatew
 	return nil
 }
 
-func (h *Handlers) HandleLeaveRoom(data *resource.Packet, c *client.Client) gateway.Error {
-	err := h.removeClient(c, true)
+func (h *Handlers) HandleLeaveRoom(ctx context.Context, data *resource.Packet, c *client.Client) gateway.Error {
+	err := h.removeClient(ctx, c, true)
 
 	if err != nil {
 		return gateway.NewError(gateway.ErrorRemoveClient, err)
@@ -698,7 +869,133 @@ func (h *Handlers) KickUser(msg *dispatcher.Message) {
 	}
 }
 
-func (h *Handlers) HandleAcceptRoomJoinRequest(data *resource.Packet, c *client.Client) gateway.Error {
+// CloseRoom removes everyone from a room and clears its queue & player, on every node
+func (h *Handlers) CloseRoom(ctx context.Context, roomId model.RoomId) error {
+	_, err := h.GetRoom(ctx, roomId)
+
+	if err != nil {
+		return err
+	}
+
+	closeMsg := dispatcher.Message{
+		Filters: dispatcher.NewFilterMap().WithType(dispatcher.ServerMessage),
+		Payload: resource.BuildPacket(gateway.CloseRoom, &CloseRoomMessage{RoomId: roomId}),
+	}
+
+	err = h.app.DispatchToContext(ctx, dispatcher.NewRoomTarget(roomId), &closeMsg)
+
+	if err != nil {
+		return gateway.NewError(gateway.ErrorDispatch, err)
+	}
+
+	rdb := h.app.GetRedis()
+	usersKey := fmt.Sprintf(constant.RoomUsersFmt, roomId)
+
+	strUserIds, err := rdb.SMembers(ctx, usersKey).Result()
+
+	if err != nil {
+		return gateway.NewError(gateway.ErrorRedis, err)
+	}
+
+	keys := []string{
+		usersKey,
+		fmt.Sprintf(constant.RoomJoinRequestsFmt, roomId),
+		fmt.Sprintf(constant.RoomQueueFmt, roomId),
+		fmt.Sprintf(constant.RoomQueueItemsFmt, roomId),
+		fmt.Sprintf(constant.RoomCurrentItemFmt, roomId),
+		fmt.Sprintf(constant.RoomStateFmt, roomId),
+		fmt.Sprintf(constant.RoomVideoEndAckFmt, roomId),
+	}
+
+	keys = append(keys, h.app.GetRepos().RoomEvent.Keys(roomId)...)
+
+	pipe := rdb.Pipeline()
+	sessionCmds := make([]*redis.StringSliceCmd, 0, len(strUserIds))
+
+	for _, strUserId := range strUserIds {
+		userSessionsKey := fmt.Sprintf(constant.RoomUserSessionsFmt, roomId, strUserId)
+
+		keys = append(keys, userSessionsKey)
+		sessionCmds = append(sessionCmds, pipe.SMembers(ctx, userSessionsKey))
+	}
+
+	_, err = pipe.Exec(ctx)
+
+	if err != nil && err != redis.Nil {
+		return gateway.NewError(gateway.ErrorRedis, err)
+	}
+
+	pipe = rdb.Pipeline()
+
+	for _, cmd := range sessionCmds {
+		for _, sessionId := range cmd.Val() {
+			sessionKey := fmt.Sprintf(constant.SessionFmt, sessionId)
+			pipe.HSet(ctx, sessionKey, "room_id", 0)
+		}
+	}
+
+	pipe.Del(ctx, keys...)
+
+	_, err = pipe.Exec(ctx)
+
+	if err != nil {
+		return gateway.NewError(gateway.ErrorRedis, err)
+	}
+
+	return nil
+}
+
+// RoomClosed removes this node's clients from a room that was closed
+func (h *Handlers) RoomClosed(msg *dispatcher.Message) {
+	var opts CloseRoomMessage
+
+	err := mapstructure.Decode(msg.Payload.Data, &opts)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to parse close room message")
+		return
+	}
+
+	roomId := opts.RoomId
+
+	m := h.app.GetRoomMgr()
+	r := m.Get(roomId)
+
+	if r == nil {
+		return
+	}
+
+	r.Mutex().Lock()
+	clients := make([]*client.Client, 0, len(r.Clients()))
+
+	for c := range r.Clients() {
+		clients = append(clients, c)
+	}
+
+	r.Mutex().Unlock()
+
+	logger := log.WithField("room_id", roomId)
+
+	for _, c := range clients {
+		c.Session.RoomId = 0
+
+		err = c.Send(gateway.CloseRoom, roomId)
+
+		if err != nil {
+			logger.WithField("session_id", c.Session.Id).
+				WithError(err).
+				Error("Failed to send close room message to a user")
+		}
+	}
+
+	err = m.Delete(h.app.Context(), roomId)
+
+	if err != nil {
+		logger.WithError(err).Error("Failed to delete closed room")
+	}
+}
+
+func (h *Handlers) HandleAcceptRoomJoinRequest(ctx context.Context, data *resource.Packet, c *client.Client) gateway.Error {
 	s := c.Session
 	roomId := s.RoomId
 
@@ -722,7 +1019,6 @@ func (h *Handlers) HandleAcceptRoomJoinRequest(data *resource.Packet, c *client.
 	targetUserId := model.UserId(fUserId)
 	strUserId := strconv.FormatInt(int64(fUserId), 10)
 
-	ctx := c.Context()
 	rdb := h.app.GetRedis()
 
 	joinRequestsKey := fmt.Sprintf(constant.RoomJoinRequestsFmt, roomId)
@@ -737,7 +1033,7 @@ func (h *Handlers) HandleAcceptRoomJoinRequest(data *resource.Packet, c *client.
 		Payload: resource.BuildPacket(opcode.RoomJoinRequest, roomId),
 	}
 
-	err = h.app.DispatchTo(dispatcher.NewUserTarget(targetUserId), &msg)
+	err = h.app.DispatchToContext(ctx, dispatcher.NewUserTarget(targetUserId), &msg)
 
 	if err != nil {
 		return gateway.NewError(gateway.ErrorDispatch, err)
@@ -33,6 +33,27 @@ func (m *ClientManager) Clients() map[string]*client.Client {
 	return m.clients
 }
 
+// List returns a snapshot of the connected clients
+func (m *ClientManager) List() []*client.Client {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clients := make([]*client.Client, 0, len(m.clients))
+
+	for _, c := range m.clients {
+		clients = append(clients, c)
+	}
+
+	return clients
+}
+
+func (m *ClientManager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.clients)
+}
+
 func (m *ClientManager) Add(c *client.Client) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
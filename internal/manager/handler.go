@@ -1,18 +1,26 @@
 package manager
 
 import (
+	"context"
 	"github.com/sakuraapp/gateway/internal/client"
 	"github.com/sakuraapp/gateway/internal/gateway"
+	"github.com/sakuraapp/gateway/internal/metrics"
+	"github.com/sakuraapp/gateway/internal/tracing"
 	dispatcher "github.com/sakuraapp/shared/pkg/dispatcher/gateway"
 	"github.com/sakuraapp/shared/pkg/resource"
 	"github.com/sakuraapp/shared/pkg/resource/opcode"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"strconv"
+	"time"
 )
 
 // Normal handlers handle client messages
 // Server handles handle server messages, i.e. messages from other servers
 // todo: rework this to use generics once they're out in stable
 
-type HandlerFunc func(packet *resource.Packet, client *client.Client) gateway.Error
+// ctx carries the span of the packet being handled, it's derived from the client's context
+type HandlerFunc func(ctx context.Context, packet *resource.Packet, client *client.Client) gateway.Error
 type HandlerList []HandlerFunc
 type HandlerMap map[opcode.Opcode]HandlerList
 
@@ -42,15 +50,44 @@ func (h *HandlerManager) Register(op opcode.Opcode, fn HandlerFunc) {
 
 func (h *HandlerManager) Handle(packet *resource.Packet, client *client.Client) {
 	list := h.handlers[packet.Opcode]
+	op := strconv.Itoa(int(packet.Opcode))
+
+	metrics.PacketsIn.WithLabelValues(op).Inc()
 
 	if list != nil {
 		var gErr gateway.Error
 		var err error
 
+		ctx, span := tracing.Start(client.Context(), "ws.handle", trace.WithSpanKind(trace.SpanKindServer))
+		span.SetAttributes(tracing.Attr("ws.opcode", int(packet.Opcode)))
+
+		if client.Session != nil {
+			span.SetAttributes(
+				tracing.Attr("session.id", client.Session.Id),
+				tracing.Attr("user.id", int64(client.Session.UserId)),
+				tracing.Attr("room.id", int64(client.Session.RoomId)),
+			)
+		}
+
+		start := time.Now()
+		defer func() {
+			metrics.HandlerDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+			span.End()
+		}()
+
 		for _, handler := range list {
-			gErr = handler(packet, client)
+			gErr = handler(ctx, packet, client)
 
 			if gErr != nil {
+				label := errorLabel(gErr)
+
+				metrics.Errors.WithLabelValues(label).Inc()
+				span.SetStatus(codes.Error, label)
+
+				if e, ok := gErr.(error); ok {
+					span.RecordError(e)
+				}
+
 				err = gErr.Handle(client)
 
 				if err != nil {
@@ -61,6 +98,17 @@ func (h *HandlerManager) Handle(packet *resource.Packet, client *client.Client)
 	}
 }
 
+func errorLabel(err gateway.Error) string {
+	switch e := err.(type) {
+	case *gateway.BaseError:
+		return strconv.Itoa(int(e.Code()))
+	case *gateway.AuthError:
+		return "auth"
+	default:
+		return "unknown"
+	}
+}
+
 func (h *HandlerManager) RegisterServer(op opcode.Opcode, fn ServerHandlerFunc) {
 	if h.serverHandlers[op] == nil {
 		h.serverHandlers[op] = ServerHandlerList{fn}
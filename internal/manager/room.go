@@ -3,6 +3,8 @@ package manager
 import (
 	"context"
 	"github.com/sakuraapp/gateway/internal/client"
+	"github.com/sakuraapp/gateway/internal/gateway"
+	"github.com/sakuraapp/gateway/pkg/util"
 	dispatcher "github.com/sakuraapp/shared/pkg/dispatcher/gateway"
 	"github.com/sakuraapp/shared/pkg/model"
 	"github.com/sakuraapp/shared/pkg/resource/permission"
@@ -14,6 +16,22 @@ type Room struct {
 	id      model.RoomId
 	mu      sync.Mutex
 	clients map[*client.Client]bool
+
+	cursorMu     sync.Mutex
+	lastSeq      int64
+	lastStreamId string
+}
+
+func (r *Room) Id() model.RoomId {
+	return r.id
+}
+
+// Cursor returns the sequence number & event log id of the last message that was dispatched to the room
+func (r *Room) Cursor() (int64, string) {
+	r.cursorMu.Lock()
+	defer r.cursorMu.Unlock()
+
+	return r.lastSeq, r.lastStreamId
 }
 
 func (r *Room) Mutex() *sync.Mutex {
@@ -54,9 +72,26 @@ func (r *Room) Dispatch(msg *dispatcher.Message) {
 			case dispatcher.MessageFilterIgnoredSession:
 				ignoredSessionId = value.(string)
 			case dispatcher.MessageFilterPermissions:
-				perms = value.(permission.Permission)
+				p, _ := util.FilterInt(msg.Filters, filter)
+				perms = permission.Permission(p)
+			}
+		}
+	}
+
+	seq, _ := util.FilterInt(msg.Filters, gateway.MessageFilterSequence)
+
+	if seq > 0 {
+		streamId, _ := msg.Filters[gateway.MessageFilterStreamId].(string)
+
+		r.cursorMu.Lock()
+		if seq > r.lastSeq {
+			r.lastSeq = seq
+
+			if streamId != "" {
+				r.lastStreamId = streamId
 			}
 		}
+		r.cursorMu.Unlock()
 	}
 
 	for c := range r.clients {
@@ -68,7 +103,11 @@ func (r *Room) Dispatch(msg *dispatcher.Message) {
 			continue
 		}
 
-		err = c.Write(msg.Payload)
+		if seq > 0 {
+			err = c.WriteSeq(msg.Payload, seq)
+		} else {
+			err = c.Write(msg.Payload)
+		}
 
 		if err != nil {
 			log.WithError(err).Error("Failed to write message to client")
@@ -89,6 +128,27 @@ func NewRoomManager(subMgr *dispatcher.SubscriptionManager) *RoomManager {
 	}
 }
 
+func (m *RoomManager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.rooms)
+}
+
+// Rooms returns every room that has clients on this node
+func (m *RoomManager) Rooms() []*Room {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rooms := make([]*Room, 0, len(m.rooms))
+
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+
+	return rooms
+}
+
 func (m *RoomManager) Get(roomId model.RoomId) *Room {
 	return m.rooms[roomId]
 }
@@ -20,6 +20,20 @@ func NewSessionManager() *SessionManager {
 	}
 }
 
+// Len returns the number of sessions (i.e. authenticated clients) on this node
+func (s *SessionManager) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+
+	for _, sessions := range s.sessions {
+		n += len(sessions)
+	}
+
+	return n
+}
+
 func (s *SessionManager) Add(session *client.Session) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
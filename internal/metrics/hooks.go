@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"context"
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/go-redis/redis/v8"
+	"github.com/sakuraapp/gateway/pkg/util"
+	"time"
+)
+
+type redisStartKey struct{}
+type crawlStartKey struct{}
+
+// RedisHook records the latency of every redis command, pipelines are recorded as a single "pipeline" command
+type RedisHook struct{}
+
+func (RedisHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (RedisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		RedisDuration.WithLabelValues(cmd.Name()).Observe(time.Since(start).Seconds())
+	}
+
+	return nil
+}
+
+func (RedisHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (RedisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		RedisDuration.WithLabelValues("pipeline").Observe(time.Since(start).Seconds())
+	}
+
+	return nil
+}
+
+// CrawlerHook records the time taken by every crawl, by outcome
+type CrawlerHook struct{}
+
+func (CrawlerHook) BeforeCrawl(ctx context.Context, rawUrl string) context.Context {
+	return context.WithValue(ctx, crawlStartKey{}, time.Now())
+}
+
+func (CrawlerHook) AfterCrawl(ctx context.Context, rawUrl string, err error) {
+	if start, ok := ctx.Value(crawlStartKey{}).(time.Time); ok {
+		CrawlerDuration.WithLabelValues(util.CrawlOutcome(err)).Observe(time.Since(start).Seconds())
+	}
+}
+
+// PostgresHook records the latency of every postgres query, Role tells the primary & the replicas apart
+type PostgresHook struct {
+	Role string
+}
+
+func (PostgresHook) BeforeQuery(ctx context.Context, event *pg.QueryEvent) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h PostgresHook) AfterQuery(ctx context.Context, event *pg.QueryEvent) error {
+	operation := "raw"
+
+	if op, ok := event.Query.(interface{ Operation() orm.QueryOp }); ok {
+		operation = string(op.Operation())
+	}
+
+	PostgresDuration.WithLabelValues(h.Role, operation).Observe(time.Since(event.StartTime).Seconds())
+
+	return nil
+}
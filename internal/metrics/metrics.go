@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "gateway"
+
+var (
+	GrpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "requests_total",
+		Help:      "Number of gRPC calls handled, by method & status code",
+	}, []string{"method", "code"})
+
+	GrpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to handle gRPC calls, by method",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	GrpcActiveStreams = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "active_streams",
+		Help:      "Number of gRPC streams currently open, by method",
+	}, []string{"method"})
+
+	GrpcPanics = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "panics_total",
+		Help:      "Number of panics recovered from while handling gRPC calls, by method",
+	}, []string{"method"})
+
+	PacketsIn = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "packets_in_total",
+		Help:      "Number of packets received from clients, by opcode",
+	}, []string{"opcode"})
+
+	PacketsOut = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "packets_out_total",
+		Help:      "Number of packets written to clients, by opcode",
+	}, []string{"opcode"})
+
+	PacketsRateLimited = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "packets_rate_limited_total",
+		Help:      "Number of packets dropped because their client went over its rate limit",
+	})
+
+	HandlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "handler_duration_seconds",
+		Help:      "Time taken by the handlers of a client packet, by opcode",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"opcode"})
+
+	Errors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_total",
+		Help:      "Number of errors returned by handlers, by error code",
+	}, []string{"code"})
+
+	PubsubLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "pubsub",
+		Name:      "lag_seconds",
+		Help:      "Time between a message being built & it being handled by this node, by message type",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"type"})
+
+	PubsubDecodeFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "pubsub",
+		Name:      "decode_failures_total",
+		Help:      "Number of pubsub messages that couldn't be deserialized",
+	})
+
+	PubsubDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "pubsub",
+		Name:      "dropped_total",
+		Help:      "Number of pubsub messages that were dropped because their topic's queue was full",
+	})
+
+	CrawlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "crawler",
+		Name:      "duration_seconds",
+		Help:      "Time taken to crawl a url, by outcome",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"outcome"})
+
+	RedisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "command_duration_seconds",
+		Help:      "Time taken by redis commands, by command",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command"})
+
+	PostgresDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "postgres",
+		Name:      "query_duration_seconds",
+		Help:      "Time taken by postgres queries, by server (primary or replica) & operation",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"role", "operation"})
+
+	PostgresReplicaFallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "postgres",
+		Name:      "replica_fallbacks_total",
+		Help:      "Number of reads sent to the primary because no replica could be reached",
+	})
+
+	TaskpoolPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "taskpool",
+		Name:      "pending_tasks",
+		Help:      "Number of tasks submitted to the taskpool that haven't started running yet",
+	})
+)
+
+// NewGaugeFunc registers a gauge whose value is read from fn whenever it's collected
+func NewGaugeFunc(name string, help string, fn func() float64) prometheus.GaugeFunc {
+	return promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn)
+}
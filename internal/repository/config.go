@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"github.com/sakuraapp/gateway/internal/constant"
+)
+
+// ConfigRepository stores the runtime config overrides, which every node applies on top of its own config
+type ConfigRepository struct {
+	rdb redis.UniversalClient
+}
+
+// Overrides returns the overrides, in the same layout as a config file
+func (r *ConfigRepository) Overrides(ctx context.Context) (map[string]interface{}, error) {
+	str, err := r.rdb.Get(ctx, constant.ConfigOverridesKey).Result()
+
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var overrides map[string]interface{}
+
+	err = json.Unmarshal([]byte(str), &overrides)
+
+	return overrides, err
+}
+
+// SetOverrides replaces the overrides & tells every node about it, empty overrides are removed
+func (r *ConfigRepository) SetOverrides(ctx context.Context, overrides map[string]interface{}) error {
+	pipe := r.rdb.TxPipeline()
+
+	if len(overrides) == 0 {
+		pipe.Del(ctx, constant.ConfigOverridesKey)
+	} else {
+		bytes, err := json.Marshal(overrides)
+
+		if err != nil {
+			return err
+		}
+
+		pipe.Set(ctx, constant.ConfigOverridesKey, bytes, 0)
+	}
+
+	pipe.Publish(ctx, constant.ConfigOverridesChannel, "")
+
+	_, err := pipe.Exec(ctx)
+
+	return err
+}
+
+// Subscribe returns a subscription that receives a message whenever the overrides change
+func (r *ConfigRepository) Subscribe(ctx context.Context) *redis.PubSub {
+	return r.rdb.Subscribe(ctx, constant.ConfigOverridesChannel)
+}
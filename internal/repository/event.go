@@ -0,0 +1,297 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/sakuraapp/gateway/internal/constant"
+	"github.com/sakuraapp/gateway/internal/gateway"
+	dispatcher "github.com/sakuraapp/shared/pkg/dispatcher/gateway"
+	"github.com/sakuraapp/shared/pkg/model"
+	"github.com/vmihailenco/msgpack/v5"
+	"strconv"
+	"time"
+)
+
+// publishRoomEventScript takes the room's next sequence number, logs the message & publishes it, all at once so messages are always published in sequence.
+// the message is encoded with placeholders for its sequence number & log id, which are swapped for their msgpack encoding once they're known
+// (map entries are self-delimiting, so the rest of the message is unaffected), then it's wrapped the way msgpack.Marshal wraps it (as bin)
+var publishRoomEventScript = redis.NewScript(`
+local function packInt(n)
+	local bytes = {}
+
+	for i = 8, 1, -1 do
+		bytes[i] = n % 256
+		n = math.floor(n / 256)
+	end
+
+	return string.char(0xd3, unpack(bytes))
+end
+
+local function packStr(s)
+	if #s < 32 then
+		return string.char(0xa0 + #s) .. s
+	end
+
+	return string.char(0xd9, #s) .. s
+end
+
+local function packBin(s)
+	if #s < 256 then
+		return string.char(0xc4, #s) .. s
+	elseif #s < 65536 then
+		return string.char(0xc5, math.floor(#s / 256), #s % 256) .. s
+	end
+
+	return string.char(0xc6, math.floor(#s / 16777216), math.floor(#s / 65536) % 256, math.floor(#s / 256) % 256, #s % 256) .. s
+end
+
+local function splice(payload, placeholder, value)
+	local i = string.find(payload, packStr(placeholder), 1, true)
+
+	return string.sub(payload, 1, i - 1) .. value .. string.sub(payload, i + #packStr(placeholder))
+end
+
+local maxLen = tonumber(ARGV[6])
+local retention = tonumber(ARGV[8])
+
+local seq = redis.call("INCR", KEYS[1])
+
+if retention > 0 then
+	redis.call("EXPIRE", KEYS[1], retention)
+end
+
+local payload = splice(ARGV[2], ARGV[3], packInt(seq))
+local id = ""
+
+if maxLen > 0 then
+	-- the live message still goes out if it can't be logged, it just can't be replayed.
+	-- the logged copy has an empty id, it's filled in when the message is replayed
+	local res = redis.pcall("XADD", KEYS[2], "MAXLEN", "~", maxLen, "*", "seq", seq, "op", ARGV[5], "msg", packBin(splice(payload, ARGV[4], packStr(""))))
+
+	if type(res) == "string" then
+		id = res
+
+		if ARGV[7] ~= "" then
+			redis.call("XTRIM", KEYS[2], "MINID", "~", ARGV[7])
+		end
+
+		if retention > 0 then
+			redis.call("EXPIRE", KEYS[2], retention)
+		end
+	end
+end
+
+redis.call("PUBLISH", ARGV[1], packBin(splice(payload, ARGV[4], packStr(id))))
+
+return {seq, id}
+`)
+
+// RoomEvent is an entry of a room's event log
+// entries are stored with the fields "seq" (the room's sequence number), "op" (the packet's opcode) and "msg" (the msgpack encoded message) so other services can read them too
+type RoomEvent struct {
+	Id      string
+	Seq     int64
+	Message *dispatcher.Message
+}
+
+type RoomEventRepository struct {
+	rdb       redis.UniversalClient
+	maxLen    int64
+	retention time.Duration
+}
+
+func (r *RoomEventRepository) Enabled() bool {
+	return r.maxLen > 0
+}
+
+// Keys returns the keys of the room's sequence number & event log, which go away along with the room
+func (r *RoomEventRepository) Keys(roomId model.RoomId) []string {
+	return []string{
+		fmt.Sprintf(constant.RoomSeqFmt, roomId),
+		fmt.Sprintf(constant.RoomEventsFmt, roomId),
+	}
+}
+
+// NextSeq takes the room's next sequence number, the counter expires along with the log
+func (r *RoomEventRepository) NextSeq(ctx context.Context, roomId model.RoomId) (int64, error) {
+	key := fmt.Sprintf(constant.RoomSeqFmt, roomId)
+	pipe := r.rdb.TxPipeline()
+	incrCmd := pipe.Incr(ctx, key)
+
+	if r.retention > 0 {
+		pipe.Expire(ctx, key, r.retention)
+	}
+
+	_, err := pipe.Exec(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return incrCmd.Val(), nil
+}
+
+// Append adds a message to the room's log & trims it, returning the id of the new entry
+func (r *RoomEventRepository) Append(ctx context.Context, roomId model.RoomId, seq int64, msg *dispatcher.Message) (string, error) {
+	bytes, err := msgpack.Marshal(msg)
+
+	if err != nil {
+		return "", err
+	}
+
+	key := fmt.Sprintf(constant.RoomEventsFmt, roomId)
+	pipe := r.rdb.Pipeline()
+
+	addCmd := pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: r.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"seq": seq,
+			"op":  int(msg.Payload.Opcode),
+			"msg": bytes,
+		},
+	})
+
+	if r.retention > 0 {
+		minId := strconv.FormatInt(time.Now().Add(-r.retention).UnixMilli(), 10)
+
+		pipe.XTrimMinIDApprox(ctx, key, minId, 0)
+		pipe.Expire(ctx, key, r.retention)
+	}
+
+	_, err = pipe.Exec(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	return addCmd.Val(), nil
+}
+
+// Publish stamps a message with the room's next sequence number, logs it (if the log is enabled) & publishes it on the channel, atomically.
+// the channel is published to with PUBLISH, so it's only of use when pub/sub goes through the same redis
+func (r *RoomEventRepository) Publish(ctx context.Context, roomId model.RoomId, channel string, msg *dispatcher.Message) (int64, string, error) {
+	seqPlaceholder := uuid.NewString()
+	idPlaceholder := uuid.NewString()
+
+	msg.Filters[gateway.MessageFilterSequence] = seqPlaceholder
+	msg.Filters[gateway.MessageFilterStreamId] = idPlaceholder
+
+	bytes, err := msg.MarshalBinary()
+
+	delete(msg.Filters, gateway.MessageFilterSequence)
+	delete(msg.Filters, gateway.MessageFilterStreamId)
+
+	if err != nil {
+		return 0, "", err
+	}
+
+	minId := ""
+
+	if r.retention > 0 {
+		minId = strconv.FormatInt(time.Now().Add(-r.retention).UnixMilli(), 10)
+	}
+
+	res, err := publishRoomEventScript.Run(ctx, r.rdb, r.Keys(roomId),
+		channel,
+		bytes,
+		seqPlaceholder,
+		idPlaceholder,
+		int(msg.Payload.Opcode),
+		r.maxLen,
+		minId,
+		int64(r.retention.Seconds()),
+	).Slice()
+
+	if err != nil {
+		return 0, "", err
+	}
+
+	seq, _ := res[0].(int64)
+	id, _ := res[1].(string)
+
+	msg.Filters[gateway.MessageFilterSequence] = seq
+
+	if id != "" {
+		msg.Filters[gateway.MessageFilterStreamId] = id
+	}
+
+	return seq, id, nil
+}
+
+// Range returns up to limit entries that come after the given id, in order
+// an empty id returns the latest entries instead
+func (r *RoomEventRepository) Range(ctx context.Context, roomId model.RoomId, afterId string, limit int64) ([]*RoomEvent, error) {
+	key := fmt.Sprintf(constant.RoomEventsFmt, roomId)
+
+	var msgs []redis.XMessage
+	var err error
+
+	if afterId == "" {
+		msgs, err = r.rdb.XRevRangeN(ctx, key, "+", "-", limit).Result()
+
+		if err != nil {
+			return nil, err
+		}
+
+		// latest first, flip them back in order
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+	} else {
+		// the range is inclusive, so fetch one more entry & skip afterId itself
+		msgs, err = r.rdb.XRangeN(ctx, key, afterId, "+", limit+1).Result()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(msgs) > 0 && msgs[0].ID == afterId {
+			msgs = msgs[1:]
+		} else if int64(len(msgs)) > limit {
+			msgs = msgs[:limit]
+		}
+	}
+
+	events := make([]*RoomEvent, 0, len(msgs))
+
+	for _, m := range msgs {
+		event, err := parseRoomEvent(m)
+
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func parseRoomEvent(m redis.XMessage) (*RoomEvent, error) {
+	strSeq, _ := m.Values["seq"].(string)
+	strMsg, _ := m.Values["msg"].(string)
+
+	seq, err := strconv.ParseInt(strSeq, 10, 64)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid room event %v: %w", m.ID, err)
+	}
+
+	var msg dispatcher.Message
+
+	err = msgpack.Unmarshal([]byte(strMsg), &msg)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid room event %v: %w", m.ID, err)
+	}
+
+	return &RoomEvent{
+		Id:      m.ID,
+		Seq:     seq,
+		Message: &msg,
+	}, nil
+}
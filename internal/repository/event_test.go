@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/sakuraapp/gateway/internal/constant"
+	"github.com/sakuraapp/gateway/internal/gateway"
+	"github.com/sakuraapp/gateway/pkg/util"
+	"github.com/sakuraapp/pubsub"
+	dispatcher "github.com/sakuraapp/shared/pkg/dispatcher/gateway"
+	"github.com/sakuraapp/shared/pkg/resource"
+	"github.com/sakuraapp/shared/pkg/resource/opcode"
+	"github.com/vmihailenco/msgpack/v5"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRoomEventRepository(t *testing.T, maxLen int64) (*RoomEventRepository, *miniredis.Miniredis) {
+	srv := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	return &RoomEventRepository{rdb: rdb, maxLen: maxLen, retention: time.Hour}, srv
+}
+
+func newTestMessage(data string) *dispatcher.Message {
+	return dispatcher.NewMessage(resource.Packet{Opcode: opcode.PlayerState, Data: data}, pubsub.FilterMap{
+		dispatcher.MessageFilterRoom: 1,
+	})
+}
+
+func TestRoomEventPublish(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		maxLen int64
+		data   string
+	}{
+		{0, "state"},
+		{100, "state"},
+		{100, strings.Repeat("x", 1024)},  // the length of the wrapped message takes 2 bytes
+		{100, strings.Repeat("x", 1<<16)}, // 4 bytes
+	}
+
+	for _, test := range tests {
+		maxLen := test.maxLen
+		repo, srv := newTestRoomEventRepository(t, maxLen)
+		sub := repo.rdb.Subscribe(ctx, "room.1")
+
+		_, err := sub.Receive(ctx)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := int64(1); i <= 3; i++ {
+			msg := newTestMessage(test.data)
+			seq, id, err := repo.Publish(ctx, 1, "room.1", msg)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if seq != i {
+				t.Fatalf("expected sequence number %d, got %d", i, seq)
+			}
+
+			if (id != "") != repo.Enabled() {
+				t.Fatalf("unexpected log id %q with max length %d", id, maxLen)
+			}
+
+			// the caller's message is stamped too
+			if n, _ := util.FilterInt(msg.Filters, gateway.MessageFilterSequence); n != seq {
+				t.Fatalf("expected the message to be stamped with %d, got %v", seq, msg.Filters[gateway.MessageFilterSequence])
+			}
+
+			received, err := sub.ReceiveMessage(ctx)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var published dispatcher.Message
+
+			err = msgpack.Unmarshal([]byte(received.Payload), &published)
+
+			if err != nil {
+				t.Fatalf("the published message can't be decoded: %v", err)
+			}
+
+			if n, _ := util.FilterInt(published.Filters, gateway.MessageFilterSequence); n != seq {
+				t.Fatalf("expected the published message to carry %d, got %v", seq, published.Filters[gateway.MessageFilterSequence])
+			}
+
+			if streamId, _ := published.Filters[gateway.MessageFilterStreamId].(string); streamId != id {
+				t.Fatalf("expected the published message to carry log id %q, got %q", id, streamId)
+			}
+
+			if n, _ := util.FilterInt(published.Filters, dispatcher.MessageFilterRoom); n != 1 || published.Payload.Data != test.data {
+				t.Fatalf("the rest of the message was altered: %+v", published)
+			}
+		}
+
+		// the counter expires along with the log
+		if ttl := srv.TTL(fmt.Sprintf(constant.RoomSeqFmt, 1)); ttl != repo.retention {
+			t.Fatalf("expected the sequence number to expire in %v, got %v", repo.retention, ttl)
+		}
+
+		events, err := repo.Range(ctx, 1, "", 10)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !repo.Enabled() {
+			if len(events) != 0 {
+				t.Fatalf("expected nothing to be logged, got %d entries", len(events))
+			}
+
+			continue
+		}
+
+		if len(events) != 3 {
+			t.Fatalf("expected 3 entries, got %d", len(events))
+		}
+
+		for i, event := range events {
+			if event.Seq != int64(i+1) {
+				t.Fatalf("expected entry %d to have sequence number %d, got %d", i, i+1, event.Seq)
+			}
+
+			if n, _ := util.FilterInt(event.Message.Filters, gateway.MessageFilterSequence); n != event.Seq {
+				t.Fatalf("expected the logged message to carry %d, got %v", event.Seq, event.Message.Filters[gateway.MessageFilterSequence])
+			}
+		}
+	}
+}
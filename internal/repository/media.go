@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-redis/cache/v8"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/sakuraapp/gateway/pkg/util"
+	"github.com/sakuraapp/shared/pkg/resource"
+	"golang.org/x/sync/singleflight"
+	"time"
+)
+
+const (
+	mediaCacheFmt = "c.media.%v"
+	mediaLockFmt  = mediaCacheFmt + ".lock"
+
+	mediaLockTTL       = 15 * time.Second
+	mediaLockRetryWait = 200 * time.Millisecond
+)
+
+var ErrMediaUnavailable = errors.New("media metadata is unavailable")
+
+// mediaCacheEntry is what's stored in redis, failed lookups are stored too (with a shorter ttl) so they aren't retried on every add
+type mediaCacheEntry struct {
+	Title        string        `msgpack:"title"`
+	Icon         string        `msgpack:"icon"`
+	Url          string        `msgpack:"url"`
+	Duration     time.Duration `msgpack:"duration"`
+	Author       string        `msgpack:"author"`
+	CanonicalUrl string        `msgpack:"canonicalUrl"`
+	Failed       bool          `msgpack:"failed,omitempty"`
+}
+
+func (e *mediaCacheEntry) info() *util.MediaInfo {
+	return &util.MediaInfo{
+		MediaItemInfo: &resource.MediaItemInfo{
+			Title: e.Title,
+			Icon:  e.Icon,
+			Url:   e.Url,
+		},
+		Duration:     e.Duration,
+		Author:       e.Author,
+		CanonicalUrl: e.CanonicalUrl,
+	}
+}
+
+type MediaRepository struct {
+	rdb         redis.UniversalClient
+	cache       *cache.Cache
+	crawler     *util.Crawler
+	group       singleflight.Group
+	ttl         time.Duration
+	negativeTTL time.Duration
+}
+
+// Get returns the metadata of a url, crawling it only if no other lookup (on this node or any other one) is already doing so
+func (m *MediaRepository) Get(ctx context.Context, rawUrl string) (*util.MediaInfo, error) {
+	normalized, err := util.NormalizeURL(rawUrl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(normalized))
+	hash := hex.EncodeToString(sum[:])
+
+	v, err, _ := m.group.Do(hash, func() (interface{}, error) {
+		return m.get(ctx, hash, normalized)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	entry := v.(*mediaCacheEntry)
+
+	if entry.Failed {
+		return nil, ErrMediaUnavailable
+	}
+
+	// the entry is shared by every form of the url, each caller gets its own back
+	info := entry.info()
+	info.Url = rawUrl
+
+	return info, nil
+}
+
+// get & crawl are given the normalized url, so the entry doesn't depend on which form of it was looked up first
+func (m *MediaRepository) get(ctx context.Context, hash string, rawUrl string) (*mediaCacheEntry, error) {
+	key := fmt.Sprintf(mediaCacheFmt, hash)
+	lockKey := fmt.Sprintf(mediaLockFmt, hash)
+
+	entry, err := m.getCached(ctx, key)
+
+	if err != cache.ErrCacheMiss {
+		return entry, err
+	}
+
+	deadline := time.Now().Add(mediaLockTTL)
+	token := uuid.NewString()
+
+	for {
+		locked, err := m.rdb.SetNX(ctx, lockKey, token, mediaLockTTL).Result()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if locked {
+			// the crawl can outlive the lock, which is then only released if no other node took it since.
+			// the caller's context might be done by then, the lock is released regardless
+			defer releaseLockScript.Run(context.Background(), m.rdb, []string{lockKey}, token)
+
+			return m.crawl(ctx, key, rawUrl)
+		}
+
+		// another node is crawling this url, wait for it to fill the cache
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(mediaLockRetryWait):
+		}
+
+		entry, err = m.getCached(ctx, key)
+
+		if err != cache.ErrCacheMiss {
+			return entry, err
+		}
+
+		if time.Now().After(deadline) {
+			return m.crawl(ctx, key, rawUrl)
+		}
+	}
+}
+
+func (m *MediaRepository) getCached(ctx context.Context, key string) (*mediaCacheEntry, error) {
+	entry := new(mediaCacheEntry)
+	err := m.cache.Get(ctx, key, entry)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (m *MediaRepository) crawl(ctx context.Context, key string, rawUrl string) (*mediaCacheEntry, error) {
+	entry := &mediaCacheEntry{Url: rawUrl}
+	ttl := m.ttl
+
+	info, err := m.crawler.Get(ctx, rawUrl)
+
+	// the lookup was given up on (singleflight shares the context of the first caller), which says nothing about the url
+	if err != nil && ctx.Err() != nil {
+		return nil, err
+	}
+
+	if err != nil {
+		entry.Failed = true
+		ttl = m.negativeTTL
+	} else {
+		entry.Title = info.Title
+		entry.Icon = info.Icon
+		entry.Duration = info.Duration
+		entry.Author = info.Author
+		entry.CanonicalUrl = info.CanonicalUrl
+	}
+
+	// failing to cache the result shouldn't fail the lookup itself
+	_ = m.cache.Set(&cache.Item{
+		Ctx:   ctx,
+		Key:   key,
+		Value: entry,
+		TTL:   ttl,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
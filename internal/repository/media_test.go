@@ -0,0 +1,276 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/cache/v8"
+	"github.com/go-redis/redis/v8"
+	"github.com/sakuraapp/gateway/pkg/util"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// the test crawlers can't reach loopback addresses, so every crawl fails
+const testMediaUrl = "http://127.0.0.1/watch?v=1"
+
+// countingHook counts the crawls, and holds them until release is closed if it's set
+type countingHook struct {
+	crawls  int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *countingHook) BeforeCrawl(ctx context.Context, rawUrl string) context.Context {
+	if atomic.AddInt32(&h.crawls, 1) == 1 && h.started != nil {
+		close(h.started)
+	}
+
+	if h.release != nil {
+		<-h.release
+	}
+
+	return ctx
+}
+
+func (h *countingHook) AfterCrawl(ctx context.Context, rawUrl string, err error) {}
+
+func (h *countingHook) count() int {
+	return int(atomic.LoadInt32(&h.crawls))
+}
+
+// setHook signals the first SET sent by a client, i.e. its attempt at taking the lock
+type setHook struct {
+	once sync.Once
+	sent chan struct{}
+}
+
+func (h *setHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *setHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if cmd.Name() == "set" {
+		h.once.Do(func() { close(h.sent) })
+	}
+
+	return nil
+}
+
+func (h *setHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *setHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// newTestMediaRepository returns a node's repository, nodes created with the same server share their cache & locks
+func newTestMediaRepository(t *testing.T, srv *miniredis.Miniredis, hook *countingHook) (*MediaRepository, *redis.Client) {
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	crawler := util.NewCrawler(util.CrawlerOptions{})
+	crawler.AddHook(hook)
+
+	return &MediaRepository{
+		rdb:         rdb,
+		cache:       cache.New(&cache.Options{Redis: rdb}),
+		crawler:     crawler,
+		ttl:         time.Hour,
+		negativeTTL: time.Minute,
+	}, rdb
+}
+
+func mediaHash(t *testing.T, rawUrl string) string {
+	normalized, err := util.NormalizeURL(rawUrl)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha1.Sum([]byte(normalized))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func TestMediaGetCollapsed(t *testing.T) {
+	srv := miniredis.RunT(t)
+	hook := &countingHook{started: make(chan struct{}), release: make(chan struct{})}
+	repo, _ := newTestMediaRepository(t, srv, hook)
+
+	// every form of the url is looked up at once, they all share the first lookup
+	urls := []string{
+		testMediaUrl,
+		testMediaUrl + "&utm_source=share",
+		"HTTP://127.0.0.1:80/watch?v=1#comments",
+	}
+
+	errs := make(chan error, len(urls))
+
+	go func() {
+		_, err := repo.Get(context.Background(), urls[0])
+		errs <- err
+	}()
+
+	<-hook.started
+
+	for _, rawUrl := range urls[1:] {
+		rawUrl := rawUrl
+
+		go func() {
+			_, err := repo.Get(context.Background(), rawUrl)
+			errs <- err
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond) // lets the other lookups join the first one
+	close(hook.release)
+
+	for range urls {
+		if err := <-errs; !errors.Is(err, util.ErrForbiddenAddress) {
+			t.Fatalf("expected the crawl's error, got %v", err)
+		}
+	}
+
+	if n := hook.count(); n != 1 {
+		t.Fatalf("expected a single crawl, got %d", n)
+	}
+}
+
+func TestMediaGetLocked(t *testing.T) {
+	srv := miniredis.RunT(t)
+	hook := &countingHook{started: make(chan struct{}), release: make(chan struct{})}
+	a, _ := newTestMediaRepository(t, srv, hook)
+	b, rdb := newTestMediaRepository(t, srv, hook)
+
+	set := &setHook{sent: make(chan struct{})}
+	rdb.AddHook(set)
+
+	done := make(chan struct{})
+
+	go func() {
+		_, err := a.Get(context.Background(), testMediaUrl)
+
+		if !errors.Is(err, util.ErrForbiddenAddress) {
+			t.Errorf("expected the crawl's error, got %v", err)
+		}
+
+		close(done)
+	}()
+
+	<-hook.started
+
+	lockKey := fmt.Sprintf(mediaLockFmt, mediaHash(t, testMediaUrl))
+
+	if !srv.Exists(lockKey) {
+		t.Fatal("expected the crawling node to hold the lock")
+	}
+
+	// the other node (which has its own singleflight group) waits for the lock's holder instead of crawling
+	go func() {
+		<-set.sent
+		close(hook.release)
+	}()
+
+	_, err := b.Get(context.Background(), testMediaUrl+"&utm_medium=social")
+
+	if !errors.Is(err, ErrMediaUnavailable) {
+		t.Fatalf("expected the cached failure, got %v", err)
+	}
+
+	<-done
+
+	if n := hook.count(); n != 1 {
+		t.Fatalf("expected a single crawl across both nodes, got %d", n)
+	}
+
+	if srv.Exists(lockKey) {
+		t.Fatal("expected the lock to be released")
+	}
+}
+
+func TestMediaNegativeCache(t *testing.T) {
+	srv := miniredis.RunT(t)
+	hook := &countingHook{}
+	repo, _ := newTestMediaRepository(t, srv, hook)
+	ctx := context.Background()
+
+	_, err := repo.Get(ctx, testMediaUrl)
+
+	if !errors.Is(err, util.ErrForbiddenAddress) {
+		t.Fatalf("expected the crawl's error, got %v", err)
+	}
+
+	_, err = repo.Get(ctx, testMediaUrl)
+
+	if !errors.Is(err, ErrMediaUnavailable) {
+		t.Fatalf("expected the cached failure, got %v", err)
+	}
+
+	if n := hook.count(); n != 1 {
+		t.Fatalf("expected the failure to be cached, got %d crawls", n)
+	}
+
+	if ttl := srv.TTL(fmt.Sprintf(mediaCacheFmt, mediaHash(t, testMediaUrl))); ttl != repo.negativeTTL {
+		t.Fatalf("expected the failure to be cached for %v, got %v", repo.negativeTTL, ttl)
+	}
+
+	srv.FastForward(repo.negativeTTL)
+
+	_, err = repo.Get(ctx, testMediaUrl)
+
+	if !errors.Is(err, util.ErrForbiddenAddress) {
+		t.Fatalf("expected the crawl's error, got %v", err)
+	}
+
+	if n := hook.count(); n != 2 {
+		t.Fatalf("expected the url to be crawled again once the failure expired, got %d crawls", n)
+	}
+}
+
+func TestMediaGetUrl(t *testing.T) {
+	srv := miniredis.RunT(t)
+	hook := &countingHook{}
+	repo, _ := newTestMediaRepository(t, srv, hook)
+	ctx := context.Background()
+
+	normalized, err := util.NormalizeURL(testMediaUrl)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.cache.Set(&cache.Item{
+		Ctx:   ctx,
+		Key:   fmt.Sprintf(mediaCacheFmt, mediaHash(t, testMediaUrl)),
+		Value: &mediaCacheEntry{Title: "Test video", Url: normalized},
+		TTL:   repo.ttl,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each caller gets the url it looked up, not the one the entry was crawled with
+	for _, rawUrl := range []string{testMediaUrl, testMediaUrl + "&utm_source=share"} {
+		info, err := repo.Get(ctx, rawUrl)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Title != "Test video" || info.Url != rawUrl {
+			t.Fatalf("expected %q with its own url, got %+v", rawUrl, info.MediaItemInfo)
+		}
+	}
+
+	if n := hook.count(); n != 0 {
+		t.Fatalf("expected the cached entry to be used, got %d crawls", n)
+	}
+}
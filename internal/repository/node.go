@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/sakuraapp/gateway/internal/constant"
+	"time"
+)
+
+// releaseLockScript only deletes the lock if it's still held by the caller, since it might have expired & been taken by another node in the meantime
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+
+return 0
+`)
+
+// NodeInfo is what a node publishes about itself with every heartbeat
+type NodeInfo struct {
+	Id          string    `json:"id"`
+	Hostname    string    `json:"hostname"`
+	StartedAt   time.Time `json:"started_at"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+	Clients     int       `json:"clients"`
+	Rooms       int       `json:"rooms"`
+	Draining    bool      `json:"draining"`
+}
+
+// NodeRepository keeps track of the gateway nodes & the sessions connected to each of them
+// nodes send heartbeats that expire, a node whose heartbeat expired is considered dead
+type NodeRepository struct {
+	rdb redis.UniversalClient
+}
+
+func (r *NodeRepository) Heartbeat(ctx context.Context, info *NodeInfo, ttl time.Duration) error {
+	bytes, err := json.Marshal(info)
+
+	if err != nil {
+		return err
+	}
+
+	pipe := r.rdb.Pipeline()
+
+	pipe.Set(ctx, fmt.Sprintf(constant.NodeFmt, info.Id), bytes, ttl)
+	pipe.SAdd(ctx, constant.NodesKey, info.Id)
+
+	_, err = pipe.Exec(ctx)
+
+	return err
+}
+
+// Nodes returns the info of every live node
+func (r *NodeRepository) Nodes(ctx context.Context) ([]*NodeInfo, error) {
+	nodeIds, err := r.rdb.SMembers(ctx, constant.NodesKey).Result()
+
+	if err != nil || len(nodeIds) == 0 {
+		return nil, err
+	}
+
+	keys := make([]string, len(nodeIds))
+
+	for i, nodeId := range nodeIds {
+		keys[i] = fmt.Sprintf(constant.NodeFmt, nodeId)
+	}
+
+	values, err := r.rdb.MGet(ctx, keys...).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]*NodeInfo, 0, len(values))
+
+	for _, value := range values {
+		str, ok := value.(string)
+
+		if !ok {
+			continue // dead
+		}
+
+		info := new(NodeInfo)
+
+		if err := json.Unmarshal([]byte(str), info); err != nil {
+			continue
+		}
+
+		nodes = append(nodes, info)
+	}
+
+	return nodes, nil
+}
+
+// Deregister removes the node's heartbeat, so whatever it leaves behind is cleaned up by the next reaper run
+func (r *NodeRepository) Deregister(ctx context.Context, nodeId string) error {
+	return r.rdb.Del(ctx, fmt.Sprintf(constant.NodeFmt, nodeId)).Err()
+}
+
+// LiveNodes returns the registered nodes that are still sending heartbeats
+func (r *NodeRepository) LiveNodes(ctx context.Context) ([]string, error) {
+	return r.nodes(ctx, true)
+}
+
+// DeadNodes returns the registered nodes whose heartbeat expired
+func (r *NodeRepository) DeadNodes(ctx context.Context) ([]string, error) {
+	return r.nodes(ctx, false)
+}
+
+func (r *NodeRepository) nodes(ctx context.Context, alive bool) ([]string, error) {
+	nodeIds, err := r.rdb.SMembers(ctx, constant.NodesKey).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := r.rdb.Pipeline()
+	cmds := make([]*redis.IntCmd, len(nodeIds))
+
+	for i, nodeId := range nodeIds {
+		cmds[i] = pipe.Exists(ctx, fmt.Sprintf(constant.NodeFmt, nodeId))
+	}
+
+	_, err = pipe.Exec(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+
+	for i, cmd := range cmds {
+		if (cmd.Val() == 1) == alive {
+			res = append(res, nodeIds[i])
+		}
+	}
+
+	return res, nil
+}
+
+func (r *NodeRepository) AddSession(ctx context.Context, nodeId string, sessionId string) error {
+	return r.rdb.SAdd(ctx, fmt.Sprintf(constant.NodeSessionsFmt, nodeId), sessionId).Err()
+}
+
+func (r *NodeRepository) RemoveSession(ctx context.Context, nodeId string, sessionId string) error {
+	return r.rdb.SRem(ctx, fmt.Sprintf(constant.NodeSessionsFmt, nodeId), sessionId).Err()
+}
+
+func (r *NodeRepository) Sessions(ctx context.Context, nodeId string) ([]string, error) {
+	return r.rdb.SMembers(ctx, fmt.Sprintf(constant.NodeSessionsFmt, nodeId)).Result()
+}
+
+// Forget removes every trace of a node, once it's been cleaned up after
+func (r *NodeRepository) Forget(ctx context.Context, nodeId string) error {
+	pipe := r.rdb.Pipeline()
+
+	pipe.Del(ctx, fmt.Sprintf(constant.NodeSessionsFmt, nodeId))
+	pipe.SRem(ctx, constant.NodesKey, nodeId)
+
+	_, err := pipe.Exec(ctx)
+
+	return err
+}
+
+// AcquireReaper takes the reaper lock for ttl, it returns false if another node holds it
+func (r *NodeRepository) AcquireReaper(ctx context.Context, nodeId string, ttl time.Duration) (bool, error) {
+	return r.rdb.SetNX(ctx, constant.NodeReaperKey, nodeId, ttl).Result()
+}
+
+func (r *NodeRepository) ReleaseReaper(ctx context.Context, nodeId string) error {
+	return releaseLockScript.Run(ctx, r.rdb, []string{constant.NodeReaperKey}, nodeId).Err()
+}
@@ -1,17 +1,24 @@
 package repository
 
 import (
-	"github.com/go-pg/pg/v10"
 	"github.com/go-redis/cache/v8"
+	"github.com/go-redis/redis/v8"
+	"github.com/sakuraapp/gateway/internal/config"
+	"github.com/sakuraapp/gateway/internal/database"
+	"github.com/sakuraapp/gateway/pkg/util"
 )
 
 type Repositories struct {
-	User *UserRepository
-	Room *RoomRepository
-	Role *RoleRepository
+	User  *UserRepository
+	Room  *RoomRepository
+	Role  *RoleRepository
+	Media *MediaRepository
+	RoomEvent *RoomEventRepository
+	Node *NodeRepository
+	Config *ConfigRepository
 }
 
-func Init(db *pg.DB, cache *cache.Cache) *Repositories {
+func Init(conf *config.Config, db *database.DB, rdb redis.UniversalClient, cache *cache.Cache, crawler *util.Crawler) *Repositories {
 	return &Repositories{
 		User: &UserRepository{
 			db: db,
@@ -24,5 +31,23 @@ func Init(db *pg.DB, cache *cache.Cache) *Repositories {
 		Role: &RoleRepository{
 			db: db,
 		},
+		Media: &MediaRepository{
+			rdb: rdb,
+			cache: cache,
+			crawler: crawler,
+			ttl: conf.MediaCacheTTL,
+			negativeTTL: conf.MediaNegativeCacheTTL,
+		},
+		RoomEvent: &RoomEventRepository{
+			rdb: rdb,
+			maxLen: conf.RoomEventsMaxLen,
+			retention: conf.RoomEventsRetention,
+		},
+		Node: &NodeRepository{
+			rdb: rdb,
+		},
+		Config: &ConfigRepository{
+			rdb: rdb,
+		},
 	}
 }
\ No newline at end of file
@@ -2,21 +2,25 @@ package repository
 
 import (
 	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/sakuraapp/gateway/internal/database"
 	"github.com/sakuraapp/shared/pkg/model"
 )
 
 type RoleRepository struct {
-	db *pg.DB
+	db *database.DB
 }
 
 func (r *RoleRepository) Get(userId model.UserId, roomId model.RoomId) ([]model.UserRole, error) {
 	var roles []model.UserRole
-	err := r.db.Model(&roles).
-		Column("id", "role_id").
-		Where("user_id = ?", userId).
-		Where("room_id = ?", roomId).
-		Order("id ASC").
-		Select()
+	err := r.db.Read(func(db orm.DB) error {
+		return db.Model(&roles).
+			Column("id", "role_id").
+			Where("user_id = ?", userId).
+			Where("room_id = ?", roomId).
+			Order("id ASC").
+			Select()
+	})
 
 	if err == pg.ErrNoRows {
 		err = nil
@@ -27,7 +31,7 @@ func (r *RoleRepository) Get(userId model.UserId, roomId model.RoomId) ([]model.
 }
 
 func (r *RoleRepository) Add(userRole *model.UserRole) error {
-	_, err := r.db.Model(userRole).
+	_, err := r.db.Primary().Model(userRole).
 		Column("id").
 		Where("user_id = ?", userRole.UserId).
 		Where("room_id = ?", userRole.RoomId).
@@ -40,7 +44,7 @@ func (r *RoleRepository) Add(userRole *model.UserRole) error {
 }
 
 func (r *RoleRepository) Remove(userRole *model.UserRole) error {
-	_, err := r.db.Model(userRole).
+	_, err := r.db.Primary().Model(userRole).
 		Where("user_id = ?", userRole.UserId).
 		Where("room_id = ?", userRole.RoomId).
 		Where("role_id = ?", userRole.RoleId).
@@ -3,14 +3,15 @@ package repository
 import (
 	"context"
 	"fmt"
-	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
 	"github.com/go-redis/cache/v8"
+	"github.com/sakuraapp/gateway/internal/database"
 	"github.com/sakuraapp/shared/pkg/constant"
 	"github.com/sakuraapp/shared/pkg/model"
 )
 
 type RoomRepository struct {
-	db *pg.DB
+	db *database.DB
 	cache *cache.Cache
 }
 
@@ -33,10 +34,12 @@ func (r *RoomRepository) Get(ctx context.Context, id model.RoomId) (*model.Room,
 }
 
 func (r *RoomRepository) fetch(room *model.Room, id model.RoomId) (*model.Room, error) {
-	err := r.db.Model(room).
-		Relation("Owner").
-		Where("room.id = ?", id).
-		First()
+	err := r.db.Read(func(db orm.DB) error {
+		return db.Model(room).
+			Relation("Owner").
+			Where("room.id = ?", id).
+			First()
+	})
 
 	return room, err
 }
\ No newline at end of file
@@ -4,13 +4,15 @@ import (
 	"context"
 	"fmt"
 	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
 	"github.com/go-redis/cache/v8"
+	"github.com/sakuraapp/gateway/internal/database"
 	"github.com/sakuraapp/shared/pkg/constant"
 	"github.com/sakuraapp/shared/pkg/model"
 )
 
 type UserRepository struct {
-	db *pg.DB
+	db *database.DB
 	cache *cache.Cache
 }
 
@@ -33,7 +35,7 @@ func (u *UserRepository) GetWithDiscriminator(ctx context.Context, id model.User
 }
 
 func (u *UserRepository) fetchWithDiscriminator(user *model.User, id model.UserId) (*model.User, error) {
-	err := u.db.Model(user).
+	err := u.db.Primary().Model(user).
 		Column("user.*").
 		ColumnExpr("discriminator.value AS discriminator").
 		Join("LEFT JOIN discriminators AS discriminator ON discriminator.owner_id = ?", pg.Ident("user.id")).
@@ -51,7 +53,7 @@ func (u *UserRepository) FetchWithDiscriminator(id model.UserId) (*model.User, e
 
 func (u *UserRepository) GetUsersWithDiscriminators(ids []model.UserId) ([]model.User, error) {
 	var users []model.User
-	err := u.db.Model(&users).
+	err := u.db.Primary().Model(&users).
 		Column("user.*").
 		ColumnExpr("discriminator.value AS discriminator").
 		Join("LEFT JOIN discriminators AS discriminator ON discriminator.owner_id = ?", pg.Ident("user.id")).
@@ -63,15 +65,17 @@ func (u *UserRepository) GetUsersWithDiscriminators(ids []model.UserId) ([]model
 
 func (u *UserRepository) GetRoomMembers(ids []model.UserId, roomId model.RoomId) ([]model.RoomMember, error) {
 	var members []model.RoomMember
-	err := u.db.Model(&members).
-		Column("user.*").
-		ColumnExpr("discriminator.value AS discriminator").
-		Join("LEFT JOIN discriminators AS discriminator ON discriminator.owner_id = ?", pg.Ident("user.id")).
-		Where("? in (?)", pg.Ident("user.id"), pg.In(ids)).
-		Relation("Roles", func(q *pg.Query) (*pg.Query, error) {
-			return q.Where("? = ?", pg.Ident("user_role.room_id"), roomId), nil
-		}).
-		Select()
+	err := u.db.Read(func(db orm.DB) error {
+		return db.Model(&members).
+			Column("user.*").
+			ColumnExpr("discriminator.value AS discriminator").
+			Join("LEFT JOIN discriminators AS discriminator ON discriminator.owner_id = ?", pg.Ident("user.id")).
+			Where("? in (?)", pg.Ident("user.id"), pg.In(ids)).
+			Relation("Roles", func(q *pg.Query) (*pg.Query, error) {
+				return q.Where("? = ?", pg.Ident("user_role.room_id"), roomId), nil
+			}).
+			Select()
+	})
 
 	return members, err
 }
\ No newline at end of file
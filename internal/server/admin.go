@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-pg/pg/v10"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sakuraapp/gateway/internal/database"
+	"github.com/sakuraapp/gateway/internal/metrics"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+)
+
+func (s *Server) initMetrics() {
+	metrics.NewGaugeFunc("connections", "Number of open websocket connections", func() float64 {
+		return float64(s.clientMgr.Len())
+	})
+	metrics.NewGaugeFunc("sessions", "Number of authenticated sessions", func() float64 {
+		return float64(s.sessionMgr.Len())
+	})
+	metrics.NewGaugeFunc("rooms", "Number of rooms with clients on this node", func() float64 {
+		return float64(s.roomMgr.Len())
+	})
+	metrics.NewGaugeFunc("pubsub_pending_messages", "Number of pubsub messages waiting to be handled", func() float64 {
+		return float64(s.executor.Len())
+	})
+
+	s.rdb.AddHook(metrics.RedisHook{})
+	s.crawler.AddHook(metrics.CrawlerHook{})
+	s.db.ForEach(func(db *pg.DB, role database.Role) {
+		db.AddQueryHook(metrics.PostgresHook{Role: string(role)})
+	})
+}
+
+// runTask runs f on the taskpool, keeping track of how many tasks are waiting to run
+func (s *Server) runTask(f func()) {
+	metrics.TaskpoolPending.Inc()
+
+	s.taskPool.Go(func() {
+		metrics.TaskpoolPending.Dec()
+		f()
+	})
+}
+
+// initAdmin starts the admin http server (metrics & the admin api), it's meant to only be reachable internally (unlike the websocket server)
+func (s *Server) initAdmin() error {
+	if s.AdminPort == 0 {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	s.registerAdminApi(mux)
+
+	addr := fmt.Sprintf("0.0.0.0:%v", s.AdminPort)
+	listener, err := net.Listen("tcp", addr)
+
+	if err != nil {
+		return fmt.Errorf("failed to start admin listener: %w", err)
+	}
+
+	s.admin = &http.Server{Handler: mux}
+
+	log.Printf("Admin server listening on port %v", s.AdminPort)
+
+	go func() {
+		err := s.admin.Serve(listener)
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithError(err).Error("Admin server stopped")
+		}
+	}()
+
+	return nil
+}
+
+func (s *Server) stopAdmin(ctx context.Context) {
+	if s.admin == nil {
+		return
+	}
+
+	err := s.admin.Shutdown(ctx)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to shut down the admin server")
+	}
+}
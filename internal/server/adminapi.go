@@ -0,0 +1,357 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/go-pg/pg/v10"
+	"github.com/sakuraapp/gateway/internal/gateway"
+	"github.com/sakuraapp/gateway/internal/repository"
+	dispatcher "github.com/sakuraapp/shared/pkg/dispatcher/gateway"
+	"github.com/sakuraapp/shared/pkg/model"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type adminSession struct {
+	Id          string       `json:"id"`
+	UserId      model.UserId `json:"user_id"`
+	RoomId      model.RoomId `json:"room_id"`
+	RemoteAddr  string       `json:"remote_addr"`
+	ConnectedAt time.Time    `json:"connected_at"`
+	LastActive  time.Time    `json:"last_active"`
+}
+
+type adminRoom struct {
+	Id      model.RoomId `json:"id"`
+	Clients int          `json:"clients"`
+}
+
+type adminBroadcastRequest struct {
+	RoomId  model.RoomId `json:"room_id"` // 0 sends it to everyone
+	Message string       `json:"message"`
+}
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// registerAdminApi adds the operator endpoints to the admin server, they all require the admin token
+func (s *Server) registerAdminApi(mux *http.ServeMux) {
+	if s.AdminToken == "" {
+		log.Warn("No admin token is set, the admin api is disabled")
+		return
+	}
+
+	mux.Handle("/admin/sessions", s.adminAuth(s.handleAdminSessions))
+	mux.Handle("/admin/sessions/", s.adminAuth(s.handleAdminSession))
+	mux.Handle("/admin/rooms", s.adminAuth(s.handleAdminRooms))
+	mux.Handle("/admin/rooms/", s.adminAuth(s.handleAdminRoom))
+	mux.Handle("/admin/users/", s.adminAuth(s.handleAdminUser))
+	mux.Handle("/admin/broadcast", s.adminAuth(s.handleAdminBroadcast))
+	mux.Handle("/admin/nodes", s.adminAuth(s.handleAdminNodes))
+	mux.Handle("/admin/drain", s.adminAuth(s.handleAdminDrain))
+	mux.Handle("/admin/config", s.adminAuth(s.handleAdminConfig))
+	mux.Handle("/admin/config/overrides", s.adminAuth(s.handleAdminConfigOverrides))
+	mux.Handle("/admin/config/reload", s.adminAuth(s.handleAdminConfigReload))
+}
+
+func (s *Server) adminAuth(next http.HandlerFunc) http.Handler {
+	expected := []byte("Bearer " + s.AdminToken)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := []byte(r.Header.Get("Authorization"))
+
+		if subtle.ConstantTimeCompare(auth, expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, &adminError{Error: "unauthorized"})
+
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"remote": r.RemoteAddr,
+		}).Info("Admin api call")
+
+		next(w, r)
+	})
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeJSON(w, http.StatusMethodNotAllowed, &adminError{Error: "method not allowed"})
+
+		return false
+	}
+
+	return true
+}
+
+// GET /admin/sessions lists the clients connected to this node
+func (s *Server) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	clients := s.clientMgr.List()
+	sessions := make([]*adminSession, 0, len(clients))
+
+	for _, c := range clients {
+		sessions = append(sessions, &adminSession{
+			Id:          c.Session.Id,
+			UserId:      c.Session.UserId,
+			RoomId:      c.Session.RoomId,
+			RemoteAddr:  c.Conn().RemoteAddr().String(),
+			ConnectedAt: c.ConnectedAt,
+			LastActive:  c.LastActive,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// DELETE /admin/sessions/{id} disconnects a session from this node
+func (s *Server) handleAdminSession(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodDelete) {
+		return
+	}
+
+	sessionId := strings.TrimPrefix(r.URL.Path, "/admin/sessions/")
+	c := s.clientMgr.Get(sessionId)
+
+	if c == nil {
+		writeJSON(w, http.StatusNotFound, &adminError{Error: "session is not connected to this node"})
+		return
+	}
+
+	c.Disconnect()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /admin/users/{id} disconnects every session of a user, on every node
+func (s *Server) handleAdminUser(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodDelete) {
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/admin/users/"), 10, 32)
+
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &adminError{Error: "invalid user id"})
+		return
+	}
+
+	err = s.handlers.DisconnectUser(r.Context(), model.UserId(id))
+
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /admin/rooms lists the rooms that have clients on this node
+func (s *Server) handleAdminRooms(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	rooms := s.roomMgr.Rooms()
+	res := make([]*adminRoom, 0, len(rooms))
+
+	for _, room := range rooms {
+		res = append(res, &adminRoom{
+			Id:      room.Id(),
+			Clients: room.NumClients(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// DELETE /admin/rooms/{id} closes a room, on every node
+func (s *Server) handleAdminRoom(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodDelete) {
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/admin/rooms/"), 10, 32)
+
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &adminError{Error: "invalid room id"})
+		return
+	}
+
+	err = s.handlers.CloseRoom(r.Context(), model.RoomId(id))
+
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /admin/broadcast sends a system notification to a room, or to everyone
+func (s *Server) handleAdminBroadcast(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req adminBroadcastRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil || req.Message == "" {
+		writeJSON(w, http.StatusBadRequest, &adminError{Error: "a message is required"})
+		return
+	}
+
+	ctx := r.Context()
+
+	if req.RoomId != 0 {
+		_, err = s.handlers.SendNotification(ctx, dispatcher.NewRoomTarget(req.RoomId), gateway.NotificationSystem, req.Message, 0)
+	} else {
+		_, err = s.handlers.BroadcastNotification(ctx, gateway.NotificationSystem, req.Message)
+	}
+
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /admin/nodes lists the live nodes, from the node registry
+func (s *Server) handleAdminNodes(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	nodes, err := s.repos.Node.Nodes(r.Context())
+
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	if nodes == nil {
+		nodes = []*repository.NodeInfo{}
+	}
+
+	writeJSON(w, http.StatusOK, nodes)
+}
+
+// POST /admin/drain drains this node & shuts it down, like a SIGTERM would
+func (s *Server) handleAdminDrain(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	if !s.requestDrain() {
+		writeJSON(w, http.StatusConflict, &adminError{Error: "node is already shutting down"})
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// GET /admin/config returns this node's config, without its secrets
+func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.GetConfig().Redacted())
+}
+
+// /admin/config/overrides reads (GET), replaces (PUT) or removes (DELETE) the runtime overrides of every node
+// they're given in the same layout as a config file, only reloadable settings can be overridden
+func (s *Server) handleAdminConfigOverrides(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		overrides, err := s.repos.Config.Overrides(ctx)
+
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+
+		if overrides == nil {
+			overrides = map[string]interface{}{}
+		}
+
+		writeJSON(w, http.StatusOK, overrides)
+	case http.MethodPut, http.MethodDelete:
+		var overrides map[string]interface{}
+
+		if r.Method == http.MethodPut {
+			err := json.NewDecoder(r.Body).Decode(&overrides)
+
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, &adminError{Error: "invalid json"})
+				return
+			}
+
+			err = s.reloader.CheckOverrides(overrides)
+
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, &adminError{Error: err.Error()})
+				return
+			}
+		}
+
+		err := s.repos.Config.SetOverrides(ctx, overrides)
+
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, &adminError{Error: "method not allowed"})
+	}
+}
+
+// POST /admin/config/reload reads this node's config again, like a SIGHUP would
+func (s *Server) handleAdminConfigReload(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	err := s.reloader.Reload()
+
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &adminError{Error: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gateway.ErrNotFound):
+		writeJSON(w, http.StatusNotFound, &adminError{Error: err.Error()})
+	case errors.Is(err, pg.ErrNoRows):
+		writeJSON(w, http.StatusNotFound, &adminError{Error: "not found"})
+	case errors.Is(err, gateway.ErrInvalidRequest):
+		writeJSON(w, http.StatusBadRequest, &adminError{Error: err.Error()})
+	default:
+		log.WithError(err).Error("Admin api call failed")
+		writeJSON(w, http.StatusInternalServerError, &adminError{Error: "internal error"})
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-pg/pg/v10"
+	"github.com/sakuraapp/gateway/internal/config"
+	"github.com/sakuraapp/gateway/internal/database"
+)
+
+// newDatabase connects to the primary & to each replica, with the same credentials & settings
+func newDatabase(conf *config.Config) (*database.DB, error) {
+	primary, err := connectPostgres(conf, conf.DatabaseAddr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := make([]*pg.DB, 0, len(conf.DatabaseReplicaAddrs))
+
+	for _, addr := range conf.DatabaseReplicaAddrs {
+		replica, err := connectPostgres(conf, addr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		replicas = append(replicas, replica)
+	}
+
+	return database.New(primary, replicas...), nil
+}
+
+func connectPostgres(conf *config.Config, addr string) (*pg.DB, error) {
+	tlsConfig, err := config.NewTLSConfig(conf.DatabaseTLS, addr, conf.DatabaseTLSCAPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &pg.Options{
+		Addr:         addr,
+		User:         conf.DatabaseUser,
+		Password:     conf.DatabasePassword,
+		Database:     conf.DatabaseName,
+		TLSConfig:    tlsConfig,
+		DialTimeout:  conf.DatabaseDialTimeout,
+		ReadTimeout:  conf.DatabaseReadTimeout,
+		WriteTimeout: conf.DatabaseWriteTimeout,
+		PoolSize:     conf.DatabasePoolSize,
+	}
+
+	if conf.DatabaseStatementTimeout > 0 {
+		timeout := fmt.Sprintf("SET statement_timeout = %d", conf.DatabaseStatementTimeout.Milliseconds())
+
+		opts.OnConnect = func(ctx context.Context, cn *pg.Conn) error {
+			_, err := cn.ExecContext(ctx, timeout)
+
+			return err
+		}
+	}
+
+	return pg.Connect(opts), nil
+}
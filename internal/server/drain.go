@@ -0,0 +1,91 @@
+package server
+
+import (
+	"github.com/sakuraapp/gateway/internal/gateway"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"os"
+	"syscall"
+	"time"
+)
+
+const drainPollInterval = 500 * time.Millisecond
+
+type ReconnectMessage struct {
+	SessionId string `json:"sessionId,omitempty"`
+	Delay     int64  `json:"delay"` // in milliseconds
+}
+
+// drain hands the clients on this node off to other nodes before shutting down
+// the node is marked as not ready first (so no new clients are routed to it), then every client is told to reconnect after a random delay
+// it returns once every client left, the drain timeout is reached, or another signal is received
+func (s *Server) drain(interrupt <-chan os.Signal) {
+	s.startDraining()
+
+	if s.DrainTimeout <= 0 {
+		return
+	}
+
+	clients := s.clientMgr.List()
+
+	log.WithField("clients", len(clients)).Info("Draining clients")
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for _, c := range clients {
+		msg := &ReconnectMessage{}
+
+		if c.Session.UserId != 0 {
+			msg.SessionId = c.Session.Id // unauthenticated clients have no session to resume
+		}
+
+		if s.DrainReconnectSpread > 0 {
+			msg.Delay = random.Int63n(s.DrainReconnectSpread.Milliseconds() + 1)
+		}
+
+		err := c.Send(gateway.Reconnect, msg)
+
+		if err != nil {
+			log.WithError(err).WithField("session_id", c.Session.Id).Warn("Failed to send reconnect hint")
+		}
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	timeout := time.After(s.DrainTimeout)
+
+	for {
+		remaining := s.clientMgr.Len()
+
+		if remaining == 0 {
+			log.Info("All clients left, done draining")
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-timeout:
+			log.WithField("clients", remaining).Warn("Drain timeout reached, disconnecting the remaining clients")
+			return
+		case <-interrupt:
+			log.WithField("clients", remaining).Warn("Received another signal, skipping the rest of the drain")
+			return
+		}
+	}
+}
+
+// requestDrain drains the node & shuts it down, as if it received a SIGTERM
+// it returns false if the node is already shutting down
+func (s *Server) requestDrain() bool {
+	if s.isDraining() {
+		return false
+	}
+
+	select {
+	case s.interrupt <- syscall.SIGTERM:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"context"
+	"github.com/mitchellh/mapstructure"
+	"github.com/sakuraapp/gateway/internal/gateway"
+	"github.com/sakuraapp/gateway/internal/handler"
+	"github.com/sakuraapp/gateway/pkg/gatewaypb"
+	"github.com/sakuraapp/gateway/pkg/util"
+	dispatcher "github.com/sakuraapp/shared/pkg/dispatcher/gateway"
+	"github.com/sakuraapp/shared/pkg/model"
+	"github.com/sakuraapp/shared/pkg/resource/opcode"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+// eventStreamBufferSize is how many events a stream can fall behind by before it gets closed
+const eventStreamBufferSize = 256
+
+// eventStream is a single StreamRoomEvents call, events are buffered so a slow consumer never blocks the pubsub workers
+type eventStream struct {
+	events       chan *gatewaypb.RoomEvent
+	overflow     chan struct{}
+	overflowOnce sync.Once
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{
+		events:   make(chan *gatewaypb.RoomEvent, eventStreamBufferSize),
+		overflow: make(chan struct{}),
+	}
+}
+
+func (s *eventStream) push(event *gatewaypb.RoomEvent) {
+	select {
+	case s.events <- event:
+	default:
+		s.overflowOnce.Do(func() {
+			close(s.overflow)
+		})
+	}
+}
+
+// roomEventSubscriber subscribes a stream to a single room's topic, since messages don't carry the topic they were sent on
+type roomEventSubscriber struct {
+	roomId model.RoomId
+	stream *eventStream
+}
+
+func (sub *roomEventSubscriber) Dispatch(msg *dispatcher.Message) {
+	event := newRoomEvent(sub.roomId, msg)
+
+	if event != nil {
+		sub.stream.push(event)
+	}
+}
+
+// eventFeed keeps track of the room subscribers so server messages (which don't go through the subscription manager) can reach them too
+type eventFeed struct {
+	mu          sync.Mutex
+	subscribers map[model.RoomId]map[*roomEventSubscriber]bool
+}
+
+func newEventFeed() *eventFeed {
+	return &eventFeed{
+		subscribers: map[model.RoomId]map[*roomEventSubscriber]bool{},
+	}
+}
+
+func (f *eventFeed) add(sub *roomEventSubscriber) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.subscribers[sub.roomId] == nil {
+		f.subscribers[sub.roomId] = map[*roomEventSubscriber]bool{sub: true}
+	} else {
+		f.subscribers[sub.roomId][sub] = true
+	}
+}
+
+func (f *eventFeed) remove(sub *roomEventSubscriber) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.subscribers[sub.roomId], sub)
+
+	if len(f.subscribers[sub.roomId]) == 0 {
+		delete(f.subscribers, sub.roomId)
+	}
+}
+
+func (f *eventFeed) onKickUser(msg *dispatcher.Message) {
+	var opts handler.KickUserMessage
+
+	err := mapstructure.Decode(msg.Payload.Data, &opts)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to parse kick message")
+		return
+	}
+
+	event := &gatewaypb.RoomEvent{
+		RoomId: int32(opts.RoomId),
+		Type:   gatewaypb.RoomEventType_KICK,
+		Time:   msg.Payload.Time.ValueOrZero(),
+		UserId: int32(opts.UserId),
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for sub := range f.subscribers[opts.RoomId] {
+		sub.stream.push(event)
+	}
+}
+
+func (s *Server) GetRoomEvents(ctx context.Context, req *gatewaypb.GetRoomEventsRequest) (*gatewaypb.GetRoomEventsResponse, error) {
+	roomId := model.RoomId(req.RoomId)
+	entries, err := s.handlers.GetRoomEvents(ctx, roomId, req.AfterId, req.Limit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := &gatewaypb.GetRoomEventsResponse{
+		Events: make([]*gatewaypb.RoomEvent, 0, len(entries)),
+	}
+
+	for _, entry := range entries {
+		event := newRoomEvent(roomId, entry.Message)
+
+		if event == nil {
+			continue
+		}
+
+		event.Id = entry.Id
+		event.Seq = entry.Seq
+
+		res.Events = append(res.Events, event)
+	}
+
+	return res, nil
+}
+
+func (s *Server) StreamRoomEvents(req *gatewaypb.StreamRoomEventsRequest, stream gatewaypb.GatewayService_StreamRoomEventsServer) error {
+	if len(req.RoomIds) == 0 {
+		return status.Error(codes.InvalidArgument, "no rooms specified")
+	}
+
+	ctx := stream.Context()
+	es := newEventStream()
+
+	subs := make([]*roomEventSubscriber, 0, len(req.RoomIds))
+	seen := map[model.RoomId]bool{}
+
+	defer func() {
+		// the stream's context is already done at this point, and so is the server's when it's shutting down
+		for _, sub := range subs {
+			s.events.remove(sub)
+
+			topic := dispatcher.NewRoomTarget(sub.roomId).Build()
+			err := s.subscriptionMgr.Remove(context.Background(), topic, sub)
+
+			if err != nil {
+				log.WithError(err).WithField("room_id", sub.roomId).Error("Failed to unsubscribe event stream")
+			}
+		}
+	}()
+
+	for _, id := range req.RoomIds {
+		roomId := model.RoomId(id)
+
+		if seen[roomId] {
+			continue
+		}
+
+		seen[roomId] = true
+
+		sub := &roomEventSubscriber{roomId: roomId, stream: es}
+		topic := dispatcher.NewRoomTarget(roomId).Build()
+
+		err := s.subscriptionMgr.Add(ctx, topic, sub)
+
+		if err != nil {
+			return status.Errorf(codes.Unavailable, "failed to subscribe to room %v: %v", roomId, err)
+		}
+
+		subs = append(subs, sub)
+		s.events.add(sub)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-s.streamsCtx.Done():
+			return status.Error(codes.Unavailable, "node is shutting down")
+		case <-es.overflow:
+			return status.Error(codes.ResourceExhausted, "event stream fell too far behind")
+		case event := <-es.events:
+			err := stream.Send(event)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type eventMediaItem struct {
+	Id     string `mapstructure:"id"`
+	Author int32  `mapstructure:"author"`
+	Type   int32  `mapstructure:"type"`
+	Title  string `mapstructure:"title"`
+	Icon   string `mapstructure:"icon"`
+	Url    string `mapstructure:"url"`
+}
+
+type eventMember struct {
+	User struct {
+		Id            int32       `mapstructure:"Id"`
+		Username      string      `mapstructure:"Username"`
+		Discriminator string      `mapstructure:"Discriminator"`
+		Avatar        interface{} `mapstructure:"Avatar"`
+	} `mapstructure:"User"`
+	Roles []int32 `mapstructure:"Roles"`
+}
+
+// newRoomEvent translates a room message into an event, messages that aren't of interest are ignored (nil)
+func newRoomEvent(roomId model.RoomId, msg *dispatcher.Message) *gatewaypb.RoomEvent {
+	event := &gatewaypb.RoomEvent{
+		RoomId: int32(roomId),
+		Time:   msg.Payload.Time.ValueOrZero(),
+	}
+
+	if event.Time == 0 {
+		event.Time = time.Now().UnixMilli()
+	}
+
+	event.Seq, _ = util.FilterInt(msg.Filters, gateway.MessageFilterSequence)
+	event.Id, _ = msg.Filters[gateway.MessageFilterStreamId].(string)
+
+	data := msg.Payload.Data
+	var err error
+
+	switch msg.Payload.Opcode {
+	case opcode.VideoSet, opcode.QueueAdd:
+		if msg.Payload.Opcode == opcode.VideoSet {
+			event.Type = gatewaypb.RoomEventType_ITEM_SET
+		} else {
+			event.Type = gatewaypb.RoomEventType_QUEUE_ADD
+		}
+
+		if data != nil {
+			var item eventMediaItem
+
+			err = mapstructure.Decode(data, &item)
+
+			event.Item = &gatewaypb.MediaItem{
+				Id:     item.Id,
+				Author: item.Author,
+				Type:   item.Type,
+				Title:  item.Title,
+				Icon:   item.Icon,
+				Url:    item.Url,
+			}
+		}
+	case opcode.QueueRemove:
+		event.Type = gatewaypb.RoomEventType_QUEUE_REMOVE
+		event.ItemId, _ = data.(string)
+	case opcode.PlayerState:
+		event.Type = gatewaypb.RoomEventType_PLAYER_STATE
+
+		m, _ := data.(map[string]interface{})
+		state := &gatewaypb.PlayerState{}
+
+		state.Playing, _ = m["playing"].(bool)
+		state.CurrentTime, _ = m["currentTime"].(float64)
+
+		if t, ok := m["playbackStart"].(time.Time); ok {
+			state.PlaybackStart = t.UnixMilli()
+		}
+
+		event.PlayerState = state
+	case opcode.AddUser:
+		event.Type = gatewaypb.RoomEventType_MEMBER_JOIN
+
+		var member eventMember
+
+		err = mapstructure.Decode(data, &member)
+
+		event.UserId = member.User.Id
+		event.Member = &gatewaypb.RoomMember{
+			User: &gatewaypb.User{
+				Id:            member.User.Id,
+				Username:      member.User.Username,
+				Discriminator: member.User.Discriminator,
+				Avatar:        decodeNullString(member.User.Avatar),
+			},
+			Roles: member.Roles,
+		}
+	case opcode.RemoveUser:
+		event.Type = gatewaypb.RoomEventType_MEMBER_LEAVE
+
+		err = mapstructure.Decode(data, &event.UserId)
+	case opcode.AddRole, opcode.RemoveRole:
+		if msg.Payload.Opcode == opcode.AddRole {
+			event.Type = gatewaypb.RoomEventType_ROLE_ADD
+		} else {
+			event.Type = gatewaypb.RoomEventType_ROLE_REMOVE
+		}
+
+		var opts handler.RoleUpdateMessage
+
+		err = mapstructure.Decode(data, &opts)
+
+		event.UserId = int32(opts.UserId)
+		event.RoleId = int32(opts.RoleId)
+	default:
+		return nil
+	}
+
+	if err != nil {
+		log.WithError(err).
+			WithField("opcode", msg.Payload.Opcode).
+			Warn("Failed to translate room event")
+
+		return nil
+	}
+
+	return event
+}
+
+// decodeNullString reads a null.String that went through msgpack, where it ends up as a map
+func decodeNullString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case map[string]interface{}:
+		str, _ := val["String"].(string)
+
+		return str
+	}
+
+	return ""
+}
@@ -2,15 +2,30 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
-	gatewaypb "github.com/sakuraapp/protobuf/gateway"
+	"github.com/sakuraapp/gateway/internal/gateway"
+	"github.com/sakuraapp/gateway/pkg/gatewaypb"
+	"github.com/sakuraapp/pubsub"
+	dispatcher "github.com/sakuraapp/shared/pkg/dispatcher/gateway"
 	"github.com/sakuraapp/shared/pkg/model"
 	"github.com/sakuraapp/shared/pkg/resource"
+	"github.com/sakuraapp/shared/pkg/resource/permission"
+	"github.com/sakuraapp/shared/pkg/resource/role"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
 	"net"
+	"os"
+	"sync/atomic"
 	"time"
 )
 
@@ -40,37 +55,370 @@ func (s *Server) SetCurrentItem(ctx context.Context, req *gatewaypb.SetCurrentIt
 	return &gatewaypb.SetCurrentItemResponse{}, nil
 }
 
-func (s *Server) initGrpc() {
-	creds, err := credentials.NewServerTLSFromFile(s.GrpcCertPath, s.GrpcKeyPath)
+func (s *Server) GetRoomState(ctx context.Context, req *gatewaypb.GetRoomStateRequest) (*gatewaypb.GetRoomStateResponse, error) {
+	state, err := s.handlers.GetRoomState(ctx, model.RoomId(req.RoomId))
 
 	if err != nil {
-		log.WithError(err).Fatal("Failed to load gRPC SSL/TLS key pair")
+		return nil, err
+	}
+
+	res := &gatewaypb.GetRoomStateResponse{
+		CurrentItem: newPbMediaItem(state.CurrentItem),
+		Queue:       make([]*gatewaypb.MediaItem, 0, len(state.Queue)),
+		Members:     make([]*gatewaypb.RoomMember, 0, len(state.Members)),
+	}
+
+	if state.PlayerState != nil {
+		res.PlayerState = &gatewaypb.PlayerState{
+			Playing:       state.PlayerState.IsPlaying,
+			CurrentTime:   state.PlayerState.CurrentTime,
+			PlaybackStart: state.PlayerState.PlaybackStart.UnixMilli(),
+		}
+	}
+
+	for _, item := range state.Queue {
+		res.Queue = append(res.Queue, newPbMediaItem(item))
+	}
+
+	for _, member := range state.Members {
+		roles := make([]int32, 0, len(member.Roles))
+
+		for _, roleId := range member.Roles {
+			roles = append(roles, int32(roleId))
+		}
+
+		res.Members = append(res.Members, &gatewaypb.RoomMember{
+			User: &gatewaypb.User{
+				Id:            member.User.Id,
+				Username:      member.User.Username,
+				Discriminator: member.User.Discriminator,
+				Avatar:        member.User.Avatar.ValueOrZero(),
+			},
+			Roles: roles,
+		})
+	}
+
+	return res, nil
+}
+
+func (s *Server) EnqueueItem(ctx context.Context, req *gatewaypb.EnqueueItemRequest) (*gatewaypb.EnqueueItemResponse, error) {
+	roomId := model.RoomId(req.RoomId)
+
+	// unlike websocket clients, callers can name any room
+	_, err := s.handlers.GetRoom(ctx, roomId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := s.handlers.AddQueueItem(ctx, roomId, model.UserId(req.Author), req.Url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &gatewaypb.EnqueueItemResponse{Item: newPbMediaItem(item)}, nil
+}
+
+func (s *Server) RemoveItem(ctx context.Context, req *gatewaypb.RemoveItemRequest) (*gatewaypb.RemoveItemResponse, error) {
+	roomId := model.RoomId(req.RoomId)
+	_, err := s.handlers.GetRoom(ctx, roomId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.handlers.RemoveQueueItem(ctx, roomId, req.ItemId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &gatewaypb.RemoveItemResponse{}, nil
+}
+
+func (s *Server) SetPlayerState(ctx context.Context, req *gatewaypb.SetPlayerStateRequest) (*gatewaypb.SetPlayerStateResponse, error) {
+	roomId := model.RoomId(req.RoomId)
+	_, err := s.handlers.GetRoom(ctx, roomId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	state := &resource.PlayerState{
+		IsPlaying:     req.Playing,
+		CurrentTime:   req.CurrentTime,
+		PlaybackStart: time.Now(),
+	}
+
+	err = s.handlers.SetPlayerState(ctx, roomId, state, "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &gatewaypb.SetPlayerStateResponse{}, nil
+}
+
+func (s *Server) KickUser(ctx context.Context, req *gatewaypb.KickUserRequest) (*gatewaypb.KickUserResponse, error) {
+	err := s.handlers.Kick(ctx, model.RoomId(req.RoomId), model.UserId(req.UserId))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &gatewaypb.KickUserResponse{}, nil
+}
+
+func (s *Server) UpdateRole(ctx context.Context, req *gatewaypb.UpdateRoleRequest) (*gatewaypb.UpdateRoleResponse, error) {
+	err := s.handlers.SetRole(ctx, model.RoomId(req.RoomId), model.UserId(req.UserId), role.Id(req.RoleId), !req.Remove, "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &gatewaypb.UpdateRoleResponse{}, nil
+}
+
+func (s *Server) CloseRoom(ctx context.Context, req *gatewaypb.CloseRoomRequest) (*gatewaypb.CloseRoomResponse, error) {
+	err := s.handlers.CloseRoom(ctx, model.RoomId(req.RoomId))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &gatewaypb.CloseRoomResponse{}, nil
+}
+
+func (s *Server) SendNotification(ctx context.Context, req *gatewaypb.SendNotificationRequest) (*gatewaypb.SendNotificationResponse, error) {
+	var target pubsub.MessageTarget
+	var numTargets int
+
+	if req.UserId != 0 {
+		target = dispatcher.NewUserTarget(model.UserId(req.UserId))
+		numTargets++
+	}
+
+	if req.RoomId != 0 {
+		target = dispatcher.NewRoomTarget(model.RoomId(req.RoomId))
+		numTargets++
+	}
+
+	if req.SessionId != "" {
+		target = dispatcher.NewSessionTarget(req.SessionId)
+		numTargets++
+	}
+
+	if numTargets != 1 {
+		return nil, gateway.ErrInvalidTarget
+	}
+
+	if req.Notification == nil {
+		return nil, gateway.ErrInvalidNotification
+	}
+
+	var data interface{}
+
+	if len(req.Notification.Data) > 0 {
+		// data is json so it can be relayed to clients as is
+		err := json.Unmarshal(req.Notification.Data, &data)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: notification data is not valid json", gateway.ErrInvalidRequest)
+		}
+	}
+
+	notification, err := s.handlers.SendNotification(
+		ctx,
+		target,
+		resource.NotificationType(req.Notification.Type),
+		data,
+		permission.Permission(req.Permissions),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &gatewaypb.SendNotificationResponse{Id: notification.Id}, nil
+}
+
+func newPbMediaItem(item *resource.MediaItem) *gatewaypb.MediaItem {
+	if item == nil {
+		return nil
+	}
+
+	res := &gatewaypb.MediaItem{
+		Id:     item.Id,
+		Author: item.Author,
+		Type:   int32(item.Type),
+	}
+
+	if item.MediaItemInfo != nil {
+		res.Title = item.Title
+		res.Icon = item.Icon
+		res.Url = item.Url
+	}
+
+	return res
+}
+
+func (s *Server) grpcCredentials() (credentials.TransportCredentials, error) {
+	if s.GrpcInsecure {
+		if !s.IsDev() {
+			return nil, errors.New("plaintext gRPC is only allowed in development")
+		}
+
+		log.Warn("gRPC is running without TLS")
+
+		return insecure.NewCredentials(), nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.GrpcCertPath, s.GrpcKeyPath)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load gRPC SSL/TLS key pair: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if s.GrpcClientCAPath != "" {
+		caPem, err := os.ReadFile(s.GrpcClientCAPath)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gRPC client CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(caPem) {
+			return nil, errors.New("gRPC client CA doesn't contain any valid certificate")
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConfig.VerifyConnection = s.verifyGrpcClient
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// verifyGrpcClient checks the (already verified) client certificate against the allow-list, using its common name & DNS names
+func (s *Server) verifyGrpcClient(cs tls.ConnectionState) error {
+	if len(s.GrpcAllowedClients) == 0 {
+		return nil
+	}
+
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no client certificate")
+	}
+
+	cert := cs.PeerCertificates[0]
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+
+	for _, name := range names {
+		for _, allowed := range s.GrpcAllowedClients {
+			if name == allowed {
+				return nil
+			}
+		}
+	}
+
+	log.WithField("subject", cert.Subject.String()).Warn("Rejected a gRPC client that isn't allowed")
+
+	return fmt.Errorf("client %v is not allowed", cert.Subject.CommonName)
+}
+
+func (s *Server) grpcListener() (net.Listener, error) {
+	switch s.GrpcNetwork {
+	case "", "tcp":
+		addr := fmt.Sprintf("0.0.0.0:%v", s.GrpcPort)
+
+		return net.Listen("tcp", addr)
+	case "unix":
+		if s.GrpcSocketPath == "" {
+			return nil, errors.New("no gRPC socket path specified")
+		}
+
+		// remove the socket left behind by a previous run, if any
+		err := os.Remove(s.GrpcSocketPath)
+
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		return net.Listen("unix", s.GrpcSocketPath)
+	default:
+		return nil, fmt.Errorf("unsupported gRPC network: %v", s.GrpcNetwork)
+	}
+}
+
+func (s *Server) initGrpc() error {
+	creds, err := s.grpcCredentials()
+
+	if err != nil {
+		return err
 	}
 
 	// gRPC & the websocket server run on different ports because the websocket server is meant to be public while the gRPC server is an internal service
-	addr := fmt.Sprintf("0.0.0.0:%v", s.GrpcPort)
-	listener, err := net.Listen("tcp", addr)
+	listener, err := s.grpcListener()
 
 	if err != nil {
-		log.WithError(err).Fatal("Failed to start gRPC TCP server")
+		return fmt.Errorf("failed to start gRPC listener: %w", err)
 	}
 
-	log.Printf("gRPC Listening on port %v", s.GrpcPort)
+	log.Printf("gRPC Listening on %v", listener.Addr())
 
 	opts := []grpc.ServerOption{
 		grpc.Creds(creds),
 		grpc.KeepaliveParams(keepalive.ServerParameters{
 			MaxConnectionAge: maxConnectionAge,
 		}),
+		grpc.ChainUnaryInterceptor(s.unaryInterceptors()...),
+		grpc.ChainStreamInterceptor(s.streamInterceptors()...),
 	}
 
 	grpcServer := grpc.NewServer(opts...)
 	s.grpc = grpcServer
 
 	gatewaypb.RegisterGatewayServiceServer(grpcServer, s)
-	err = grpcServer.Serve(listener)
 
-	if err != nil {
-		log.WithError(err).Fatal("Failed to start gRPC server")
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthServer.SetServingStatus(gatewaypb.GatewayService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	s.health.OnChange(func(healthy bool) {
+		status := healthpb.HealthCheckResponse_NOT_SERVING
+
+		if healthy {
+			status = healthpb.HealthCheckResponse_SERVING
+		}
+
+		healthServer.SetServingStatus("", status)
+		healthServer.SetServingStatus(gatewaypb.GatewayService_ServiceDesc.ServiceName, status)
+	})
+
+	s.grpcHealth = healthServer
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	if s.IsDev() {
+		reflection.Register(grpcServer)
 	}
-}
\ No newline at end of file
+
+	// the listener is already open, so calls are accepted from here on
+	atomic.StoreInt32(&s.grpcServing, 1)
+
+	go func() {
+		err := grpcServer.Serve(listener)
+		atomic.StoreInt32(&s.grpcServing, 0)
+
+		if err != nil {
+			log.WithError(err).Error("gRPC server stopped")
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	healthCheckInterval = 5 * time.Second
+	healthCheckTimeout  = 2 * time.Second
+)
+
+// HealthCheck reports whether a dependency is usable, a nil error means it is
+type HealthCheck func(ctx context.Context) error
+
+type HealthListener func(healthy bool)
+
+// HealthResult is the outcome of a check's latest run
+type HealthResult struct {
+	Err       error
+	Latency   time.Duration
+	CheckedAt time.Time
+}
+
+// HealthChecker periodically runs a set of checks & keeps their latest results around, so they can be exposed through different means (gRPC, http)
+type HealthChecker struct {
+	mu        sync.RWMutex
+	checks    map[string]HealthCheck
+	results   map[string]HealthResult
+	healthy   bool
+	checked   bool
+	listeners []HealthListener
+}
+
+func NewHealthChecker() *HealthChecker {
+	return &HealthChecker{
+		checks:  map[string]HealthCheck{},
+		results: map[string]HealthResult{},
+	}
+}
+
+func (h *HealthChecker) Register(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checks[name] = check
+}
+
+// OnChange registers a listener that's called whenever the overall health changes, as well as after the first check
+func (h *HealthChecker) OnChange(fn HealthListener) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.listeners = append(h.listeners, fn)
+}
+
+func (h *HealthChecker) Healthy() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.healthy
+}
+
+// Results returns the latest result of every check, keyed by name
+func (h *HealthChecker) Results() map[string]HealthResult {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	results := make(map[string]HealthResult, len(h.results))
+
+	for name, res := range h.results {
+		results[name] = res
+	}
+
+	return results
+}
+
+func (h *HealthChecker) Check(ctx context.Context) {
+	h.mu.RLock()
+	checks := make(map[string]HealthCheck, len(h.checks))
+
+	for name, check := range h.checks {
+		checks[name] = check
+	}
+	h.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	var resMu sync.Mutex
+
+	results := make(map[string]HealthResult, len(checks))
+
+	for name, check := range checks {
+		wg.Add(1)
+
+		go func(name string, check HealthCheck) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(checkCtx)
+
+			res := HealthResult{
+				Err:       err,
+				Latency:   time.Since(start),
+				CheckedAt: start,
+			}
+
+			resMu.Lock()
+			results[name] = res
+			resMu.Unlock()
+		}(name, check)
+	}
+
+	wg.Wait()
+
+	healthy := true
+
+	for name, res := range results {
+		if res.Err != nil {
+			healthy = false
+			log.WithError(res.Err).WithField("check", name).Warn("Health check failed")
+		}
+	}
+
+	h.mu.Lock()
+
+	changed := !h.checked || h.healthy != healthy
+
+	h.results = results
+	h.healthy = healthy
+	h.checked = true
+	listeners := h.listeners
+
+	h.mu.Unlock()
+
+	if changed {
+		for _, fn := range listeners {
+			fn(healthy)
+		}
+	}
+}
+
+// Run checks everything right away & then at a regular interval, until the context is done
+func (h *HealthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	h.Check(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.Check(ctx)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/go-pg/pg/v10"
+	"github.com/go-redis/redis/v8"
+	"github.com/sakuraapp/gateway/internal/gateway"
+	"github.com/sakuraapp/gateway/internal/metrics"
+	"github.com/sakuraapp/gateway/internal/tracing"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"runtime/debug"
+	"time"
+)
+
+// interceptors are chained in this order: tracing -> metrics -> logging -> error mapping -> panic recovery -> (deadline) -> handler
+// so that recovered panics & mapped errors are what ends up being traced, logged & counted
+
+func (s *Server) unaryInterceptors() []grpc.UnaryServerInterceptor {
+	return []grpc.UnaryServerInterceptor{
+		tracing.UnaryServerInterceptor,
+		unaryMetricsInterceptor,
+		unaryLoggingInterceptor,
+		unaryErrorInterceptor,
+		unaryRecoveryInterceptor,
+		s.unaryDeadlineInterceptor,
+	}
+}
+
+func (s *Server) streamInterceptors() []grpc.StreamServerInterceptor {
+	// streams are long-lived (i.e. event feeds) so they don't get a default deadline
+	return []grpc.StreamServerInterceptor{
+		tracing.StreamServerInterceptor,
+		streamMetricsInterceptor,
+		streamLoggingInterceptor,
+		streamErrorInterceptor,
+		streamRecoveryInterceptor,
+	}
+}
+
+func unaryMetricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	res, err := handler(ctx, req)
+
+	observeCall(info.FullMethod, start, err)
+
+	return res, err
+}
+
+func streamMetricsInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	gauge := metrics.GrpcActiveStreams.WithLabelValues(info.FullMethod)
+	gauge.Inc()
+	defer gauge.Dec()
+
+	start := time.Now()
+	err := handler(srv, ss)
+
+	observeCall(info.FullMethod, start, err)
+
+	return err
+}
+
+func observeCall(method string, start time.Time, err error) {
+	code := status.Code(err)
+
+	metrics.GrpcRequests.WithLabelValues(method, code.String()).Inc()
+	metrics.GrpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+func unaryLoggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	res, err := handler(ctx, req)
+
+	logCall(info.FullMethod, start, err)
+
+	return res, err
+}
+
+func streamLoggingInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+
+	logCall(info.FullMethod, start, err)
+
+	return err
+}
+
+func logCall(method string, start time.Time, err error) {
+	code := status.Code(err)
+	logger := log.WithFields(log.Fields{
+		"method":  method,
+		"latency": time.Since(start),
+		"code":    code.String(),
+	})
+
+	if err != nil {
+		logger = logger.WithError(err)
+	}
+
+	switch code {
+	case codes.OK:
+		logger.Info("gRPC call")
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DataLoss, codes.Unimplemented:
+		logger.Error("gRPC call failed")
+	default:
+		logger.Warn("gRPC call failed")
+	}
+}
+
+func unaryErrorInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	res, err := handler(ctx, req)
+
+	return res, toStatusError(info.FullMethod, err)
+}
+
+func streamErrorInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return toStatusError(info.FullMethod, handler(srv, ss))
+}
+
+// toStatusError maps internal errors to the matching gRPC status, errors that aren't known are reported as internal without leaking their details
+func toStatusError(method string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, gateway.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, pg.ErrNoRows), errors.Is(err, redis.Nil):
+		return status.Error(codes.NotFound, "not found")
+	case errors.Is(err, gateway.ErrInvalidRequest):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+
+	log.WithError(err).WithField("method", method).Error("Internal error while handling a gRPC call")
+
+	return status.Error(codes.Internal, "internal error")
+}
+
+func unaryRecoveryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (res interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverCall(info.FullMethod, r)
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+func streamRecoveryInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverCall(info.FullMethod, r)
+		}
+	}()
+
+	return handler(srv, ss)
+}
+
+func recoverCall(method string, r interface{}) error {
+	metrics.GrpcPanics.WithLabelValues(method).Inc()
+
+	log.WithFields(log.Fields{
+		"method": method,
+		"panic":  r,
+		"stack":  string(debug.Stack()),
+	}).Error("Recovered from a panic while handling a gRPC call")
+
+	return status.Error(codes.Internal, "internal error")
+}
+
+// unaryDeadlineInterceptor gives calls that don't have a deadline a default one
+func (s *Server) unaryDeadlineInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if _, ok := ctx.Deadline(); !ok && s.GrpcDefaultTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, s.GrpcDefaultTimeout)
+		defer cancel()
+	}
+
+	return handler(ctx, req)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var errGrpcNotServing = errors.New("gRPC server is not serving")
+
+type probeCheck struct {
+	Status    string    `json:"status"`
+	LatencyMs float64   `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type probeResponse struct {
+	Status string                `json:"status"`
+	NodeId string                `json:"node_id"`
+	Checks map[string]probeCheck `json:"checks,omitempty"`
+}
+
+// handleLiveness reports the process as alive as long as it can serve http at all
+func (s *Server) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &probeResponse{
+		Status: "ok",
+		NodeId: s.NodeId(),
+	})
+}
+
+// handleReadiness reports whether this node should receive new connections, based on the latest health checks
+// checks run in the background, so probes never hit the dependencies themselves
+func (s *Server) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	res := &probeResponse{
+		Status: "ready",
+		NodeId: s.NodeId(),
+		Checks: map[string]probeCheck{},
+	}
+
+	for name, result := range s.health.Results() {
+		check := probeCheck{
+			Status:    "up",
+			LatencyMs: float64(result.Latency.Microseconds()) / 1000,
+			CheckedAt: result.CheckedAt,
+		}
+
+		if result.Err != nil {
+			check.Status = "down"
+			check.Error = result.Err.Error()
+		}
+
+		res.Checks[name] = check
+	}
+
+	code := http.StatusOK
+
+	switch {
+	case s.isDraining():
+		res.Status = "draining"
+		code = http.StatusServiceUnavailable
+	case !s.health.Healthy():
+		res.Status = "not_ready"
+		code = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, code, res)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(v)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to write http response")
+	}
+}
+
+// checkGrpc reports whether the gRPC server is accepting calls
+func (s *Server) checkGrpc(ctx context.Context) error {
+	if atomic.LoadInt32(&s.grpcServing) == 0 {
+		return errGrpcNotServing
+	}
+
+	return nil
+}
+
+func (s *Server) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// startDraining marks the node as not ready, so the orchestrator stops sending it new connections
+func (s *Server) startDraining() {
+	if atomic.CompareAndSwapInt32(&s.draining, 0, 1) {
+		s.grpcHealth.Shutdown()
+	}
+}
@@ -1,49 +1,439 @@
 package server
 
 import (
-	"github.com/sakuraapp/shared/pkg/dispatcher/gateway"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/sakuraapp/gateway/internal/broker"
+	"github.com/sakuraapp/gateway/internal/config"
+	"github.com/sakuraapp/gateway/internal/gateway"
+	"github.com/sakuraapp/gateway/internal/manager"
+	"github.com/sakuraapp/gateway/internal/metrics"
+	"github.com/sakuraapp/gateway/internal/tracing"
+	"github.com/sakuraapp/gateway/pkg/util"
+	"github.com/sakuraapp/pubsub"
+	sharedConstant "github.com/sakuraapp/shared/pkg/constant"
 	dispatcher "github.com/sakuraapp/shared/pkg/dispatcher/gateway"
+	"github.com/sakuraapp/shared/pkg/model"
+	"github.com/sakuraapp/shared/pkg/resource"
 	log "github.com/sirupsen/logrus"
 	"github.com/vmihailenco/msgpack/v5"
+	"go.opentelemetry.io/otel/trace"
+	"strconv"
+	"sync/atomic"
+	"time"
 )
 
+const (
+	pubsubMinBackoff = 100 * time.Millisecond
+	pubsubMaxBackoff = 30 * time.Second
+	pubsubQueueSize  = 256 // per shard
+)
+
+const maxReplayedEvents = 500
+
+var (
+	errPubsubDown = errors.New("pubsub connection is down")
+	errNoEventLog = errors.New("no event log to replay")
+	errEventGap   = errors.New("missed more events than the event log holds")
+	errQueueFull  = errors.New("the room's queue is full")
+)
+
+func newBroker(ctx context.Context, conf *config.Config, rdb redis.UniversalClient) (broker.Broker, error) {
+	switch broker.Backend(conf.PubsubBackend) {
+	case "", broker.BackendRedis:
+		return broker.NewRedis(ctx, rdb), nil
+	case broker.BackendMemory:
+		return broker.NewMemory(broker.NewMemoryBus()), nil
+	case broker.BackendNATS:
+		b, err := broker.NewNATS(conf.NatsUrl)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unsupported pubsub backend: %v", conf.PubsubBackend)
+	}
+}
+
 func (s *Server) initPubsub() {
-	rdb := s.rdb
+	go s.receivePubsub()
+}
+
+// nodeSubscriber relays the messages sent to this node (i.e. broadcasts) to every authenticated client on it
+type nodeSubscriber struct {
+	clientMgr *manager.ClientManager
+}
+
+func (sub *nodeSubscriber) Dispatch(msg *dispatcher.Message) {
+	for _, c := range sub.clientMgr.List() {
+		if c.Session.UserId != 0 {
+			c.Dispatch(msg)
+		}
+	}
+}
+
+func (s *Server) subscribeNode() error {
+	topic := dispatcher.NewNodeTarget(s.NodeId()).Build()
+
+	return s.subscriptionMgr.Add(s.ctx, topic, &nodeSubscriber{clientMgr: s.clientMgr})
+}
+
+// receivePubsub reads messages from the broker until it's closed
+// when receiving fails, it backs off exponentially & resubscribes, rooms on this node are then told to resync since they probably missed messages
+func (s *Server) receivePubsub() {
 	ctx := s.ctx
+	b := s.broker
+	backoff := pubsubMinBackoff
+
+	for {
+		message, err := b.Receive(ctx)
+
+		if errors.Is(err, broker.ErrClosed) || ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			atomic.StoreInt32(&s.pubsubDown, 1)
 
-	ps := rdb.Subscribe(ctx)
+			log.WithError(err).
+				WithField("retry_in", backoff).
+				Error("PubSub Error")
 
-	s.pubsub = ps
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+
+			if backoff > pubsubMaxBackoff {
+				backoff = pubsubMaxBackoff
+			}
 
-	go func() {
-		for {
-			message, err := ps.ReceiveMessage(ctx)
+			err = b.Resubscribe(ctx)
 
 			if err != nil {
-				log.Errorf("PubSub Error: %v", err)
+				log.WithError(err).Error("Failed to resubscribe to pubsub topics")
 				continue
 			}
 
-			s.taskPool.Go(func() {
-				var msg dispatcher.Message
+			s.onPubsubRecovered()
+			backoff = pubsubMinBackoff
 
-				err = msgpack.Unmarshal([]byte(message.Payload), &msg)
+			continue
+		}
+
+		// messages are handled in order per topic, so that clients never see a room's updates out of order
+		// a topic that falls behind has its messages dropped rather than holding up every other one, its room then catches up on what it missed
+		queued := s.executor.Go(message.Topic, func() {
+			s.handlePubsubMessage(message)
+		})
 
-				if err != nil {
-					log.WithError(err).Error("PubSub Deserialization Error")
-					return
-				}
+		if !queued {
+			s.onPubsubMessageDropped(message.Topic)
+		}
+	}
+}
 
-				ch := message.Channel
+func (s *Server) onPubsubMessageDropped(topic string) {
+	metrics.PubsubDropped.Inc()
 
-				log.WithField("channel", ch).Debugf("Incoming PubSub Message: %+v", msg)
+	var roomId model.RoomId
 
-				if msg.Filters[gateway.MessageFilterType] == gateway.ServerMessage {
-					s.handlerMgr.HandleServer(&msg)
-				} else {
-					s.subscriptionMgr.Dispatch(ch, &msg)
-				}
-			})
+	_, err := fmt.Sscanf(topic, sharedConstant.RoomTopicFmt, &roomId)
+
+	if err != nil {
+		// only rooms keep track of what they received, there's nothing to make up for the others
+		log.WithField("topic", topic).Error("Dropped a pubsub message, the topic's queue is full")
+		return
+	}
+
+	log.WithField("room_id", roomId).Warn("Dropped a pubsub message, the room's queue is full, resyncing")
+
+	s.markLagging(roomId)
+}
+
+// markLagging schedules a room to be resynced once its queue has room again
+func (s *Server) markLagging(roomId model.RoomId) {
+	s.laggingMu.Lock()
+	defer s.laggingMu.Unlock()
+
+	s.lagging[roomId] = true
+
+	if !s.resyncing {
+		s.resyncing = true
+		go s.resyncLagging()
+	}
+}
+
+// resyncLagging resyncs the rooms that were marked as lagging, retrying with a backoff while their queues are still full
+func (s *Server) resyncLagging() {
+	backoff := pubsubMinBackoff
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			s.laggingMu.Lock()
+			s.resyncing = false
+			s.laggingMu.Unlock()
+
+			return
+		case <-time.After(backoff):
 		}
-	}()
+
+		s.laggingMu.Lock()
+		lagging := s.lagging
+		s.lagging = map[model.RoomId]bool{}
+		s.laggingMu.Unlock()
+
+		var full []model.RoomId
+
+		for roomId := range lagging {
+			room := s.roomMgr.Get(roomId)
+
+			if room != nil && !s.resyncRoom(room) {
+				full = append(full, roomId)
+			}
+		}
+
+		s.laggingMu.Lock()
+
+		for _, roomId := range full {
+			s.lagging[roomId] = true
+		}
+
+		if len(s.lagging) == 0 {
+			s.resyncing = false
+			s.laggingMu.Unlock()
+
+			return
+		}
+
+		s.laggingMu.Unlock()
+
+		backoff *= 2
+
+		if backoff > pubsubMaxBackoff {
+			backoff = pubsubMaxBackoff
+		}
+	}
+}
+
+func (s *Server) onPubsubRecovered() {
+	if !atomic.CompareAndSwapInt32(&s.pubsubDown, 1, 0) {
+		return
+	}
+
+	log.Info("PubSub connection recovered")
+
+	go s.resyncRooms()
+}
+
+func (s *Server) handlePubsubMessage(message *broker.Message) {
+	var msg dispatcher.Message
+
+	err := msgpack.Unmarshal(message.Payload, &msg)
+
+	if err != nil {
+		metrics.PubsubDecodeFailures.Inc()
+		log.WithError(err).Error("PubSub Deserialization Error")
+		return
+	}
+
+	ch := message.Topic
+
+	log.WithField("channel", ch).Debugf("Incoming PubSub Message: %+v", msg)
+
+	msgType, _ := util.FilterInt(msg.Filters, dispatcher.MessageFilterType)
+
+	ctx := tracing.Extract(s.ctx, msg.Filters[gateway.MessageFilterTrace])
+	_, span := tracing.Start(ctx, "pubsub.receive", trace.WithSpanKind(trace.SpanKindConsumer))
+	span.SetAttributes(
+		tracing.Attr("messaging.destination", ch),
+		tracing.Attr("ws.opcode", int(msg.Payload.Opcode)),
+	)
+	defer span.End()
+
+	if msg.Payload.Time.Valid {
+		lag := time.Since(time.UnixMilli(msg.Payload.Time.Int64))
+		metrics.PubsubLag.WithLabelValues(strconv.Itoa(int(msgType))).Observe(lag.Seconds())
+	}
+
+	if dispatcher.MessageType(msgType) == dispatcher.ServerMessage {
+		s.handlerMgr.HandleServer(&msg)
+	} else {
+		s.subscriptionMgr.Dispatch(ch, &msg)
+	}
+}
+
+// resyncRooms catches the rooms on this node up on what they missed, by replaying their event log
+// rooms that can't be caught up (no log, or it was trimmed past what they last received) are told to refetch their state instead
+func (s *Server) resyncRooms() {
+	for _, room := range s.roomMgr.Rooms() {
+		if !s.resyncRoom(room) {
+			s.markLagging(room.Id())
+		}
+	}
+}
+
+// resyncRoom catches a room up on what it missed, it returns false if nothing could be queued because the room's queue is full
+func (s *Server) resyncRoom(room *manager.Room) bool {
+	err := s.replayRoom(room)
+
+	if err == nil {
+		return true
+	}
+
+	if errors.Is(err, errQueueFull) {
+		return false
+	}
+
+	log.WithError(err).WithField("room_id", room.Id()).Warn("Failed to replay room events, resyncing")
+
+	msg := dispatcher.NewMessage(resource.BuildPacket(gateway.Resync, nil))
+	topic := dispatcher.NewRoomTarget(room.Id()).Build()
+
+	return s.executor.Go(topic, func() {
+		room.Dispatch(msg)
+	})
+}
+
+func (s *Server) replayRoom(room *manager.Room) error {
+	events := s.repos.RoomEvent
+
+	if !events.Enabled() {
+		return errNoEventLog
+	}
+
+	lastSeq, lastId := room.Cursor()
+
+	if lastId == "" {
+		return errNoEventLog
+	}
+
+	entries, err := events.Range(s.ctx, room.Id(), lastId, maxReplayedEvents)
+
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if entries[0].Seq > lastSeq+1 || len(entries) == maxReplayedEvents {
+		return errEventGap
+	}
+
+	topic := dispatcher.NewRoomTarget(room.Id()).Build()
+
+	// replayed through the room's shard so they can't interleave with live messages
+	// messages that also made it through live are sent twice, clients can tell them apart by their sequence number
+	queued := s.executor.Go(topic, func() {
+		for _, entry := range entries {
+			entry.Message.Filters[gateway.MessageFilterStreamId] = entry.Id
+			room.Dispatch(entry.Message)
+		}
+	})
+
+	if !queued {
+		return errQueueFull
+	}
+
+	return nil
+}
+
+func (s *Server) DispatchTo(target pubsub.MessageTarget, message interface{}) error {
+	return s.DispatchToContext(s.ctx, target, message)
+}
+
+// DispatchToContext publishes a message along with the trace context of ctx
+func (s *Server) DispatchToContext(ctx context.Context, target pubsub.MessageTarget, message interface{}) error {
+	msg, ok := message.(*dispatcher.Message)
+
+	if !ok {
+		return s.Dispatcher.DispatchTo(target, message)
+	}
+
+	ctx, span := tracing.Start(ctx, "pubsub.publish", trace.WithSpanKind(trace.SpanKindProducer))
+	span.SetAttributes(
+		tracing.Attr("messaging.destination", target.Build()),
+		tracing.Attr("ws.opcode", int(msg.Payload.Opcode)),
+	)
+
+	if msg.Filters == nil {
+		msg.Filters = pubsub.FilterMap{}
+	}
+
+	if carrier := tracing.Inject(ctx); carrier != nil {
+		msg.Filters[gateway.MessageFilterTrace] = carrier
+	}
+
+	err := s.publish(ctx, target, msg)
+	tracing.End(span, err)
+
+	return err
+}
+
+// publish stamps room messages with the room's next sequence number before publishing them
+// the number is taken & published under a lock so messages from this node go out in sequence, messages from different nodes can still interleave
+func (s *Server) publish(ctx context.Context, target pubsub.MessageTarget, msg *dispatcher.Message) error {
+	roomTarget, ok := target.(dispatcher.MessageTargetRoom)
+
+	if !ok {
+		return s.Dispatcher.DispatchTo(target, msg)
+	}
+
+	if msgType, _ := util.FilterInt(msg.Filters, dispatcher.MessageFilterType); dispatcher.MessageType(msgType) == dispatcher.ServerMessage {
+		return s.Dispatcher.DispatchTo(target, msg)
+	}
+
+	events := s.repos.RoomEvent
+
+	// the message goes out from the same redis, so it can be stamped & published in one go.
+	// other brokers have to hold the room's lock across both
+	if _, ok := s.broker.(*broker.RedisBroker); ok {
+		_, _, err := events.Publish(ctx, model.RoomId(roomTarget), target.Build(), msg)
+
+		return err
+	}
+
+	mu := &s.seqLocks[uint32(roomTarget)%uint32(len(s.seqLocks))]
+	mu.Lock()
+	defer mu.Unlock()
+
+	seq, err := events.NextSeq(ctx, model.RoomId(roomTarget))
+
+	if err != nil {
+		return err
+	}
+
+	msg.Filters[gateway.MessageFilterSequence] = seq
+
+	if events.Enabled() {
+		// the live message still goes out if it can't be logged, it just can't be replayed
+		id, err := events.Append(ctx, model.RoomId(roomTarget), seq, msg)
+
+		if err != nil {
+			log.WithError(err).WithField("room_id", roomTarget).Error("Failed to append to the room event log")
+		} else {
+			msg.Filters[gateway.MessageFilterStreamId] = id
+		}
+	}
+
+	return s.Dispatcher.DispatchTo(target, msg)
+}
+
+// checkPubsub reports the broker as unhealthy while the receive loop is recovering from an error
+func (s *Server) checkPubsub(ctx context.Context) error {
+	if atomic.LoadInt32(&s.pubsubDown) == 1 {
+		return errPubsubDown
+	}
+
+	return s.broker.Ping(ctx)
 }
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"github.com/go-redis/redis/v8"
+	"github.com/sakuraapp/gateway/internal/config"
+)
+
+// newRedis connects to redis the way it's deployed, the clients of every mode are used the same way
+func newRedis(conf *config.Config) (redis.UniversalClient, error) {
+	// in sentinel mode, each node's certificate is verified against the address it's reached at
+	tlsAddr := ""
+
+	if conf.RedisMode == config.RedisStandalone {
+		tlsAddr = conf.RedisAddr
+	}
+
+	tlsConfig, err := config.NewTLSConfig(conf.RedisTLS, tlsAddr, conf.RedisTLSCAPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch conf.RedisMode {
+	case config.RedisSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       conf.RedisMasterName,
+			SentinelAddrs:    conf.RedisAddrs,
+			SentinelPassword: conf.RedisSentinelPassword,
+			Password:         conf.RedisPassword,
+			DB:               conf.RedisDatabase,
+			TLSConfig:        tlsConfig,
+			DialTimeout:      conf.RedisDialTimeout,
+			ReadTimeout:      conf.RedisReadTimeout,
+			WriteTimeout:     conf.RedisWriteTimeout,
+		}), nil
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:         conf.RedisAddr,
+			Password:     conf.RedisPassword,
+			DB:           conf.RedisDatabase,
+			TLSConfig:    tlsConfig,
+			DialTimeout:  conf.RedisDialTimeout,
+			ReadTimeout:  conf.RedisReadTimeout,
+			WriteTimeout: conf.RedisWriteTimeout,
+		}), nil
+	}
+}
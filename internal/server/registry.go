@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"github.com/sakuraapp/gateway/internal/repository"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	nodeHeartbeatInterval = 5 * time.Second
+	nodeHeartbeatTTL      = 3 * nodeHeartbeatInterval
+	nodeReaperInterval    = 30 * time.Second
+	nodeReaperLockTTL     = nodeReaperInterval - 5*time.Second
+)
+
+// runRegistry keeps this node's heartbeat alive & periodically cleans up after dead nodes, until the server's context is done
+func (s *Server) runRegistry() {
+	heartbeat := time.NewTicker(nodeHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	reaper := time.NewTicker(nodeReaperInterval)
+	defer reaper.Stop()
+
+	s.heartbeat()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-heartbeat.C:
+			s.heartbeat()
+		case <-reaper.C:
+			s.reapDeadNodes()
+		}
+	}
+}
+
+func (s *Server) heartbeat() {
+	info := &repository.NodeInfo{
+		Id:          s.NodeId(),
+		Hostname:    s.hostname,
+		StartedAt:   s.startedAt,
+		HeartbeatAt: time.Now(),
+		Clients:     s.clientMgr.Len(),
+		Rooms:       s.roomMgr.Len(),
+		Draining:    s.isDraining(),
+	}
+
+	err := s.repos.Node.Heartbeat(s.ctx, info, nodeHeartbeatTTL)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to send node heartbeat")
+	}
+}
+
+// reapDeadNodes cleans up after the nodes whose heartbeat expired, only one node does it at a time
+func (s *Server) reapDeadNodes() {
+	nodes := s.repos.Node
+	locked, err := nodes.AcquireReaper(s.ctx, s.NodeId(), nodeReaperLockTTL)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to acquire the node reaper lock")
+		return
+	}
+
+	if !locked {
+		return
+	}
+
+	defer func() {
+		// released even if the node started shutting down in the meantime
+		err := nodes.ReleaseReaper(context.Background(), s.NodeId())
+
+		if err != nil {
+			log.WithError(err).Error("Failed to release the node reaper lock")
+		}
+	}()
+
+	deadNodes, err := nodes.DeadNodes(s.ctx)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to list dead nodes")
+		return
+	}
+
+	for _, nodeId := range deadNodes {
+		if nodeId == s.NodeId() {
+			continue // our heartbeat only just expired, i.e. redis was unreachable for a while
+		}
+
+		err = s.handlers.ReapNode(s.ctx, nodeId)
+
+		if err != nil {
+			log.WithError(err).WithField("node_id", nodeId).Error("Failed to reap dead node")
+		}
+	}
+}
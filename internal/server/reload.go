@@ -0,0 +1,112 @@
+package server
+
+import (
+	"github.com/rs/cors"
+	"github.com/sakuraapp/gateway/internal/config"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func newCors(allowedOrigins []string) *cors.Cors {
+	return cors.New(cors.Options{
+		AllowedOrigins:   allowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Cache-Control", "Upgrade"},
+		ExposedHeaders:   []string{"Link"},
+		AllowCredentials: false,
+		MaxAge:           300, // Maximum value not ignored by any of major browsers
+	})
+}
+
+func (s *Server) getCors() *cors.Cors {
+	return s.cors.Load().(*cors.Cors)
+}
+
+// corsHandler goes through the current cors settings on every request, so they can be swapped while the server is running
+func (s *Server) corsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.getCors().ServeHTTP(w, r, next.ServeHTTP)
+	})
+}
+
+// initReload applies config changes from SIGHUP, the config file & the overrides in redis
+func (s *Server) initReload() error {
+	s.reloader.OnChange(s.onConfigChange)
+
+	err := s.reloader.WatchFile(s.ctx)
+
+	if err != nil {
+		return err
+	}
+
+	// subscribed before the overrides are first read, so a change can't slip in between
+	sub := s.repos.Config.Subscribe(s.ctx)
+	_, err = sub.Receive(s.ctx)
+
+	if err != nil {
+		sub.Close()
+		return err
+	}
+
+	s.loadConfigOverrides()
+
+	go func() {
+		for range sub.Channel() {
+			s.loadConfigOverrides()
+		}
+	}()
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		for range hangup {
+			log.Info("Received SIGHUP, reloading the config")
+
+			err := s.reloader.Reload()
+
+			if err != nil {
+				log.WithError(err).Error("Failed to reload the config")
+			}
+		}
+	}()
+
+	go func() {
+		<-s.ctx.Done()
+
+		signal.Stop(hangup)
+		close(hangup)
+		_ = sub.Close()
+	}()
+
+	return nil
+}
+
+func (s *Server) loadConfigOverrides() {
+	overrides, err := s.repos.Config.Overrides(s.ctx)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to fetch the config overrides")
+		return
+	}
+
+	err = s.reloader.SetOverrides(overrides)
+
+	if err != nil {
+		log.WithError(err).Error("Failed to apply the config overrides")
+	}
+}
+
+func (s *Server) onConfigChange(conf *config.Config) {
+	initLogging(conf)
+
+	s.cors.Store(newCors(conf.AllowedOrigins))
+	s.crawler.SetDomains(conf.Crawler.AllowedDomains, conf.Crawler.DeniedDomains)
+
+	for _, c := range s.clientMgr.List() {
+		c.SetRateLimit(conf.MessageRateLimit, conf.MessageRateBurst)
+	}
+}
@@ -11,42 +11,57 @@ import (
 	"github.com/lesismal/nbio/nbhttp"
 	"github.com/lesismal/nbio/nbhttp/websocket"
 	"github.com/lesismal/nbio/taskpool"
-	"github.com/rs/cors"
+	"github.com/sakuraapp/gateway/internal/broker"
 	"github.com/sakuraapp/gateway/internal/client"
 	"github.com/sakuraapp/gateway/internal/config"
+	"github.com/sakuraapp/gateway/internal/database"
 	"github.com/sakuraapp/gateway/internal/handler"
 	"github.com/sakuraapp/gateway/internal/manager"
+	"github.com/sakuraapp/gateway/internal/metrics"
 	"github.com/sakuraapp/gateway/internal/repository"
+	"github.com/sakuraapp/gateway/internal/tracing"
+	"github.com/sakuraapp/gateway/pkg/gatewaypb"
 	"github.com/sakuraapp/gateway/pkg/util"
-	gatewaypb "github.com/sakuraapp/protobuf/gateway"
 	"github.com/sakuraapp/pubsub"
 	"github.com/sakuraapp/shared/pkg/crypto"
 	dispatcher "github.com/sakuraapp/shared/pkg/dispatcher/gateway"
+	"github.com/sakuraapp/shared/pkg/model"
 	"github.com/sakuraapp/shared/pkg/resource"
 	"github.com/sakuraapp/shared/pkg/resource/opcode"
 	sharedUtil "github.com/sakuraapp/shared/pkg/util"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// shutdownTimeout is how long the servers are given to stop once the clients are gone
+const shutdownTimeout = 10 * time.Second
+
 type Server struct {
 	gatewaypb.UnimplementedGatewayServiceServer
 	config.Config
 	pubsub.Dispatcher
-	cors            *cors.Cors
+	cors            atomic.Value // *cors.Cors
+	reloader        *config.Reloader
 	taskPool        *taskpool.MixedPool
 	server          *nbhttp.Server
 	ctx             context.Context
 	ctxCancel       context.CancelFunc
+	streamsCtx      context.Context // ends the event streams, which would otherwise hold up the grpc server's graceful stop
+	streamsCancel   context.CancelFunc
 	crawler         *util.Crawler
 	resourceBuilder *resource.Builder
 	jwt             *util.JWT
-	db              *pg.DB
-	rdb             *redis.Client
+	db              *database.DB
+	rdb             redis.UniversalClient
 	repos           *repository.Repositories
 	handlers        *handler.Handlers
 	cache           *cache.Cache
@@ -55,34 +70,46 @@ type Server struct {
 	handlerMgr      *manager.HandlerManager
 	roomMgr         *manager.RoomManager
 	subscriptionMgr *dispatcher.SubscriptionManager
-	pubsub          *redis.PubSub
+	broker          broker.Broker
+	pubsubDown      int32 // accessed atomically
+	executor        *util.ShardedExecutor
+	laggingMu       sync.Mutex
+	lagging         map[model.RoomId]bool // rooms that had messages dropped & are waiting to be resynced
+	resyncing       bool                  // whether resyncLagging is running
+	seqLocks        [64]sync.Mutex
 	grpc            *grpc.Server
+	grpcHealth      *health.Server
+	grpcServing     int32 // accessed atomically
+	draining        int32 // accessed atomically
+	admin           *http.Server
+	health          *HealthChecker
+	events          *eventFeed
+	stopTracing     func(context.Context) error
+	hostname        string
+	startedAt       time.Time
+	interrupt       chan os.Signal
 }
 
-func New(conf config.Config) *Server {
-	c := cors.New(cors.Options{
-		AllowedOrigins:   conf.AllowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Cache-Control", "Upgrade"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: false,
-		MaxAge:           300, // Maximum value not ignored by any of major browsers
-	})
+func New(reloader *config.Reloader) *Server {
+	conf := *reloader.Config()
+
+	initLogging(&conf)
 
-	dbOpts := pg.Options{
-		User:     conf.DatabaseUser,
-		Password: conf.DatabasePassword,
-		Database: conf.DatabaseName,
+	db, err := newDatabase(&conf)
+
+	if err != nil {
+		log.WithError(err).Fatal("Failed to set up the database")
 	}
 
-	db := pg.Connect(&dbOpts)
 	ctx, cancel := context.WithCancel(context.Background())
+	streamsCtx, streamsCancel := context.WithCancel(ctx)
 
 	if conf.IsDev() {
-		log.SetLevel(log.DebugLevel)
-		db.AddQueryHook(pgdebug.DebugHook{
-			// Print all queries.
-			Verbose: true,
+		db.ForEach(func(db *pg.DB, role database.Role) {
+			db.AddQueryHook(pgdebug.DebugHook{
+				// Print all queries.
+				Verbose: true,
+			})
 		})
 	}
 
@@ -90,11 +117,11 @@ func New(conf config.Config) *Server {
 		log.WithError(err).Fatal("Failed to open database connection")
 	}
 
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     conf.RedisAddr,
-		Password: conf.RedisPassword,
-		DB:       conf.RedisDatabase,
-	})
+	rdb, err := newRedis(&conf)
+
+	if err != nil {
+		log.WithError(err).Fatal("Failed to set up redis")
+	}
 
 	myCache := cache.New(&cache.Options{
 		Redis: rdb,
@@ -102,7 +129,8 @@ func New(conf config.Config) *Server {
 		// until server-assisted client cache is possible, don't keep a client cache (we can't invalidate it)
 	})
 
-	repos := repository.Init(db, myCache)
+	crawler := util.NewCrawler(conf.Crawler)
+	repos := repository.Init(&conf, db, rdb, myCache, crawler)
 
 	jwtPublicKey, err := crypto.LoadRSAPublicKey(conf.JWTPublicPath)
 
@@ -110,6 +138,26 @@ func New(conf config.Config) *Server {
 		log.WithError(err).Fatal("Failed to load public key")
 	}
 
+	stopTracing, err := tracing.Init(tracing.Options{
+		Exporter:    tracing.Exporter(conf.TracingExporter),
+		Endpoint:    conf.TracingEndpoint,
+		Insecure:    conf.TracingInsecure,
+		SampleRatio: conf.TracingSampleRatio,
+		NodeId:      conf.NodeId,
+	})
+
+	if err != nil {
+		log.WithError(err).Fatal("Failed to set up tracing")
+	}
+
+	if conf.TracingExporter != "" {
+		db.ForEach(func(db *pg.DB, role database.Role) {
+			db.AddQueryHook(tracing.PostgresHook{})
+		})
+		rdb.AddHook(tracing.RedisHook{})
+		crawler.AddHook(tracing.CrawlerHook{})
+	}
+
 	s3Config := &sharedUtil.S3Config{
 		Bucket:         conf.S3Bucket,
 		Region:         conf.S3Region,
@@ -131,16 +179,19 @@ func New(conf config.Config) *Server {
 	serverConfig := nbhttp.Config{
 		Network:                 "tcp",
 		Addrs:                   []string{addr},
-		MaxLoad:                 1000000,
+		MaxLoad:                 conf.MaxConnections,
+		ReadLimit:               conf.MaxMessageSize,
 		ReleaseWebsocketPayload: true,
 	}
 
 	s := &Server{
 		Config:          conf,
-		cors:            c,
-		ctx:             context.Background(),
+		reloader:        reloader,
+		ctx:             ctx,
 		ctxCancel:       cancel,
-		crawler:         util.NewCrawler(),
+		streamsCtx:      streamsCtx,
+		streamsCancel:   streamsCancel,
+		crawler:         crawler,
 		resourceBuilder: resourceBuilder,
 		taskPool:        util.NewTaskpool(&serverConfig),
 		jwt:             &util.JWT{PublicKey: jwtPublicKey},
@@ -151,28 +202,67 @@ func New(conf config.Config) *Server {
 		clientMgr:       manager.NewClientManager(),
 		sessionMgr:      manager.NewSessionManager(),
 		handlerMgr:      manager.NewHandlerManager(),
+		events:          newEventFeed(),
+		lagging:         map[model.RoomId]bool{},
+		health:          NewHealthChecker(),
+		stopTracing:     stopTracing,
+		startedAt:       time.Now(),
+		interrupt:       make(chan os.Signal, 1),
 	}
 
-	s.Dispatcher = pubsub.NewRedisDispatcher(s.ctx, s.NodeId(), s.rdb)
+	s.hostname, _ = os.Hostname()
+	s.cors.Store(newCors(conf.AllowedOrigins))
+
+	b, err := newBroker(s.ctx, &conf, rdb)
+
+	if err != nil {
+		log.WithError(err).Fatal("Failed to connect to the pubsub backend")
+	}
+
+	s.broker = b
+	s.Dispatcher = b
+	s.executor = util.NewShardedExecutor(runtime.NumCPU()*4, pubsubQueueSize)
 	s.initPubsub()
 
-	s.subscriptionMgr = dispatcher.NewSubscriptionManager(s.pubsub)
+	s.subscriptionMgr = dispatcher.NewSubscriptionManager(b)
 	s.roomMgr = manager.NewRoomManager(s.subscriptionMgr)
 
+	err = s.subscribeNode()
+
+	if err != nil {
+		log.WithError(err).Fatal("Failed to subscribe to the node's topic")
+	}
+
 	s.handlers = handler.Init(s)
+	s.handlerMgr.RegisterServer(opcode.KickUser, s.events.onKickUser)
 
-	go s.initGrpc()
+	s.initHealthChecks()
+	s.initMetrics()
 
 	mux := &http.ServeMux{}
 	mux.HandleFunc("/", s.onConnection)
+	mux.HandleFunc("/healthz", s.handleLiveness)
+	mux.HandleFunc("/readyz", s.handleReadiness)
 
-	h := c.Handler(mux)
+	h := s.corsHandler(mux)
 
-	s.server = nbhttp.NewServer(serverConfig, h, s.taskPool.Go)
+	s.server = nbhttp.NewServer(serverConfig, h, s.runTask)
 
 	return s
 }
 
+func initLogging(conf *config.Config) {
+	level, err := log.ParseLevel(conf.LogLevel)
+
+	if err == nil {
+		log.SetLevel(level)
+	}
+
+	if conf.LogFormat == "json" {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+}
+
 func (s *Server) Context() context.Context {
 	return s.ctx
 }
@@ -181,8 +271,9 @@ func (s *Server) NodeId() string {
 	return s.Config.NodeId
 }
 
+// GetConfig returns the running config, unlike the embedded config its reloadable settings are kept up to date
 func (s *Server) GetConfig() *config.Config {
-	return &s.Config
+	return s.reloader.Config()
 }
 
 func (s *Server) GetBuilder() *resource.Builder {
@@ -198,14 +289,14 @@ func (s *Server) GetJWT() *util.JWT {
 }
 
 func (s *Server) GetDB() *pg.DB {
-	return s.db
+	return s.db.Primary()
 }
 
 func (s *Server) GetRepos() *repository.Repositories {
 	return s.repos
 }
 
-func (s *Server) GetRedis() *redis.Client {
+func (s *Server) GetRedis() redis.UniversalClient {
 	return s.rdb
 }
 
@@ -233,8 +324,44 @@ func (s *Server) GetSubscriptionMgr() *dispatcher.SubscriptionManager {
 	return s.subscriptionMgr
 }
 
+func (s *Server) initHealthChecks() {
+	s.health.Register("redis", func(ctx context.Context) error {
+		return s.rdb.Ping(ctx).Err()
+	})
+	s.health.Register("postgres", func(ctx context.Context) error {
+		return s.db.Ping(ctx)
+	})
+	s.health.Register("pubsub", s.checkPubsub)
+	s.health.Register("grpc", s.checkGrpc)
+}
+
+func (s *Server) GetHealth() *HealthChecker {
+	return s.health
+}
+
 func (s *Server) Start() error {
-	err := s.server.Start()
+	err := s.initGrpc()
+
+	if err != nil {
+		return err
+	}
+
+	err = s.initAdmin()
+
+	if err != nil {
+		return err
+	}
+
+	err = s.initReload()
+
+	if err != nil {
+		return err
+	}
+
+	go s.health.Run(s.ctx)
+	go s.runRegistry()
+
+	err = s.server.Start()
 
 	go s.clientMgr.StartTicker()
 	defer s.clientMgr.StopTicker()
@@ -245,36 +372,72 @@ func (s *Server) Start() error {
 
 	log.Printf("Server is listening on port %v", s.Port)
 
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
+	signal.Notify(s.interrupt, os.Interrupt, syscall.SIGTERM)
 
-	<-interrupt
+	<-s.interrupt
 
-	// ctx, cancel := context.WithTimeout(context.Background(), time.Second * 5)
+	s.drain(s.interrupt)
 
-	// defer cancel()
-	defer s.ctxCancel()
+	// items that are still being enriched are updated before the node goes away
+	s.handlers.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
 	log.Println("Shutting down...")
-	err = s.server.Shutdown(s.ctx)
+	err = s.server.Shutdown(ctx)
 
 	if err != nil {
 		panic(err)
 	}
 
+	// the event streams never end on their own, the rpcs that are still running (and what they publish) are finished first
+	s.streamsCancel()
 	s.grpc.GracefulStop()
 
+	// stops everything that runs in the background (pubsub, health checks, the registry & config reloads)
+	s.ctxCancel()
+	s.stopAdmin(ctx)
+
+	err = s.repos.Node.Deregister(ctx, s.NodeId())
+
+	if err != nil {
+		log.WithError(err).Error("Failed to deregister node")
+	}
+
+	err = s.broker.Close()
+
+	if err != nil {
+		log.WithError(err).Error("Failed to close the pubsub broker")
+	}
+
+	// messages that were already received still go out to the clients that are left
+	s.executor.Stop()
+
+	err = s.stopTracing(context.Background())
+
+	if err != nil {
+		log.WithError(err).Error("Failed to flush traces")
+	}
+
 	return nil
 }
 
 func (s *Server) newUpgrader() *websocket.Upgrader {
 	u := websocket.NewUpgrader()
-	u.CheckOrigin = s.cors.OriginAllowed
+	u.CheckOrigin = func(r *http.Request) bool {
+		return s.getCors().OriginAllowed(r)
+	}
 
 	return u
 }
 
 func (s *Server) onConnection(w http.ResponseWriter, r *http.Request) {
+	if s.isDraining() {
+		http.Error(w, "node is draining", http.StatusServiceUnavailable)
+		return
+	}
+
 	u := s.newUpgrader()
 
 	conn, err := u.Upgrade(w, r, nil)
@@ -288,6 +451,9 @@ func (s *Server) onConnection(w http.ResponseWriter, r *http.Request) {
 	c := client.NewClient(s.ctx, wsConn, u)
 	c.Session = client.NewSession(0, s.NodeId())
 
+	conf := s.GetConfig()
+	c.SetRateLimit(conf.MessageRateLimit, conf.MessageRateBurst)
+
 	s.clientMgr.Add(c)
 
 	u.OnMessage(func(conn *websocket.Conn, messageType websocket.MessageType, data []byte) {
@@ -298,6 +464,13 @@ func (s *Server) onConnection(w http.ResponseWriter, r *http.Request) {
 			log.WithError(err).Error("Failed to set read deadline")
 		}
 
+		if !c.Allow() {
+			metrics.PacketsRateLimited.Inc()
+			log.WithField("session_id", c.Session.Id).Debug("Client went over its rate limit, dropping packet")
+
+			return
+		}
+
 		var packet resource.Packet
 
 		err = json.Unmarshal(data, &packet)
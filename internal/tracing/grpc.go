@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// metadataCarrier reads & writes trace context from/to grpc metadata
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+
+	for k := range c {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+func startGrpcSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	}
+
+	ctx, span := Start(ctx, method, trace.WithSpanKind(trace.SpanKindServer))
+	span.SetAttributes(Attr("rpc.system", "grpc"), Attr("rpc.method", method))
+
+	return ctx, span
+}
+
+func endGrpcSpan(span trace.Span, err error) {
+	code := status.Code(err)
+	span.SetAttributes(Attr("rpc.grpc.status_code", int(code)))
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, code.String())
+	}
+
+	span.End()
+}
+
+// UnaryServerInterceptor starts a span for every call, joining the caller's trace if it sent one along
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, span := startGrpcSpan(ctx, info.FullMethod)
+	res, err := handler(ctx, req)
+
+	endGrpcSpan(span, err)
+
+	return res, err
+}
+
+type tracedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedStream) Context() context.Context {
+	return s.ctx
+}
+
+func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, span := startGrpcSpan(ss.Context(), info.FullMethod)
+	err := handler(srv, &tracedStream{ServerStream: ss, ctx: ctx})
+
+	endGrpcSpan(span, err)
+
+	return err
+}
@@ -0,0 +1,113 @@
+package tracing
+
+import (
+	"context"
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/go-redis/redis/v8"
+	"github.com/sakuraapp/gateway/pkg/util"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RedisHook wraps every redis command in a span, pipelines get a single span listing their commands
+type RedisHook struct{}
+
+func (RedisHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, span := Start(ctx, "redis."+cmd.Name(), trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(Attr("db.system", "redis"), Attr("db.operation", cmd.Name()))
+
+	return ctx, nil
+}
+
+func (RedisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endRedisSpan(ctx, cmd.Err())
+
+	return nil
+}
+
+func (RedisHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	names := make([]string, len(cmds))
+
+	for i, cmd := range cmds {
+		names[i] = cmd.Name()
+	}
+
+	ctx, span := Start(ctx, "redis.pipeline", trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(
+		Attr("db.system", "redis"),
+		Attr("db.operation", "pipeline"),
+		Attr("db.redis.pipeline_length", len(cmds)),
+		attribute.StringSlice("db.redis.commands", names),
+	)
+
+	return ctx, nil
+}
+
+func (RedisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); cmdErr != nil {
+			err = cmdErr
+			break
+		}
+	}
+
+	endRedisSpan(ctx, err)
+
+	return nil
+}
+
+func endRedisSpan(ctx context.Context, err error) {
+	if err == redis.Nil {
+		err = nil // a missing key is an expected result, not a failure
+	}
+
+	End(trace.SpanFromContext(ctx), err)
+}
+
+// CrawlerHook wraps every crawl in a span
+type CrawlerHook struct{}
+
+func (CrawlerHook) BeforeCrawl(ctx context.Context, rawUrl string) context.Context {
+	ctx, span := Start(ctx, "crawler.Get", trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(Attr("http.url", rawUrl))
+
+	return ctx
+}
+
+func (CrawlerHook) AfterCrawl(ctx context.Context, rawUrl string, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(Attr("crawler.outcome", util.CrawlOutcome(err)))
+
+	End(span, err)
+}
+
+// PostgresHook wraps every postgres query in a span
+type PostgresHook struct{}
+
+func (PostgresHook) BeforeQuery(ctx context.Context, event *pg.QueryEvent) (context.Context, error) {
+	operation := "raw"
+
+	if op, ok := event.Query.(interface{ Operation() orm.QueryOp }); ok {
+		operation = string(op.Operation())
+	}
+
+	ctx, span := Start(ctx, "postgres."+operation, trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(Attr("db.system", "postgresql"), Attr("db.operation", operation))
+
+	return ctx, nil
+}
+
+func (PostgresHook) AfterQuery(ctx context.Context, event *pg.QueryEvent) error {
+	err := event.Err
+
+	if err == pg.ErrNoRows {
+		err = nil
+	}
+
+	End(trace.SpanFromContext(ctx), err)
+
+	return nil
+}
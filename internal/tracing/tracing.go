@@ -0,0 +1,157 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/sakuraapp/gateway"
+
+type Exporter string
+
+const (
+	ExporterNone   Exporter = ""
+	ExporterOTLP   Exporter = "otlp"
+	ExporterStdout Exporter = "stdout"
+)
+
+type Options struct {
+	Exporter    Exporter
+	Endpoint    string  // otlp/http endpoint (host:port), the standard OTEL_EXPORTER_OTLP_* env variables are used when it's empty
+	Insecure    bool    // otlp without TLS
+	SampleRatio float64 // share of traces that get sampled, only applies to traces that start on this node
+	NodeId      string
+}
+
+// Init sets up the global tracer provider & propagator, the returned function flushes & stops it
+// when no exporter is configured, spans are still propagated but never recorded
+func Init(opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch opts.Exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		var clientOpts []otlptracehttp.Option
+
+		if opts.Endpoint != "" {
+			clientOpts = append(clientOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+		}
+
+		if opts.Insecure {
+			clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+		}
+
+		exporter, err = otlptracehttp.New(context.Background(), clientOpts...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unsupported tracing exporter: %v", opts.Exporter)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String("gateway"),
+		semconv.ServiceInstanceIDKey.String(opts.NodeId),
+	)
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, opts...)
+}
+
+// WithSpanFrom returns ctx with the span of from, for work that outlives from (or must not be cancelled along with it) but is still part of its trace
+func WithSpanFrom(ctx context.Context, from context.Context) context.Context {
+	return trace.ContextWithSpan(ctx, trace.SpanFromContext(from))
+}
+
+// End records err (if any) on the span & ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// Inject returns the trace context of ctx as a map, so it can travel along with a message
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	if len(carrier) == 0 {
+		return nil
+	}
+
+	return carrier
+}
+
+// Extract returns ctx with the trace context that was injected in carrier
+// carrier can be a map[string]string or a map[string]interface{}, which is what it becomes once it went through msgpack
+func Extract(ctx context.Context, carrier interface{}) context.Context {
+	mapCarrier := propagation.MapCarrier{}
+
+	switch c := carrier.(type) {
+	case map[string]string:
+		for k, v := range c {
+			mapCarrier[k] = v
+		}
+	case map[string]interface{}:
+		for k, v := range c {
+			if str, ok := v.(string); ok {
+				mapCarrier[k] = str
+			}
+		}
+	default:
+		return ctx
+	}
+
+	return otel.GetTextMapPropagator().Extract(ctx, mapCarrier)
+}
+
+func Attr(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	default:
+		return attribute.String(key, fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,493 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: gateway.proto
+
+package gatewaypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// GatewayServiceClient is the client API for GatewayService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type GatewayServiceClient interface {
+	SetCurrentItem(ctx context.Context, in *SetCurrentItemRequest, opts ...grpc.CallOption) (*SetCurrentItemResponse, error)
+	GetRoomState(ctx context.Context, in *GetRoomStateRequest, opts ...grpc.CallOption) (*GetRoomStateResponse, error)
+	EnqueueItem(ctx context.Context, in *EnqueueItemRequest, opts ...grpc.CallOption) (*EnqueueItemResponse, error)
+	RemoveItem(ctx context.Context, in *RemoveItemRequest, opts ...grpc.CallOption) (*RemoveItemResponse, error)
+	SetPlayerState(ctx context.Context, in *SetPlayerStateRequest, opts ...grpc.CallOption) (*SetPlayerStateResponse, error)
+	KickUser(ctx context.Context, in *KickUserRequest, opts ...grpc.CallOption) (*KickUserResponse, error)
+	UpdateRole(ctx context.Context, in *UpdateRoleRequest, opts ...grpc.CallOption) (*UpdateRoleResponse, error)
+	CloseRoom(ctx context.Context, in *CloseRoomRequest, opts ...grpc.CallOption) (*CloseRoomResponse, error)
+	GetRoomEvents(ctx context.Context, in *GetRoomEventsRequest, opts ...grpc.CallOption) (*GetRoomEventsResponse, error)
+	StreamRoomEvents(ctx context.Context, in *StreamRoomEventsRequest, opts ...grpc.CallOption) (GatewayService_StreamRoomEventsClient, error)
+	SendNotification(ctx context.Context, in *SendNotificationRequest, opts ...grpc.CallOption) (*SendNotificationResponse, error)
+}
+
+type gatewayServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGatewayServiceClient(cc grpc.ClientConnInterface) GatewayServiceClient {
+	return &gatewayServiceClient{cc}
+}
+
+func (c *gatewayServiceClient) SetCurrentItem(ctx context.Context, in *SetCurrentItemRequest, opts ...grpc.CallOption) (*SetCurrentItemResponse, error) {
+	out := new(SetCurrentItemResponse)
+	err := c.cc.Invoke(ctx, "/gateway.GatewayService/SetCurrentItem", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayServiceClient) GetRoomState(ctx context.Context, in *GetRoomStateRequest, opts ...grpc.CallOption) (*GetRoomStateResponse, error) {
+	out := new(GetRoomStateResponse)
+	err := c.cc.Invoke(ctx, "/gateway.GatewayService/GetRoomState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayServiceClient) EnqueueItem(ctx context.Context, in *EnqueueItemRequest, opts ...grpc.CallOption) (*EnqueueItemResponse, error) {
+	out := new(EnqueueItemResponse)
+	err := c.cc.Invoke(ctx, "/gateway.GatewayService/EnqueueItem", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayServiceClient) RemoveItem(ctx context.Context, in *RemoveItemRequest, opts ...grpc.CallOption) (*RemoveItemResponse, error) {
+	out := new(RemoveItemResponse)
+	err := c.cc.Invoke(ctx, "/gateway.GatewayService/RemoveItem", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayServiceClient) SetPlayerState(ctx context.Context, in *SetPlayerStateRequest, opts ...grpc.CallOption) (*SetPlayerStateResponse, error) {
+	out := new(SetPlayerStateResponse)
+	err := c.cc.Invoke(ctx, "/gateway.GatewayService/SetPlayerState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayServiceClient) KickUser(ctx context.Context, in *KickUserRequest, opts ...grpc.CallOption) (*KickUserResponse, error) {
+	out := new(KickUserResponse)
+	err := c.cc.Invoke(ctx, "/gateway.GatewayService/KickUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayServiceClient) UpdateRole(ctx context.Context, in *UpdateRoleRequest, opts ...grpc.CallOption) (*UpdateRoleResponse, error) {
+	out := new(UpdateRoleResponse)
+	err := c.cc.Invoke(ctx, "/gateway.GatewayService/UpdateRole", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayServiceClient) CloseRoom(ctx context.Context, in *CloseRoomRequest, opts ...grpc.CallOption) (*CloseRoomResponse, error) {
+	out := new(CloseRoomResponse)
+	err := c.cc.Invoke(ctx, "/gateway.GatewayService/CloseRoom", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayServiceClient) GetRoomEvents(ctx context.Context, in *GetRoomEventsRequest, opts ...grpc.CallOption) (*GetRoomEventsResponse, error) {
+	out := new(GetRoomEventsResponse)
+	err := c.cc.Invoke(ctx, "/gateway.GatewayService/GetRoomEvents", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayServiceClient) StreamRoomEvents(ctx context.Context, in *StreamRoomEventsRequest, opts ...grpc.CallOption) (GatewayService_StreamRoomEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &GatewayService_ServiceDesc.Streams[0], "/gateway.GatewayService/StreamRoomEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &gatewayServiceStreamRoomEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type GatewayService_StreamRoomEventsClient interface {
+	Recv() (*RoomEvent, error)
+	grpc.ClientStream
+}
+
+type gatewayServiceStreamRoomEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *gatewayServiceStreamRoomEventsClient) Recv() (*RoomEvent, error) {
+	m := new(RoomEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *gatewayServiceClient) SendNotification(ctx context.Context, in *SendNotificationRequest, opts ...grpc.CallOption) (*SendNotificationResponse, error) {
+	out := new(SendNotificationResponse)
+	err := c.cc.Invoke(ctx, "/gateway.GatewayService/SendNotification", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GatewayServiceServer is the server API for GatewayService service.
+// All implementations must embed UnimplementedGatewayServiceServer
+// for forward compatibility
+type GatewayServiceServer interface {
+	SetCurrentItem(context.Context, *SetCurrentItemRequest) (*SetCurrentItemResponse, error)
+	GetRoomState(context.Context, *GetRoomStateRequest) (*GetRoomStateResponse, error)
+	EnqueueItem(context.Context, *EnqueueItemRequest) (*EnqueueItemResponse, error)
+	RemoveItem(context.Context, *RemoveItemRequest) (*RemoveItemResponse, error)
+	SetPlayerState(context.Context, *SetPlayerStateRequest) (*SetPlayerStateResponse, error)
+	KickUser(context.Context, *KickUserRequest) (*KickUserResponse, error)
+	UpdateRole(context.Context, *UpdateRoleRequest) (*UpdateRoleResponse, error)
+	CloseRoom(context.Context, *CloseRoomRequest) (*CloseRoomResponse, error)
+	GetRoomEvents(context.Context, *GetRoomEventsRequest) (*GetRoomEventsResponse, error)
+	StreamRoomEvents(*StreamRoomEventsRequest, GatewayService_StreamRoomEventsServer) error
+	SendNotification(context.Context, *SendNotificationRequest) (*SendNotificationResponse, error)
+	mustEmbedUnimplementedGatewayServiceServer()
+}
+
+// UnimplementedGatewayServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedGatewayServiceServer struct {
+}
+
+func (UnimplementedGatewayServiceServer) SetCurrentItem(context.Context, *SetCurrentItemRequest) (*SetCurrentItemResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetCurrentItem not implemented")
+}
+func (UnimplementedGatewayServiceServer) GetRoomState(context.Context, *GetRoomStateRequest) (*GetRoomStateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRoomState not implemented")
+}
+func (UnimplementedGatewayServiceServer) EnqueueItem(context.Context, *EnqueueItemRequest) (*EnqueueItemResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EnqueueItem not implemented")
+}
+func (UnimplementedGatewayServiceServer) RemoveItem(context.Context, *RemoveItemRequest) (*RemoveItemResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveItem not implemented")
+}
+func (UnimplementedGatewayServiceServer) SetPlayerState(context.Context, *SetPlayerStateRequest) (*SetPlayerStateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetPlayerState not implemented")
+}
+func (UnimplementedGatewayServiceServer) KickUser(context.Context, *KickUserRequest) (*KickUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KickUser not implemented")
+}
+func (UnimplementedGatewayServiceServer) UpdateRole(context.Context, *UpdateRoleRequest) (*UpdateRoleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateRole not implemented")
+}
+func (UnimplementedGatewayServiceServer) CloseRoom(context.Context, *CloseRoomRequest) (*CloseRoomResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CloseRoom not implemented")
+}
+func (UnimplementedGatewayServiceServer) GetRoomEvents(context.Context, *GetRoomEventsRequest) (*GetRoomEventsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRoomEvents not implemented")
+}
+func (UnimplementedGatewayServiceServer) StreamRoomEvents(*StreamRoomEventsRequest, GatewayService_StreamRoomEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamRoomEvents not implemented")
+}
+func (UnimplementedGatewayServiceServer) SendNotification(context.Context, *SendNotificationRequest) (*SendNotificationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendNotification not implemented")
+}
+func (UnimplementedGatewayServiceServer) mustEmbedUnimplementedGatewayServiceServer() {}
+
+// UnsafeGatewayServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GatewayServiceServer will
+// result in compilation errors.
+type UnsafeGatewayServiceServer interface {
+	mustEmbedUnimplementedGatewayServiceServer()
+}
+
+func RegisterGatewayServiceServer(s grpc.ServiceRegistrar, srv GatewayServiceServer) {
+	s.RegisterService(&GatewayService_ServiceDesc, srv)
+}
+
+func _GatewayService_SetCurrentItem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetCurrentItemRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServiceServer).SetCurrentItem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gateway.GatewayService/SetCurrentItem",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServiceServer).SetCurrentItem(ctx, req.(*SetCurrentItemRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GatewayService_GetRoomState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRoomStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServiceServer).GetRoomState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gateway.GatewayService/GetRoomState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServiceServer).GetRoomState(ctx, req.(*GetRoomStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GatewayService_EnqueueItem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnqueueItemRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServiceServer).EnqueueItem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gateway.GatewayService/EnqueueItem",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServiceServer).EnqueueItem(ctx, req.(*EnqueueItemRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GatewayService_RemoveItem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveItemRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServiceServer).RemoveItem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gateway.GatewayService/RemoveItem",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServiceServer).RemoveItem(ctx, req.(*RemoveItemRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GatewayService_SetPlayerState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetPlayerStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServiceServer).SetPlayerState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gateway.GatewayService/SetPlayerState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServiceServer).SetPlayerState(ctx, req.(*SetPlayerStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GatewayService_KickUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KickUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServiceServer).KickUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gateway.GatewayService/KickUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServiceServer).KickUser(ctx, req.(*KickUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GatewayService_UpdateRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateRoleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServiceServer).UpdateRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gateway.GatewayService/UpdateRole",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServiceServer).UpdateRole(ctx, req.(*UpdateRoleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GatewayService_CloseRoom_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CloseRoomRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServiceServer).CloseRoom(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gateway.GatewayService/CloseRoom",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServiceServer).CloseRoom(ctx, req.(*CloseRoomRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GatewayService_GetRoomEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRoomEventsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServiceServer).GetRoomEvents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gateway.GatewayService/GetRoomEvents",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServiceServer).GetRoomEvents(ctx, req.(*GetRoomEventsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GatewayService_StreamRoomEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamRoomEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GatewayServiceServer).StreamRoomEvents(m, &gatewayServiceStreamRoomEventsServer{stream})
+}
+
+type GatewayService_StreamRoomEventsServer interface {
+	Send(*RoomEvent) error
+	grpc.ServerStream
+}
+
+type gatewayServiceStreamRoomEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *gatewayServiceStreamRoomEventsServer) Send(m *RoomEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _GatewayService_SendNotification_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendNotificationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServiceServer).SendNotification(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gateway.GatewayService/SendNotification",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServiceServer).SendNotification(ctx, req.(*SendNotificationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// GatewayService_ServiceDesc is the grpc.ServiceDesc for GatewayService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var GatewayService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gateway.GatewayService",
+	HandlerType: (*GatewayServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SetCurrentItem",
+			Handler:    _GatewayService_SetCurrentItem_Handler,
+		},
+		{
+			MethodName: "GetRoomState",
+			Handler:    _GatewayService_GetRoomState_Handler,
+		},
+		{
+			MethodName: "EnqueueItem",
+			Handler:    _GatewayService_EnqueueItem_Handler,
+		},
+		{
+			MethodName: "RemoveItem",
+			Handler:    _GatewayService_RemoveItem_Handler,
+		},
+		{
+			MethodName: "SetPlayerState",
+			Handler:    _GatewayService_SetPlayerState_Handler,
+		},
+		{
+			MethodName: "KickUser",
+			Handler:    _GatewayService_KickUser_Handler,
+		},
+		{
+			MethodName: "UpdateRole",
+			Handler:    _GatewayService_UpdateRole_Handler,
+		},
+		{
+			MethodName: "CloseRoom",
+			Handler:    _GatewayService_CloseRoom_Handler,
+		},
+		{
+			MethodName: "GetRoomEvents",
+			Handler:    _GatewayService_GetRoomEvents_Handler,
+		},
+		{
+			MethodName: "SendNotification",
+			Handler:    _GatewayService_SendNotification_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamRoomEvents",
+			Handler:       _GatewayService_StreamRoomEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "gateway.proto",
+}
@@ -1,11 +1,28 @@
 package util
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/sakuraapp/shared/pkg/resource"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"io"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+const userAgent = "Googlebot/2.1 (+http://www.google.com/bot.html)"
+
 var iconSelectors = map[string]bool{
 	"apple-touch-icon-precomposed": true,
 	"apple-touch-icon": true,
@@ -13,105 +30,791 @@ var iconSelectors = map[string]bool{
 	"icon": true,
 }
 
+var oEmbedTypes = map[string]bool{
+	"application/json+oembed": true,
+	"text/json+oembed": true,
+}
+
+var htmlContentTypes = map[string]bool{
+	"text/html": true,
+	"application/xhtml+xml": true,
+}
+
+var jsonContentTypes = map[string]bool{
+	"application/json": true,
+	"text/json": true,
+	"text/javascript": true,
+}
+
+// ranges that aren't covered by the net.IP helpers but still must never be reached from the outside
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+)
+
+var isoDurationRegex = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+var (
+	ErrInvalidDuration        = errors.New("invalid ISO 8601 duration")
+	ErrUnsupportedScheme      = errors.New("crawler: unsupported url scheme")
+	ErrForbiddenDomain        = errors.New("crawler: domain is not allowed")
+	ErrForbiddenAddress       = errors.New("crawler: address is not allowed")
+	ErrTooManyRedirects       = errors.New("crawler: too many redirects")
+	ErrUnsupportedContentType = errors.New("crawler: unsupported content type")
+	ErrBadStatus              = errors.New("crawler: unexpected status code")
+)
+
+type CrawlerOptions struct {
+	ConnectTimeout time.Duration `config:"connect_timeout"` // time allowed to establish a tcp connection
+	Timeout        time.Duration `config:"timeout"`         // time allowed for the whole request, including redirects & reading the body
+	MaxBodySize    int64         `config:"max_body_size"`   // bytes read from the response at most, anything after is ignored
+	MaxRedirects   int           `config:"max_redirects"`   // redirects followed at most, 0 doesn't follow any (NewCrawler only applies the default when it's negative)
+	AllowedDomains []string      `config:"allowed_domains,reload"` // if set, only these domains (and their subdomains) can be crawled
+	DeniedDomains  []string      `config:"denied_domains,reload"`  // these domains (and their subdomains) can never be crawled
+}
+
+func DefaultCrawlerOptions() CrawlerOptions {
+	return CrawlerOptions{
+		ConnectTimeout: 3 * time.Second,
+		Timeout:        10 * time.Second,
+		MaxBodySize:    2 << 20,
+		MaxRedirects:   5,
+	}
+}
+
+// MediaInfo is the metadata the crawler managed to extract from a page.
+// The embedded MediaItemInfo holds the fields that are stored with queue items, Icon being the thumbnail
+type MediaInfo struct {
+	*resource.MediaItemInfo
+	Duration     time.Duration
+	Author       string
+	CanonicalUrl string
+}
+
+// pageMeta collects every candidate value found on a page, the most specific source wins when they're merged
+type pageMeta struct {
+	base *url.URL
+
+	docTitle     string
+	metaTitle    string
+	ogTitle      string
+	twitterTitle string
+
+	ogImage       string
+	twitterImage  string
+	itempropImage string
+	linkIcon      string
+
+	ogDuration       string
+	itempropDuration string
+
+	metaAuthor string
+	canonical  string
+	ogUrl      string
+	oEmbedUrl  string
+
+	ld *videoObject
+}
+
+type videoObject struct {
+	Name      string
+	Thumbnail string
+	Duration  time.Duration
+	Author    string
+	Url       string
+}
+
+type oEmbedResponse struct {
+	Title        string      `json:"title"`
+	AuthorName   string      `json:"author_name"`
+	ThumbnailUrl string      `json:"thumbnail_url"`
+	Duration     json.Number `json:"duration"`
+}
+
+// crawlerDomains are the domain lists, they're swapped as a whole when they change so a check never sees half of an update
+type crawlerDomains struct {
+	allowed []string
+	denied  []string
+}
+
 type Crawler struct {
+	opts      CrawlerOptions
+	domains   atomic.Value // *crawlerDomains
+	allowIP   func(ip net.IP) bool // addresses that can be connected to, IsPublicIP outside of tests
 	transport http.RoundTripper
+	client    *http.Client
+	hooks     []CrawlerHook
+}
+
+// CrawlerHook is notified of every crawl (e.g. to trace or time them), the context returned by BeforeCrawl is the one the crawl & AfterCrawl get
+type CrawlerHook interface {
+	BeforeCrawl(ctx context.Context, rawUrl string) context.Context
+	AfterCrawl(ctx context.Context, rawUrl string, err error)
 }
 
-func NewCrawler() *Crawler {
-	return &Crawler{
-		transport: http.DefaultTransport,
+// NewCrawler fills the unset options with their defaults, except for MaxRedirects: since 0 disables redirects, only a negative value is replaced
+func NewCrawler(opts CrawlerOptions) *Crawler {
+	defaults := DefaultCrawlerOptions()
+
+	if opts.ConnectTimeout <= 0 {
+		opts.ConnectTimeout = defaults.ConnectTimeout
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaults.Timeout
 	}
+
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = defaults.MaxBodySize
+	}
+
+	if opts.MaxRedirects < 0 {
+		opts.MaxRedirects = defaults.MaxRedirects
+	}
+
+	c := &Crawler{opts: opts, allowIP: IsPublicIP}
+	c.SetDomains(opts.AllowedDomains, opts.DeniedDomains)
+
+	dialer := &net.Dialer{
+		Timeout: opts.ConnectTimeout,
+		Control: c.checkAddress,
+	}
+
+	// proxies are disabled on purpose, otherwise the address check would only ever see the proxy
+	c.transport = &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   opts.ConnectTimeout,
+		ResponseHeaderTimeout: opts.Timeout,
+	}
+
+	c.client = &http.Client{
+		Transport:     c.transport,
+		Timeout:       opts.Timeout,
+		CheckRedirect: c.checkRedirect,
+	}
+
+	return c
+}
+
+// SetDomains replaces the allowed & denied domains, requests that are already running aren't affected
+func (c *Crawler) SetDomains(allowed []string, denied []string) {
+	c.domains.Store(&crawlerDomains{
+		allowed: normalizeDomains(allowed),
+		denied:  normalizeDomains(denied),
+	})
 }
 
-func (c *Crawler) Get(url string) (*resource.MediaItemInfo, error) {
-	client := &http.Client{Transport: c.transport}
-	req, err := http.NewRequest("GET", url, nil)
+// checkAddress runs after DNS resolution, right before connecting, so it also catches hostnames pointing at internal addresses
+func (c *Crawler) checkAddress(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil || !c.allowIP(ip) {
+		return fmt.Errorf("%w: %v", ErrForbiddenAddress, host)
+	}
+
+	return nil
+}
+
+func (c *Crawler) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > c.opts.MaxRedirects {
+		return ErrTooManyRedirects
+	}
+
+	return c.checkUrl(req.URL)
+}
+
+func (c *Crawler) checkUrl(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ErrUnsupportedScheme
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	domains := c.domains.Load().(*crawlerDomains)
+
+	if matchDomain(host, domains.denied) {
+		return ErrForbiddenDomain
+	}
+
+	if len(domains.allowed) > 0 && !matchDomain(host, domains.allowed) {
+		return ErrForbiddenDomain
+	}
+
+	return nil
+}
+
+func (c *Crawler) fetch(ctx context.Context, rawUrl string, contentTypes map[string]bool) (*http.Response, error) {
+	u, err := url.Parse(rawUrl)
 
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("User-agent", "Googlebot/2.1 (+http://www.google.com/bot.html)")
+	err = c.checkUrl(u)
 
-	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	req.Header.Add("User-agent", userAgent)
+
+	resp, err := c.client.Do(req)
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		cancel()
+
+		return nil, fmt.Errorf("%w: %v", ErrBadStatus, resp.StatusCode)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	if err != nil || !contentTypes[mediaType] {
+		resp.Body.Close()
+		cancel()
+
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedContentType, mediaType)
+	}
+
+	resp.Body = &limitedBody{
+		Reader: io.LimitReader(resp.Body, c.opts.MaxBodySize),
+		body:   resp.Body,
+		cancel: cancel,
+	}
+
+	return resp, nil
+}
+
+// AddHook adds a hook that's notified of every crawl, hooks must be added before the crawler is used
+func (c *Crawler) AddHook(hook CrawlerHook) {
+	c.hooks = append(c.hooks, hook)
+}
+
+func (c *Crawler) Get(ctx context.Context, rawUrl string) (*MediaInfo, error) {
+	for _, hook := range c.hooks {
+		ctx = hook.BeforeCrawl(ctx, rawUrl)
+	}
+
+	info, err := c.get(ctx, rawUrl)
+
+	for i := len(c.hooks) - 1; i >= 0; i-- {
+		c.hooks[i].AfterCrawl(ctx, rawUrl, err)
+	}
+
+	return info, err
+}
+
+// CrawlOutcome classifies the result of a crawl, for metrics & traces
+func CrawlOutcome(err error) string {
+	var netErr net.Error
+
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrUnsupportedScheme), errors.Is(err, ErrForbiddenDomain), errors.Is(err, ErrForbiddenAddress):
+		return "forbidden"
+	case errors.Is(err, ErrTooManyRedirects):
+		return "too_many_redirects"
+	case errors.Is(err, ErrUnsupportedContentType):
+		return "unsupported_content_type"
+	case errors.Is(err, ErrBadStatus):
+		return "bad_status"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "error"
+	}
+}
+
+func (c *Crawler) get(ctx context.Context, rawUrl string) (*MediaInfo, error) {
+	resp, err := c.fetch(ctx, rawUrl, htmlContentTypes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
 
 	if err != nil {
 		return nil, err
 	}
 
-	info := &resource.MediaItemInfo{Url: url}
-	z := html.NewTokenizer(resp.Body)
+	// relative urls are resolved against the final url, i.e. after redirects
+	meta := &pageMeta{base: resp.Request.URL}
+	err = meta.parse(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := meta.build(rawUrl)
+
+	if meta.oEmbedUrl != "" && (info.Title == "" || info.Icon == "" || info.Author == "" || info.Duration == 0) {
+		oEmbed, err := c.getOEmbed(ctx, meta.oEmbedUrl)
+
+		if err == nil {
+			oEmbed.merge(info, meta.base)
+		}
+	}
+
+	return info, nil
+}
+
+func (c *Crawler) getOEmbed(ctx context.Context, rawUrl string) (*oEmbedResponse, error) {
+	resp, err := c.fetch(ctx, rawUrl, jsonContentTypes)
 
-	titleFound := false
-	title := false
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var res oEmbedResponse
+
+	err = json.NewDecoder(resp.Body).Decode(&res)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func (r *oEmbedResponse) merge(info *MediaInfo, base *url.URL) {
+	if info.Title == "" {
+		info.Title = strings.TrimSpace(r.Title)
+	}
+
+	if info.Icon == "" {
+		info.Icon = resolveUrl(base, r.ThumbnailUrl)
+	}
+
+	if info.Author == "" {
+		info.Author = strings.TrimSpace(r.AuthorName)
+	}
 
-	iconFound := false
-	icon := false
+	if info.Duration == 0 && r.Duration != "" {
+		seconds, err := r.Duration.Float64()
+
+		if err == nil {
+			info.Duration = time.Duration(seconds * float64(time.Second))
+		}
+	}
+}
+
+func (m *pageMeta) parse(r io.Reader) error {
+	z := html.NewTokenizer(r)
+
+	inTitle := false
+	inLD := false
 
-	loop:
 	for {
 		tt := z.Next()
 
 		switch tt {
 		case html.ErrorToken:
-			err = z.Err()
-			break loop
-		case html.StartTagToken:
-			t := z.Token()
+			err := z.Err()
 
-			if t.Data == "title" {
-				titleFound = true
+			if err == io.EOF {
+				return nil
 			}
 
-			if t.Data == "meta" {
-				for _, attr := range t.Attr {
-					if attr.Key == "title" {
-						info.Title = attr.Val
-						title = true
-					}
-
-					if attr.Key == "itemprop" && attr.Val == "image" {
-						iconFound = true
-					}
+			return err
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			attrs := attrMap(t.Attr)
 
-					if attr.Key == "content" && iconFound {
-						info.Icon = attr.Val
-						icon = true
-						iconFound = false
-						break
+			switch t.Data {
+			case "title":
+				inTitle = m.docTitle == ""
+			case "base":
+				if href := attrs["href"]; href != "" {
+					if u, err := m.base.Parse(href); err == nil {
+						m.base = u
 					}
 				}
+			case "meta":
+				m.parseMeta(attrs)
+			case "link":
+				m.parseLink(attrs)
+			case "script":
+				inLD = strings.EqualFold(attrs["type"], "application/ld+json")
+			}
+		case html.EndTagToken:
+			inTitle = false
+			inLD = false
+		case html.TextToken:
+			if inTitle {
+				m.docTitle = strings.TrimSpace(string(z.Text()))
+				inTitle = false
+			} else if inLD && m.ld == nil {
+				m.ld = parseLinkedData(z.Text())
+				inLD = false
 			}
+		}
+	}
+}
 
-			if t.Data == "link" {
-				for _, attr := range t.Attr {
-					if attr.Key == "rel" && iconSelectors[attr.Val] {
-						iconFound = true
-					}
+func (m *pageMeta) parseMeta(attrs map[string]string) {
+	content := strings.TrimSpace(attrs["content"])
 
-					if attr.Key == "href" && iconFound {
-						info.Icon = attr.Val
-						icon = true
-						iconFound = false
-						break
-					}
-				}
+	if content == "" {
+		return
+	}
+
+	// opengraph uses "property" while twitter cards use "name", but plenty of sites mix them up
+	key := attrs["property"]
+
+	if key == "" {
+		key = attrs["name"]
+	}
+
+	switch strings.ToLower(key) {
+	case "title":
+		setOnce(&m.metaTitle, content)
+	case "og:title":
+		setOnce(&m.ogTitle, content)
+	case "twitter:title":
+		setOnce(&m.twitterTitle, content)
+	case "og:image", "og:image:url", "og:image:secure_url":
+		setOnce(&m.ogImage, content)
+	case "twitter:image", "twitter:image:src":
+		setOnce(&m.twitterImage, content)
+	case "og:url":
+		setOnce(&m.ogUrl, content)
+	case "og:video:duration", "video:duration":
+		setOnce(&m.ogDuration, content)
+	case "author":
+		setOnce(&m.metaAuthor, content)
+	}
+
+	switch attrs["itemprop"] {
+	case "image", "thumbnailUrl":
+		setOnce(&m.itempropImage, content)
+	case "duration":
+		setOnce(&m.itempropDuration, content)
+	}
+}
+
+func (m *pageMeta) parseLink(attrs map[string]string) {
+	href := strings.TrimSpace(attrs["href"])
+
+	if href == "" {
+		return
+	}
+
+	rel := strings.ToLower(attrs["rel"])
+
+	switch {
+	case iconSelectors[rel]:
+		setOnce(&m.linkIcon, href)
+	case rel == "canonical":
+		setOnce(&m.canonical, href)
+	case rel == "alternate" && oEmbedTypes[strings.ToLower(attrs["type"])]:
+		setOnce(&m.oEmbedUrl, m.resolve(href))
+	case attrs["itemprop"] == "thumbnailUrl":
+		setOnce(&m.itempropImage, href)
+	}
+}
+
+func (m *pageMeta) resolve(ref string) string {
+	return resolveUrl(m.base, ref)
+}
+
+func (m *pageMeta) build(rawUrl string) *MediaInfo {
+	ld := m.ld
+
+	if ld == nil {
+		ld = &videoObject{}
+	}
+
+	info := &MediaInfo{
+		MediaItemInfo: &resource.MediaItemInfo{
+			Title: firstNonEmpty(m.ogTitle, m.twitterTitle, ld.Name, m.metaTitle, m.docTitle),
+			Icon:  m.resolve(firstNonEmpty(m.ogImage, m.twitterImage, ld.Thumbnail, m.itempropImage, m.linkIcon)),
+			Url:   rawUrl,
+		},
+		Duration:     ld.Duration,
+		Author:       firstNonEmpty(ld.Author, m.metaAuthor),
+		CanonicalUrl: m.resolve(firstNonEmpty(m.canonical, m.ogUrl, ld.Url, m.base.String())),
+	}
+
+	if info.Duration == 0 && m.itempropDuration != "" {
+		info.Duration, _ = ParseISODuration(m.itempropDuration)
+	}
+
+	if info.Duration == 0 && m.ogDuration != "" {
+		seconds, err := strconv.ParseFloat(m.ogDuration, 64)
+
+		if err == nil {
+			info.Duration = time.Duration(seconds * float64(time.Second))
+		}
+	}
+
+	return info
+}
+
+func parseLinkedData(b []byte) *videoObject {
+	var data interface{}
+
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil
+	}
+
+	obj := findVideoObject(data)
+
+	if obj == nil {
+		return nil
+	}
+
+	video := &videoObject{
+		Name:      ldString(obj["name"]),
+		Thumbnail: ldString(obj["thumbnailUrl"]),
+		Author:    ldString(obj["author"]),
+		Url:       ldString(obj["url"]),
+	}
+
+	if video.Thumbnail == "" {
+		video.Thumbnail = ldString(obj["thumbnail"])
+	}
+
+	if video.Author == "" {
+		video.Author = ldString(obj["creator"])
+	}
+
+	if duration, ok := obj["duration"].(string); ok {
+		video.Duration, _ = ParseISODuration(duration)
+	}
+
+	return video
+}
+
+// findVideoObject walks a JSON-LD document (which may be a single node, an array or an @graph) looking for a VideoObject
+func findVideoObject(data interface{}) map[string]interface{} {
+	switch v := data.(type) {
+	case []interface{}:
+		for _, node := range v {
+			if obj := findVideoObject(node); obj != nil {
+				return obj
 			}
+		}
+	case map[string]interface{}:
+		if isVideoObject(v["@type"]) {
+			return v
+		}
 
-			iconFound = false
+		if graph, ok := v["@graph"]; ok {
+			return findVideoObject(graph)
+		}
+	}
+
+	return nil
+}
 
-			if title && icon {
-				return info, nil
+func isVideoObject(t interface{}) bool {
+	switch v := t.(type) {
+	case string:
+		return v == "VideoObject"
+	case []interface{}:
+		for _, s := range v {
+			if s == "VideoObject" {
+				return true
 			}
-		case html.TextToken:
-			if titleFound && !title {
-				info.Title = z.Token().Data
+		}
+	}
 
-				titleFound = false
-				title = true
+	return false
+}
+
+// ldString flattens the different shapes a JSON-LD value can take (text, object with a name/url, list) into a string
+func ldString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return strings.TrimSpace(val)
+	case []interface{}:
+		for _, item := range val {
+			if s := ldString(item); s != "" {
+				return s
 			}
 		}
+	case map[string]interface{}:
+		if name := ldString(val["name"]); name != "" {
+			return name
+		}
+
+		return ldString(val["url"])
 	}
 
-	return info, err
+	return ""
+}
+
+// ParseISODuration parses ISO 8601 durations such as PT4M13S, which is what schema.org uses
+func ParseISODuration(s string) (time.Duration, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	matches := isoDurationRegex.FindStringSubmatch(s)
+
+	if matches == nil || s == "P" || s == "PT" {
+		return 0, ErrInvalidDuration
+	}
+
+	units := []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second}
+
+	var d time.Duration
+
+	for i, unit := range units {
+		if matches[i+1] == "" {
+			continue
+		}
+
+		n, err := strconv.ParseFloat(matches[i+1], 64)
+
+		if err != nil {
+			return 0, ErrInvalidDuration
+		}
+
+		d += time.Duration(n * float64(unit))
+	}
+
+	return d, nil
+}
+
+// limitedBody caps how much of a response is read and releases the request's context once it's closed
+type limitedBody struct {
+	io.Reader
+	body   io.Closer
+	cancel context.CancelFunc
+}
+
+func (b *limitedBody) Close() error {
+	defer b.cancel()
+
+	return b.body.Close()
+}
+
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() {
+		return false
+	}
+
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchDomain reports whether host is one of the domains or a subdomain of one of them
+func matchDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func normalizeDomains(domains []string) []string {
+	res := make([]string, 0, len(domains))
+
+	for _, domain := range domains {
+		domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+
+		if domain != "" {
+			res = append(res, domain)
+		}
+	}
+
+	return res
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+
+		if err != nil {
+			panic(err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+func resolveUrl(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+
+	u, err := base.Parse(ref)
+
+	if err != nil {
+		return ref
+	}
+
+	return u.String()
+}
+
+func attrMap(attrs []html.Attribute) map[string]string {
+	m := make(map[string]string, len(attrs))
+
+	for _, attr := range attrs {
+		m[strings.ToLower(attr.Key)] = attr.Val
+	}
+
+	return m
+}
+
+func setOnce(dst *string, val string) {
+	if *dst == "" {
+		*dst = val
+	}
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, val := range vals {
+		if val != "" {
+			return val
+		}
+	}
+
+	return ""
 }
@@ -0,0 +1,586 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPage = `<html><head><title>Test video</title></head><body></body></html>`
+
+var testServerIP = net.ParseIP("127.0.0.1")
+
+// newTestCrawler returns a crawler that can reach the test servers, which listen on 127.0.0.1, every other address goes through the usual check
+func newTestCrawler(opts CrawlerOptions) *Crawler {
+	c := NewCrawler(opts)
+	c.allowIP = func(ip net.IP) bool {
+		return ip.Equal(testServerIP) || IsPublicIP(ip)
+	}
+
+	return c
+}
+
+// newLoopbackServer starts a test server on another loopback address, that the test crawlers still can't reach
+func newLoopbackServer(t *testing.T, handler http.Handler) *httptest.Server {
+	l, err := net.Listen("tcp", "127.0.0.2:0")
+
+	if err != nil {
+		t.Skipf("can't listen on 127.0.0.2: %v", err)
+	}
+
+	srv := &httptest.Server{
+		Listener: l,
+		Config:   &http.Server{Handler: handler},
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func servePage(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = io.WriteString(w, testPage)
+}
+
+func TestCheckAddress(t *testing.T) {
+	c := NewCrawler(CrawlerOptions{})
+
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:80", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:80", false},
+		{"127.0.0.2:8080", false},
+		{"[::1]:80", false},
+		{"10.0.0.1:80", false},
+		{"172.16.0.1:80", false},
+		{"192.168.1.1:80", false},
+		{"[fc00::1]:80", false},
+		{"169.254.169.254:80", false},
+		{"[fe80::1]:80", false},
+		{"0.0.0.0:80", false},
+		{"100.64.0.1:80", false},
+		{"198.18.0.1:80", false},
+		{"224.0.0.1:80", false},
+		{"[::ffff:127.0.0.1]:80", false},
+		{"[64:ff9b::a00:1]:80", false},
+	}
+
+	for _, test := range tests {
+		err := c.checkAddress("tcp", test.address, nil)
+
+		if test.allowed && err != nil {
+			t.Errorf("%v: unexpected error: %v", test.address, err)
+		}
+
+		if !test.allowed && !errors.Is(err, ErrForbiddenAddress) {
+			t.Errorf("%v: expected ErrForbiddenAddress, got %v", test.address, err)
+		}
+	}
+}
+
+func TestCrawlerRejectsLoopback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(servePage))
+	defer srv.Close()
+
+	c := NewCrawler(CrawlerOptions{})
+
+	_, err := c.Get(context.Background(), srv.URL)
+
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("expected ErrForbiddenAddress, got %v", err)
+	}
+
+	// a hostname is checked once it's resolved
+	u, _ := url.Parse(srv.URL)
+	_, err = c.Get(context.Background(), "http://localhost:"+u.Port())
+
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("expected ErrForbiddenAddress for localhost, got %v", err)
+	}
+}
+
+func TestCrawlerRejectsRedirectToLoopback(t *testing.T) {
+	var hit bool
+
+	internal := newLoopbackServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+		servePage(w, r)
+	}))
+
+	srv := httptest.NewServer(http.RedirectHandler(internal.URL+"/admin", http.StatusFound))
+	defer srv.Close()
+
+	c := newTestCrawler(DefaultCrawlerOptions())
+
+	_, err := c.Get(context.Background(), srv.URL)
+
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("expected ErrForbiddenAddress, got %v", err)
+	}
+
+	if hit {
+		t.Fatal("the internal server was reached")
+	}
+}
+
+func TestCrawlerGet(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(servePage))
+	defer srv.Close()
+
+	c := newTestCrawler(CrawlerOptions{})
+
+	info, err := c.Get(context.Background(), srv.URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Title != "Test video" {
+		t.Fatalf("expected the page's title, got %q", info.Title)
+	}
+}
+
+func TestCrawlerRedirectLimit(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", servePage)
+	mux.HandleFunc("/redirect/", func(w http.ResponseWriter, r *http.Request) {
+		var n int
+
+		_, _ = fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/redirect/"), "%d", &n)
+
+		if n <= 1 {
+			http.Redirect(w, r, "/", http.StatusFound)
+		} else {
+			http.Redirect(w, r, fmt.Sprintf("/redirect/%d", n-1), http.StatusFound)
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		maxRedirects int
+		redirects    int
+		err          error
+	}{
+		{0, 0, nil},
+		{0, 1, ErrTooManyRedirects},
+		{2, 2, nil},
+		{2, 3, ErrTooManyRedirects},
+		{-1, 5, nil}, // default
+		{-1, 6, ErrTooManyRedirects},
+	}
+
+	for _, test := range tests {
+		c := newTestCrawler(CrawlerOptions{MaxRedirects: test.maxRedirects})
+		rawUrl := srv.URL
+
+		if test.redirects > 0 {
+			rawUrl = fmt.Sprintf("%v/redirect/%d", srv.URL, test.redirects)
+		}
+
+		_, err := c.Get(context.Background(), rawUrl)
+
+		if test.err == nil && err != nil {
+			t.Errorf("max %d, %d redirects: unexpected error: %v", test.maxRedirects, test.redirects, err)
+		}
+
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("max %d, %d redirects: expected %v, got %v", test.maxRedirects, test.redirects, test.err, err)
+		}
+	}
+}
+
+func TestCrawlerMaxBodySize(t *testing.T) {
+	const maxBodySize = 1024
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html><head>"+strings.Repeat(" ", 4*maxBodySize)+"<title>Too far</title></head></html>")
+	}))
+	defer srv.Close()
+
+	c := newTestCrawler(CrawlerOptions{MaxBodySize: maxBodySize})
+
+	resp, err := c.fetch(context.Background(), srv.URL, htmlContentTypes)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body) != maxBodySize {
+		t.Fatalf("expected %d bytes, read %d", maxBodySize, len(body))
+	}
+
+	info, err := c.Get(context.Background(), srv.URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Title != "" {
+		t.Fatalf("expected the title past the limit to be ignored, got %q", info.Title)
+	}
+}
+
+func TestCrawlerContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		err         error
+	}{
+		{"text/html; charset=utf-8", nil},
+		{"application/xhtml+xml", nil},
+		{"image/png", ErrUnsupportedContentType},
+		{"application/octet-stream", ErrUnsupportedContentType},
+		{"", ErrUnsupportedContentType},
+	}
+
+	for _, test := range tests {
+		contentType := test.contentType
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header()["Content-Type"] = []string{contentType}
+			_, _ = io.WriteString(w, testPage)
+		}))
+
+		c := newTestCrawler(CrawlerOptions{})
+
+		_, err := c.Get(context.Background(), srv.URL)
+		srv.Close()
+
+		if test.err == nil && err != nil {
+			t.Errorf("%q: unexpected error: %v", contentType, err)
+		}
+
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%q: expected %v, got %v", contentType, test.err, err)
+		}
+	}
+}
+
+func TestCrawlerDomains(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		denied  []string
+		url     string
+		err     error
+	}{
+		{nil, nil, "https://example.com/watch", nil},
+		{nil, nil, "ftp://example.com/video.mp4", ErrUnsupportedScheme},
+		{nil, nil, "file:///etc/passwd", ErrUnsupportedScheme},
+		{[]string{"example.com"}, nil, "https://example.com/", nil},
+		{[]string{"example.com"}, nil, "https://videos.example.com/", nil},
+		{[]string{"Example.com."}, nil, "https://EXAMPLE.COM./", nil},
+		{[]string{"example.com"}, nil, "https://notexample.com/", ErrForbiddenDomain},
+		{[]string{"example.com"}, nil, "https://example.org/", ErrForbiddenDomain},
+		{nil, []string{"example.com"}, "https://example.com/", ErrForbiddenDomain},
+		{nil, []string{"example.com"}, "https://cdn.example.com/", ErrForbiddenDomain},
+		{nil, []string{"example.com"}, "https://example.org/", nil},
+		{[]string{"example.com"}, []string{"private.example.com"}, "https://private.example.com/", ErrForbiddenDomain},
+		{[]string{"example.com"}, []string{"private.example.com"}, "https://public.example.com/", nil},
+	}
+
+	for _, test := range tests {
+		c := NewCrawler(CrawlerOptions{AllowedDomains: test.allowed, DeniedDomains: test.denied})
+		u, err := url.Parse(test.url)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = c.checkUrl(u)
+
+		if err != test.err {
+			t.Errorf("%v (allowed %v, denied %v): expected %v, got %v", test.url, test.allowed, test.denied, test.err, err)
+		}
+	}
+}
+
+func TestCrawlerDomainsOnRedirect(t *testing.T) {
+	srv := httptest.NewServer(http.RedirectHandler("http://denied.test/", http.StatusFound))
+	defer srv.Close()
+
+	opts := DefaultCrawlerOptions()
+	opts.DeniedDomains = []string{"denied.test"}
+
+	c := newTestCrawler(opts)
+
+	_, err := c.Get(context.Background(), srv.URL)
+
+	if !errors.Is(err, ErrForbiddenDomain) {
+		t.Fatalf("expected ErrForbiddenDomain, got %v", err)
+	}
+
+	// the lists can be swapped at runtime
+	c.SetDomains([]string{"example.com"}, nil)
+
+	_, err = c.Get(context.Background(), srv.URL)
+
+	if !errors.Is(err, ErrForbiddenDomain) {
+		t.Fatalf("expected ErrForbiddenDomain once only example.com is allowed, got %v", err)
+	}
+}
+
+type testHook struct {
+	name  string
+	calls *[]string
+}
+
+type testHookKey struct{}
+
+func (h testHook) BeforeCrawl(ctx context.Context, rawUrl string) context.Context {
+	*h.calls = append(*h.calls, "before "+h.name)
+
+	return context.WithValue(ctx, testHookKey{}, h.name)
+}
+
+func (h testHook) AfterCrawl(ctx context.Context, rawUrl string, err error) {
+	*h.calls = append(*h.calls, fmt.Sprintf("after %v (%v, %v)", h.name, ctx.Value(testHookKey{}), CrawlOutcome(err)))
+}
+
+func TestCrawlerHooks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(servePage))
+	defer srv.Close()
+
+	var calls []string
+
+	c := newTestCrawler(CrawlerOptions{})
+	c.AddHook(testHook{"a", &calls})
+	c.AddHook(testHook{"b", &calls})
+
+	_, err := c.Get(context.Background(), srv.URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = c.Get(context.Background(), "ftp://example.com/")
+
+	expected := []string{
+		"before a", "before b", "after b (b, ok)", "after a (b, ok)",
+		"before a", "before b", "after b (b, forbidden)", "after a (b, forbidden)",
+	}
+
+	if strings.Join(calls, ", ") != strings.Join(expected, ", ") {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+}
+
+// metadataPages are html fixtures for each source the crawler reads, "{{url}}" is replaced with the test server's url
+var metadataPages = map[string]string{
+	"/opengraph": `<html><head>
+		<title>Document title</title>
+		<meta property="og:title" content="OpenGraph title">
+		<meta property="og:image" content="/images/og.jpg">
+		<meta property="og:url" content="{{url}}/videos/og">
+		<meta property="og:video:duration" content="253">
+		<meta name="author" content="Meta author">
+	</head></html>`,
+	"/twitter": `<html><head>
+		<title>Document title</title>
+		<meta name="twitter:title" content="Twitter title">
+		<meta name="twitter:image:src" content="images/twitter.jpg">
+	</head></html>`,
+	"/ld": `<html><head>
+		<title>Document title</title>
+		<script type="application/ld+json">{
+			"@context": "https://schema.org",
+			"@graph": [
+				{"@type": "WebPage", "name": "Page"},
+				{
+					"@type": ["VideoObject", "CreativeWork"],
+					"name": "Linked data title",
+					"thumbnailUrl": ["https://cdn.example.com/ld.jpg"],
+					"author": {"@type": "Person", "name": "Linked data author"},
+					"url": "{{url}}/videos/ld",
+					"duration": "PT1H2M3S"
+				}
+			]
+		}</script>
+	</head></html>`,
+	"/oembed": `<html><head>
+		<link rel="alternate" type="application/json+oembed" href="/oembed.json">
+	</head></html>`,
+	"/oembed-fallback": `<html><head>
+		<meta property="og:title" content="OpenGraph title">
+		<meta property="og:image" content="/images/og.jpg">
+		<link rel="alternate" type="text/json+oembed" href="/oembed.json">
+	</head></html>`,
+	"/itemprop": `<html><head>
+		<base href="{{url}}/media/">
+		<title>Document title</title>
+		<meta itemprop="duration" content="PT4M13.5S">
+		<link itemprop="thumbnailUrl" href="thumb.jpg">
+	</head></html>`,
+	"/precedence": `<html><head>
+		<title>Document title</title>
+		<meta name="title" content="Meta title">
+		<meta name="twitter:title" content="Twitter title">
+		<meta property="og:title" content="OpenGraph title">
+		<meta name="twitter:image" content="/images/twitter.jpg">
+		<meta property="og:image" content="/images/og.jpg">
+		<meta property="og:url" content="{{url}}/videos/og">
+		<meta property="og:video:duration" content="10">
+		<meta itemprop="duration" content="PT20S">
+		<meta name="author" content="Meta author">
+		<link rel="canonical" href="/videos/canonical">
+		<link rel="icon" href="/favicon.ico">
+		<link rel="alternate" type="application/json+oembed" href="/oembed.json">
+		<script type="application/ld+json">{
+			"@type": "VideoObject",
+			"name": "Linked data title",
+			"thumbnailUrl": "/images/ld.jpg",
+			"creator": "Linked data author",
+			"duration": "PT30S"
+		}</script>
+	</head></html>`,
+}
+
+const testOEmbed = `{
+	"type": "video",
+	"title": "oEmbed title",
+	"author_name": "oEmbed author",
+	"thumbnail_url": "/images/oembed.jpg",
+	"duration": 95.5
+}`
+
+func newMetadataServer(t *testing.T) *httptest.Server {
+	var srv *httptest.Server
+
+	mux := http.NewServeMux()
+
+	for path, page := range metadataPages {
+		page := page
+
+		mux.HandleFunc(path, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = io.WriteString(w, strings.ReplaceAll(page, "{{url}}", srv.URL))
+		})
+	}
+
+	mux.HandleFunc("/oembed.json", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, testOEmbed)
+	})
+
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestCrawlerMetadata(t *testing.T) {
+	srv := newMetadataServer(t)
+	c := newTestCrawler(CrawlerOptions{})
+
+	tests := []struct {
+		path      string
+		title     string
+		icon      string
+		author    string
+		duration  time.Duration
+		canonical string
+	}{
+		{"/opengraph", "OpenGraph title", "/images/og.jpg", "Meta author", 253 * time.Second, "/videos/og"},
+		{"/twitter", "Twitter title", "/images/twitter.jpg", "", 0, "/twitter"},
+		{"/ld", "Linked data title", "https://cdn.example.com/ld.jpg", "Linked data author", time.Hour + 2*time.Minute + 3*time.Second, "/videos/ld"},
+		{"/oembed", "oEmbed title", "/images/oembed.jpg", "oEmbed author", 95500 * time.Millisecond, "/oembed"},
+		{"/oembed-fallback", "OpenGraph title", "/images/og.jpg", "oEmbed author", 95500 * time.Millisecond, "/oembed-fallback"},
+		{"/itemprop", "Document title", "/media/thumb.jpg", "", 4*time.Minute + 13500*time.Millisecond, "/media/"},
+		// opengraph beats twitter cards, which beat json-ld, which beats the other meta tags and the title
+		// the duration & the author come from json-ld first though, and oembed is only used for what's still missing
+		{"/precedence", "OpenGraph title", "/images/og.jpg", "Linked data author", 30 * time.Second, "/videos/canonical"},
+	}
+
+	for _, test := range tests {
+		rawUrl := srv.URL + test.path
+		info, err := c.Get(context.Background(), rawUrl)
+
+		if err != nil {
+			t.Fatalf("%v: %v", test.path, err)
+		}
+
+		icon := test.icon
+
+		if strings.HasPrefix(icon, "/") {
+			icon = srv.URL + icon
+		}
+
+		if info.Url != rawUrl {
+			t.Errorf("%v: expected url %q, got %q", test.path, rawUrl, info.Url)
+		}
+
+		if info.Title != test.title {
+			t.Errorf("%v: expected title %q, got %q", test.path, test.title, info.Title)
+		}
+
+		if info.Icon != icon {
+			t.Errorf("%v: expected icon %q, got %q", test.path, icon, info.Icon)
+		}
+
+		if info.Author != test.author {
+			t.Errorf("%v: expected author %q, got %q", test.path, test.author, info.Author)
+		}
+
+		if info.Duration != test.duration {
+			t.Errorf("%v: expected duration %v, got %v", test.path, test.duration, info.Duration)
+		}
+
+		if info.CanonicalUrl != srv.URL+test.canonical {
+			t.Errorf("%v: expected canonical url %q, got %q", test.path, srv.URL+test.canonical, info.CanonicalUrl)
+		}
+	}
+}
+
+func TestParseISODuration(t *testing.T) {
+	tests := []struct {
+		value    string
+		duration time.Duration
+		valid    bool
+	}{
+		{"PT4M13S", 4*time.Minute + 13*time.Second, true},
+		{"PT1H", time.Hour, true},
+		{"P1DT2H", 26 * time.Hour, true},
+		{"P2D", 48 * time.Hour, true},
+		{"PT0.5S", 500 * time.Millisecond, true},
+		{" pt90s ", 90 * time.Second, true},
+		{"PT1H30M", 90 * time.Minute, true},
+		{"P", 0, false},
+		{"PT", 0, false},
+		{"4M13S", 0, false},
+		{"PT4S13M", 0, false},
+		{"P1Y", 0, false},
+		{"", 0, false},
+	}
+
+	for _, test := range tests {
+		d, err := ParseISODuration(test.value)
+
+		if test.valid && err != nil {
+			t.Errorf("%q: %v", test.value, err)
+		} else if !test.valid && !errors.Is(err, ErrInvalidDuration) {
+			t.Errorf("%q: expected ErrInvalidDuration, got %v", test.value, err)
+		} else if d != test.duration {
+			t.Errorf("%q: expected %v, got %v", test.value, test.duration, d)
+		}
+	}
+}
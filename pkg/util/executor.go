@@ -0,0 +1,94 @@
+package util
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// ShardedExecutor runs tasks on a fixed set of workers, tasks that share a key always run on the same worker, in the order they were submitted
+// tasks with different keys still run in parallel (unless their keys land on the same shard)
+type ShardedExecutor struct {
+	shards  []chan func()
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+	stopped bool
+}
+
+// NewShardedExecutor starts numShards workers (at least one), each with a queue of queueSize tasks
+func NewShardedExecutor(numShards int, queueSize int) *ShardedExecutor {
+	if numShards < 1 {
+		numShards = 1
+	}
+
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	e := &ShardedExecutor{
+		shards: make([]chan func(), numShards),
+	}
+
+	e.wg.Add(numShards)
+
+	for i := range e.shards {
+		ch := make(chan func(), queueSize)
+		e.shards[i] = ch
+
+		go func() {
+			defer e.wg.Done()
+
+			for fn := range ch {
+				fn()
+			}
+		}()
+	}
+
+	return e
+}
+
+// Go queues fn on the shard of key, it never blocks: if that shard's queue is full (or the executor was stopped), fn is dropped & false is returned
+func (e *ShardedExecutor) Go(key string, fn func()) bool {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.stopped {
+		return false
+	}
+
+	select {
+	case e.shards[h.Sum32()%uint32(len(e.shards))] <- fn:
+		return true
+	default:
+		return false
+	}
+}
+
+// Len returns the number of tasks waiting to run, across every shard
+func (e *ShardedExecutor) Len() int {
+	n := 0
+
+	for _, ch := range e.shards {
+		n += len(ch)
+	}
+
+	return n
+}
+
+// Stop waits for every queued task to finish, tasks queued afterwards are dropped
+func (e *ShardedExecutor) Stop() {
+	e.mu.Lock()
+
+	if !e.stopped {
+		e.stopped = true
+
+		for _, ch := range e.shards {
+			close(ch)
+		}
+	}
+
+	e.mu.Unlock()
+	e.wg.Wait()
+}
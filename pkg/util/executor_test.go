@@ -0,0 +1,66 @@
+package util
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestShardedExecutorOrder(t *testing.T) {
+	e := NewShardedExecutor(4, 1000)
+
+	var mu sync.Mutex
+	got := map[string][]int{}
+
+	for i := 0; i < 1000; i++ {
+		i := i
+		key := []string{"room.1", "room.2", "room.3"}[i%3]
+
+		if !e.Go(key, func() {
+			mu.Lock()
+			got[key] = append(got[key], i)
+			mu.Unlock()
+		}) {
+			t.Fatalf("task %d was dropped", i)
+		}
+	}
+
+	e.Stop()
+
+	for key, tasks := range got {
+		for j := 1; j < len(tasks); j++ {
+			if tasks[j] < tasks[j-1] {
+				t.Fatalf("%v: task %d ran after task %d", key, tasks[j-1], tasks[j])
+			}
+		}
+	}
+}
+
+func TestShardedExecutorFull(t *testing.T) {
+	e := NewShardedExecutor(0, 1) // clamped to a single shard
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+
+	e.Go("a", func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	if !e.Go("a", func() {}) {
+		t.Fatal("expected the task to fit in the queue")
+	}
+
+	if e.Go("b", func() {}) {
+		t.Fatal("expected the task to be dropped once the queue is full")
+	}
+
+	close(block)
+	e.Stop()
+
+	if e.Go("a", func() {}) {
+		t.Fatal("expected the task to be dropped once the executor is stopped")
+	}
+
+	e.Stop() // stopping twice is fine
+}
@@ -3,26 +3,126 @@ package util
 import (
 	"github.com/lesismal/nbio/nbhttp"
 	"github.com/lesismal/nbio/taskpool"
+	"github.com/sakuraapp/pubsub"
+	"golang.org/x/net/publicsuffix"
+	"net"
 	"net/url"
+	"reflect"
 	"runtime"
 	"strings"
 )
 
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// query parameters that are only used for tracking and never change the content of a page
+var trackingParams = map[string]bool{
+	"si":      true,
+	"feature": true,
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"yclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"ref_src": true,
+	"_hsenc":  true,
+	"_hsmi":   true,
+}
+
+// GetDomain returns the registrable domain of a url (effective TLD + 1), e.g. bbc.co.uk for www.bbc.co.uk
 func GetDomain(url *url.URL) string {
-	var domain string
+	host := strings.TrimSuffix(strings.ToLower(url.Hostname()), ".")
 
-	parts := strings.Split(url.Hostname(), ".")
-	partsLen := len(parts)
+	if net.ParseIP(host) != nil {
+		return host
+	}
 
-	if partsLen > 1 {
-		domain = strings.Join(parts[partsLen - 2:], ".")
-	} else {
-		domain = parts[0]
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+
+	if err != nil {
+		return host // the host is a public suffix itself or a single label like localhost
 	}
 
 	return domain
 }
 
+func isTrackingParam(key string) bool {
+	key = strings.ToLower(key)
+
+	return trackingParams[key] || strings.HasPrefix(key, "utm_")
+}
+
+// CanonicalizeURL returns a copy of the url without tracking parameters, default ports or fragments.
+// Parameters that change what's being pointed to (video ids, playlists, timestamps, etc.) are kept
+func CanonicalizeURL(u *url.URL) *url.URL {
+	res := *u
+
+	res.Scheme = strings.ToLower(res.Scheme)
+	res.Host = strings.ToLower(res.Host)
+
+	if port := res.Port(); port != "" && defaultPorts[res.Scheme] == port {
+		res.Host = res.Hostname()
+
+		if strings.Contains(res.Host, ":") {
+			res.Host = "[" + res.Host + "]" // ipv6
+		}
+	}
+
+	if res.Path == "" {
+		res.Path = "/"
+	}
+
+	query := res.Query()
+
+	for key := range query {
+		if isTrackingParam(key) {
+			query.Del(key)
+		}
+	}
+
+	res.RawQuery = query.Encode() // also sorts the parameters
+
+	// fragments are only kept when they're used as timestamps, e.g. #t=1m30s
+	if !strings.HasPrefix(res.Fragment, "t=") {
+		res.Fragment = ""
+	}
+
+	res.RawFragment = ""
+
+	return &res
+}
+
+// NormalizeURL returns a stable form of a url so that equivalent urls can share the same cache entries
+func NormalizeURL(rawUrl string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
+
+	if err != nil {
+		return "", err
+	}
+
+	return CanonicalizeURL(u).String(), nil
+}
+
+// FilterInt reads a numeric message filter.
+// msgpack decodes integers into the smallest type that fits them, so filters lose their original type once they've gone through pubsub
+func FilterInt(filters pubsub.FilterMap, kind pubsub.MessageFilterKind) (int64, bool) {
+	v := reflect.ValueOf(filters[kind])
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), true
+	}
+
+	return 0, false
+}
+
 func NewTaskpool(conf *nbhttp.Config) *taskpool.MixedPool {
 	if conf.MessageHandlerPoolSize <= 0 {
 		conf.MessageHandlerPoolSize = runtime.NumCPU() * 1024
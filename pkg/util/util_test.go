@@ -0,0 +1,108 @@
+package util
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestGetDomain(t *testing.T) {
+	tests := []struct {
+		url    string
+		domain string
+	}{
+		{"https://bbc.co.uk/iplayer", "bbc.co.uk"},
+		{"https://www.bbc.co.uk/iplayer", "bbc.co.uk"},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ", "youtube.com"},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "youtube.com"},
+		{"https://youtu.be/dQw4w9WgXcQ", "youtu.be"},
+		{"https://www.youtu.be/dQw4w9WgXcQ", "youtu.be"},
+		{"https://WWW.Example.COM./", "example.com"},
+		{"https://example.com:8443/", "example.com"},
+		{"http://93.184.216.34/video.mp4", "93.184.216.34"},
+		{"http://93.184.216.34:8080/video.mp4", "93.184.216.34"},
+		{"http://[2001:db8::1]:8080/video.mp4", "2001:db8::1"},
+		{"http://localhost:3000/", "localhost"},
+		{"http://co.uk/", "co.uk"},
+	}
+
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		domain := GetDomain(u)
+
+		if domain != test.domain {
+			t.Errorf("%v: expected %q, got %q", test.url, test.domain, domain)
+		}
+	}
+}
+
+func TestCanonicalizeURL(t *testing.T) {
+	tests := []struct {
+		url       string
+		canonical string
+	}{
+		// tracking parameters
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&utm_source=twitter&utm_medium=social", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&UTM_Campaign=x", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"https://youtu.be/dQw4w9WgXcQ?si=AbCdEf", "https://youtu.be/dQw4w9WgXcQ"},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&feature=share", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"https://example.com/video?fbclid=IwAR0&id=1", "https://example.com/video?id=1"},
+		{"https://example.com/video?utm_source=a&si=b&feature=c&fbclid=d", "https://example.com/video"},
+		// parameters are sorted
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PL1", "https://www.youtube.com/watch?list=PL1&v=dQw4w9WgXcQ"},
+		{"https://www.youtube.com/watch?list=PL1&v=dQw4w9WgXcQ", "https://www.youtube.com/watch?list=PL1&v=dQw4w9WgXcQ"},
+		// timestamps are kept
+		{"https://youtu.be/dQw4w9WgXcQ?t=42&si=AbCdEf", "https://youtu.be/dQw4w9WgXcQ?t=42"},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ#t=1m30s", "https://www.youtube.com/watch?v=dQw4w9WgXcQ#t=1m30s"},
+		{"https://example.com/page#comments", "https://example.com/page"},
+		// scheme, host & ports
+		{"HTTPS://WWW.YouTube.com/watch?v=dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"https://example.com:443/video", "https://example.com/video"},
+		{"http://example.com:80/video", "http://example.com/video"},
+		{"http://example.com:8080/video", "http://example.com:8080/video"},
+		{"https://example.com:80/video", "https://example.com:80/video"},
+		{"https://example.com", "https://example.com/"},
+		// bare ips
+		{"http://93.184.216.34/video.mp4", "http://93.184.216.34/video.mp4"},
+		{"http://93.184.216.34:80/video.mp4", "http://93.184.216.34/video.mp4"},
+		{"http://93.184.216.34:8080/video.mp4?utm_source=a", "http://93.184.216.34:8080/video.mp4"},
+		{"http://[2001:db8::1]:80/video.mp4", "http://[2001:db8::1]/video.mp4"},
+		{"http://[2001:db8::1]:8080/video.mp4", "http://[2001:db8::1]:8080/video.mp4"},
+	}
+
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		canonical := CanonicalizeURL(u).String()
+
+		if canonical != test.canonical {
+			t.Errorf("%v: expected %q, got %q", test.url, test.canonical, canonical)
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	a, err := NormalizeURL("  https://youtu.be/dQw4w9WgXcQ?si=AbCdEf ")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NormalizeURL("https://YOUTU.BE:443/dQw4w9WgXcQ?utm_source=share")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a != b {
+		t.Fatalf("expected equivalent urls to match, got %q and %q", a, b)
+	}
+}